@@ -23,6 +23,7 @@ interface Donation {
   message: string;
   txHash: string;
   status: string;
+  categoryId: number;
   category: string;
   createdAt: string;
   charity: {
@@ -41,7 +41,7 @@ export default function MilestoneManager({ approval, isOwner, onUpdate }: Milest
   const [selectedMilestone, setSelectedMilestone] = useState<Milestone | null>(null);
   const [verificationProof, setVerificationProof] = useState('');
   const [verificationComments, setVerificationComments] = useState('');
-  const [verificationStatus, setVerificationStatus] = useState('approved');
+  const [verificationStatus, setVerificationStatus] = useState<'approved' | 'rejected'>('approved');
   
   // New milestone form state
   const [milestoneName, setMilestoneName] = useState('');
@@ -154,11 +154,11 @@ export default function MilestoneManager({ approval, isOwner, onUpdate }: Milest
   
   const handleReleaseFunds = async (milestone: Milestone) => {
     try {
-      await fundManagementService.releaseMilestoneFunds(milestone.id.toString());
+      const result = await fundManagementService.releaseMilestoneFunds(milestone.id.toString());
       
       // Reload milestones
       loadMilestones();
-      toast.success('Funds released for milestone');
+      toast.success(result.approval ? 'Release waits for cosigner signatures' : 'Funds released for milestone');
       
       if (onUpdate) onUpdate();
     } catch (error) {
@@ -172,7 +172,7 @@ export default function MilestoneManager({ approval, isOwner, onUpdate }: Milest
     try {
       const result = await fundManagementService.refundUnspentFunds(approval.ID.toString());
       
-      toast.success(`Successfully refunded ${result.refundAmount.toFixed(2)} XLM of unspent funds`);
+      toast.success(`Successfully refunded ${result.refundAmount} XLM of unspent funds`);
       
       if (onUpdate) onUpdate();
     } catch (error) {
@@ -185,6 +185,8 @@ export default function MilestoneManager({ approval, isOwner, onUpdate }: Milest
     switch (status) {
       case 'pending':
         return 'bg-yellow-100 text-yellow-800';
+      case 'overdue':
+        return 'bg-orange-100 text-orange-800';
       case 'completed':
         return 'bg-blue-100 text-blue-800';
       case 'verified':
@@ -319,7 +321,7 @@ export default function MilestoneManager({ approval, isOwner, onUpdate }: Milest
                 </div>
                 <div className="flex flex-col items-end gap-2">
                   {/* Owner actions */}
-                  {isOwner && milestone.status === 'pending' && (
+                  {isOwner && (milestone.status === 'pending' || milestone.status === 'overdue') && (
                     <Button 
                       size="sm" 
                       variant="outline"
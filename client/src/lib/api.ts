@@ -14,7 +14,7 @@ const api = axios.create({
 api.interceptors.request.use(async (config) => {
   const user = auth.currentUser;
   if (user) {
-    config.headers.Authorization = `Bearer ${user.uid}`;
+    config.headers.Authorization = `Bearer ${await user.getIdToken()}`;
   }
   return config;
 });
@@ -82,7 +82,7 @@ interface Charity {
   owner_id: string
   createdAt: string
   updatedAt: string
-  cosigners?: Array<{id: string, email: string, userId: string, isPrimary: boolean}>
+  cosigners?: Cosigner[]
 }
 
 interface CharityWithBalance extends Charity {
@@ -145,7 +145,7 @@ const samplePendingApprovals: TransactionApproval[] = [
 ];
 
 // Fund Management Component
-const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBalance, charityCosigners: Record<string, Array<{id: string, email: string, userId: string, isPrimary: boolean}>> }) => {
+const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBalance, charityCosigners: Record<string, Cosigner[]> }) => {
   
   const { user } = useAuth() as { user: User | null };
   const [activeTab, setActiveTab] = useState('budget');
@@ -157,7 +157,7 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
   const [isLoading, setIsLoading] = useState(true);
   const [newCosignerEmail, setNewCosignerEmail] = useState('');
   const [newBudgetCategory, setNewBudgetCategory] = useState({ name: '', allocation: 0 });
-  const [newApproval, setNewApproval] = useState({ amount: '', description: '', category: '' });
+  const [newApproval, setNewApproval] = useState({ amount: '', description: '', categoryId: '' });
   const [showApprovalDialog, setShowApprovalDialog] = useState(false);
   const [showCosignerDialog, setShowCosignerDialog] = useState(false);
   const [showBudgetDialog, setShowBudgetDialog] = useState(false);
@@ -255,7 +255,7 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
   const handleApprove = async (approvalId: string) => {
     try {
       console.log(approvalId);
-      const updatedApproval = await fundManagementService.addApprovalSignature(approvalId, user?.email || '');
+      const updatedApproval = await fundManagementService.addApprovalSignature(approvalId);
       
       // Update local state
       setPendingApprovals(prevApprovals => 
@@ -282,13 +282,13 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
       );
       
       // If there's a category, update the budget
-      if (executedApproval.category) {
+      if (executedApproval.categoryId) {
         setBudgetCategories(prev => 
           prev.map(category => {
-            if (category.name === executedApproval.category) {
+            if (category.ID === executedApproval.categoryId) {
               return {
                 ...category,
-                spent: category.spent + parseFloat(executedApproval.amount)
+                spent: (parseFloat(category.spent) + parseFloat(executedApproval.amount)).toFixed(7)
               };
             }
             return category;
@@ -373,11 +373,11 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
         charity.ID,
         newApproval.amount,
         newApproval.description,
-        newApproval.category,
+        newApproval.categoryId ? Number(newApproval.categoryId) : undefined,
       );
       
       setPendingApprovals(prev => [...prev, approval]);
-      setNewApproval({ amount: '', description: '', category: '' });
+      setNewApproval({ amount: '', description: '', categoryId: '' });
       setShowApprovalDialog(false);
       toast.success('Transaction approval created successfully');
     } catch (error) {
@@ -388,13 +388,13 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
   
   // Calculate total budget and spent amounts
   const totalBalance = parseFloat(charity.balance);
-  const totalSpent = budgetCategories.reduce((sum, category) => sum + category.spent, 0);
+  const totalSpent = budgetCategories.reduce((sum, category) => sum + parseFloat(category.spent), 0);
   
   // Sample cosigners to demonstrate UI
-  const sampleCosigners = [
-    { id: "1", email: "john@example.com", userId: "1", isPrimary: true },
-    { id: "2", email: "sarah@example.com", userId: "2", isPrimary: false },
-    { id: "3", email: "michael@example.com", userId: "3", isPrimary: false },
+  const sampleCosigners: Cosigner[] = [
+    { id: "1", email: "john@example.com", userId: 1, publicKey: "", isPrimary: true, status: "active", inviteExpiresAt: "" },
+    { id: "2", email: "sarah@example.com", userId: 2, publicKey: "", isPrimary: false, status: "active", inviteExpiresAt: "" },
+    { id: "3", email: "michael@example.com", userId: 3, publicKey: "", isPrimary: false, status: "active", inviteExpiresAt: "" },
   ];
   
   const displayCosigners = cosigners.length > 0 ? cosigners : sampleCosigners;
@@ -448,7 +448,7 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
               
               <div className="grid gap-3">
                 {budgetCategories.map(category => (
-                  <div key={category.id} className="space-y-1">
+                  <div key={category.ID} className="space-y-1">
                     <div className="flex justify-between items-center text-sm">
                       <div className="flex items-center">
                         <span>{category.name}</span>
@@ -459,7 +459,7 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
                       </div>
                     </div>
                     <Progress 
-                      value={(category.spent / (totalBalance * (category.allocation / 100))) * 100} 
+                      value={(parseFloat(category.spent) / (totalBalance * (category.allocation / 100))) * 100} 
                       className="h-2"
                     />
                   </div>
@@ -774,12 +774,12 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
               <select
                 id="category"
                 className="flex h-10 w-full rounded-md border border-input bg-background px-3 py-2 text-sm ring-offset-background focus:outline-none focus:ring-2 focus:ring-ring focus:ring-offset-2"
-                value={newApproval.category}
-                onChange={e => setNewApproval(prev => ({ ...prev, category: e.target.value }))}
+                value={newApproval.categoryId}
+                onChange={e => setNewApproval(prev => ({ ...prev, categoryId: e.target.value }))}
               >
                 <option value="">Select a category</option>
                 {budgetCategories.map(category => (
-                  <option key={category.id} value={category.name}>
+                  <option key={category.ID} value={category.ID}>
                     {category.name}
                   </option>
                 ))}
@@ -799,7 +799,7 @@ const FundManagement = ({ charity, charityCosigners }: { charity: CharityWithBal
 export default function CharitiesPage() {
   const { user } = useAuth() as { user: User | null }
   const [charities, setCharities] = useState<CharityWithBalance[]>([])
-  const [charityCosigners, setCharityCosigners] = useState<Record<string, Array<{id: string, email: string, userId: string, isPrimary: boolean}>>>({})
+  const [charityCosigners, setCharityCosigners] = useState<Record<string, Cosigner[]>>({})
   const [isDialogOpen, setIsDialogOpen] = useState(false)
   const [isLoading, setIsLoading] = useState(false)
   const [formData, setFormData] = useState({
@@ -3,49 +3,220 @@ import { api } from '@/lib/api';
 export interface Cosigner {
   id: string;
   email: string;
-  userId: string;
+  userId: number;
+  publicKey: string;
   isPrimary: boolean;
+  status: 'pending' | 'active';
+  inviteExpiresAt: string;
+  acceptedAt?: string;
 }
 
 export interface BudgetCategory {
-  id: string;
+  ID: number;
+  periodId: number;
   name: string;
   allocation: number;
-  spent: number;
+  spent: string;
 }
 
 export interface TransactionApproval {
   ID: number;
   charityId: number;
   amount: string;
+  assetCode: string;
+  assetIssuer?: string;
   description: string;
+  categoryId: number;
   category: string;
   requestedById: string;
   requiredSignatures: number;
   currentSignatures: number;
-  status: string;
+  status: ApprovalStatus;
   txHash?: string;
+  executedAt?: string;
+  overBudget: boolean;
+  escrowed: boolean;
+  escrowAddress?: string;
+  refundTxHash?: string;
+  expiresAt?: string;
   createdAt: string;
 }
 
+export type ApprovalStatus = 'pending' | 'approved' | 'rejected' | 'cancelled' | 'expired' | 'executing' | 'executed' | 'refunded';
+
+export interface FundAttribution {
+  ID: number;
+  approvalId: number;
+  donationId: number;
+  amount: string;
+}
+
+export interface Refund {
+  ID: number;
+  approvalId: number;
+  donationId: number;
+  donorId: string;
+  destination: string;
+  amount: string;
+  assetCode: string;
+  assetIssuer?: string;
+  txHash: string;
+  CreatedAt: string;
+}
+
+export interface RefundProposal {
+  ID: number;
+  approvalId: number;
+  milestoneId: number;
+  amount: string;
+  reason: string;
+  status: 'open' | 'accepted' | 'dismissed';
+  decidedById?: string;
+  decidedAt?: string;
+  CreatedAt: string;
+}
+
+export interface Notification {
+  ID: number;
+  event: string;
+  message: string;
+  link?: string;
+  readAt?: string;
+  CreatedAt: string;
+}
+
+export interface BudgetLine {
+  categoryId: number;
+  name: string;
+  allocation: number;
+  budget: string;
+  actual: string;
+  variance: string;
+  approvals: number;
+}
+
+export interface BudgetReport {
+  charityId: number;
+  periodId: number;
+  period: string;
+  from: string;
+  to: string;
+  status: FiscalPeriod['status'];
+  budget: string;
+  donations: string;
+  allocated: number;
+  categories: BudgetLine[];
+  unbudgeted: string;
+}
+
+export interface FiscalPeriod {
+  ID: number;
+  charityId: number;
+  name: string;
+  startMonth: string;
+  endMonth: string;
+  budget: string;
+  status: 'open' | 'closed';
+  closedAt?: string;
+  closedById?: string;
+}
+
+export interface BudgetVersion {
+  ID: number;
+  periodId: number;
+  version: number;
+  budget: string;
+  note: string;
+  createdById: string;
+  lines: { categoryId: number; name: string; allocation: number }[];
+  CreatedAt: string;
+}
+
+export interface BudgetVersionComparison {
+  from: number;
+  to: number;
+  fromBudget: string;
+  toBudget: string;
+  categories: {
+    categoryId: number;
+    name: string;
+    fromAllocation: number;
+    toAllocation: number;
+    fromBudget: string;
+    toBudget: string;
+    status: 'added' | 'removed' | 'changed' | 'unchanged';
+  }[];
+}
+
+export interface StatusTransition {
+  entity: 'transaction_approval' | 'milestone';
+  entityId: number;
+  fromStatus: string;
+  toStatus: string;
+  actorId: string;
+  reason?: string;
+  CreatedAt: string;
+}
+
+export interface MilestoneSummary {
+  approvalId: number;
+  assetCode: string;
+  assetIssuer?: string;
+  amount: string;
+  allocated: string;
+  released: string;
+  remaining: string;
+  unallocated: string;
+  milestoneCount: number;
+}
+
 export interface Milestone {
   id: number;
   name: string;
   description: string;
   approvalId: number;
   amount: string;
+  assetCode: string;
+  assetIssuer?: string;
   dueDate: string;
   completionDate?: string;
   status: string;
   verificationProof?: string;
+  completedById?: string;
+  verificationRound: number;
+  releaseTxHash: string;
 }
 
 export interface MilestoneVerification {
   id: number;
   milestoneId: number;
+  round: number;
   verifierId: string;
+  independent: boolean;
   comments: string;
-  status: string;
+  status: 'approved' | 'rejected';
+}
+
+export interface MilestoneEvidence {
+  ID: number;
+  milestoneId: number;
+  uploadedById: string;
+  fileName: string;
+  contentType: string;
+  size: number;
+  sha256: string;
+  anchorTxHash?: string;
+  anchoredAt?: string;
+  CreatedAt: string;
+}
+
+export interface VerificationTally {
+  round: number;
+  rule: { quorum: number; requireIndependent: boolean };
+  approvals: number;
+  rejections: number;
+  independentApprovals: number;
+  outcome: '' | 'verified' | 'pending';
 }
 
 export class FundManagementService {
@@ -61,20 +232,42 @@ export class FundManagementService {
   }
 
   // Cosigner Management
-  async addCosigner(charityId: string, email: string, userId?: string, isPrimary: boolean = false): Promise<Cosigner> {
+  async addCosigner(charityId: string, email: string, isPrimary: boolean = false): Promise<Cosigner & { inviteToken: string }> {
     try {
       const response = await api.post(`/charities/${charityId}/cosigners`, {
         email,
-        userId,
         isPrimary
       });
-      return response.data.data;
+      return { ...response.data.data, inviteToken: response.data.inviteToken };
     } catch (error) {
       console.error('Error adding cosigner:', error);
       throw new Error('Failed to add cosigner');
     }
   }
 
+  async reissueCosignerInvite(charityId: string, cosignerId: string): Promise<string> {
+    try {
+      const response = await api.post(`/charities/${charityId}/cosigners/${cosignerId}/invite`);
+      return response.data.inviteToken;
+    } catch (error) {
+      console.error('Error reissuing cosigner invitation:', error);
+      throw new Error('Failed to reissue cosigner invitation');
+    }
+  }
+
+  async acceptCosignerInvite(token: string, publicKey?: string): Promise<Cosigner> {
+    try {
+      const response = await api.post('/charities/cosigners/accept', {
+        token,
+        publicKey
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error accepting cosigner invitation:', error);
+      throw new Error('Failed to accept cosigner invitation');
+    }
+  }
+
   async removeCosigner(charityId: string, cosignerId: string): Promise<void> {
     try {
       await api.delete(`/charities/${charityId}/cosigners/${cosignerId}`);
@@ -99,11 +292,12 @@ export class FundManagementService {
   }
 
   // Budget Management
-  async addBudgetCategory(charityId: string, name: string, allocation: number): Promise<BudgetCategory> {
+  async addBudgetCategory(charityId: string, name: string, allocation: number, periodId?: number): Promise<BudgetCategory> {
     try {
       const response = await api.post(`/charities/${charityId}/budget`, {
         name,
-        allocation
+        allocation,
+        periodId
       });
       return response.data.data;
     } catch (error) {
@@ -134,10 +328,102 @@ export class FundManagementService {
     }
   }
 
+  async getBudgetReport(charityId: string, periodId?: number): Promise<BudgetReport> {
+    try {
+      const response = await api.get(`/charities/${charityId}/budget/report`, {
+        params: periodId ? { periodId } : {}
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching budget report:', error);
+      throw new Error('Failed to fetch budget report');
+    }
+  }
+
+  // Fiscal Periods
+  async getFiscalPeriods(charityId: string): Promise<FiscalPeriod[]> {
+    try {
+      const response = await api.get(`/charities/${charityId}/periods`);
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching fiscal periods:', error);
+      throw new Error('Failed to fetch fiscal periods');
+    }
+  }
+
+  async createFiscalPeriod(charityId: string, name: string, startMonth: string, endMonth: string, budget: string): Promise<FiscalPeriod> {
+    try {
+      const response = await api.post(`/charities/${charityId}/periods`, {
+        name,
+        startMonth,
+        endMonth,
+        budget
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error creating fiscal period:', error);
+      throw new Error('Failed to create fiscal period');
+    }
+  }
+
+  async updateFiscalPeriod(charityId: string, periodId: number, changes: { name?: string, budget?: string }): Promise<FiscalPeriod> {
+    try {
+      const response = await api.patch(`/charities/${charityId}/periods/${periodId}`, changes);
+      return response.data.data;
+    } catch (error) {
+      console.error('Error updating fiscal period:', error);
+      throw new Error('Failed to update fiscal period');
+    }
+  }
+
+  async closeFiscalPeriod(charityId: string, periodId: number): Promise<FiscalPeriod> {
+    try {
+      const response = await api.post(`/charities/${charityId}/periods/${periodId}/close`, {});
+      return response.data.data;
+    } catch (error) {
+      console.error('Error closing fiscal period:', error);
+      throw new Error('Failed to close fiscal period');
+    }
+  }
+
+  async rollForwardFiscalPeriod(charityId: string, periodId: number, name?: string): Promise<FiscalPeriod> {
+    try {
+      const response = await api.post(`/charities/${charityId}/periods/${periodId}/roll-forward`, { name });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error rolling fiscal period forward:', error);
+      throw new Error('Failed to roll fiscal period forward');
+    }
+  }
+
+  async getBudgetVersions(charityId: string, periodId: number): Promise<BudgetVersion[]> {
+    try {
+      const response = await api.get(`/charities/${charityId}/periods/${periodId}/versions`);
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching budget versions:', error);
+      throw new Error('Failed to fetch budget versions');
+    }
+  }
+
+  async compareBudgetVersions(charityId: string, periodId: number, from?: number, to?: number): Promise<BudgetVersionComparison> {
+    try {
+      const response = await api.get(`/charities/${charityId}/periods/${periodId}/versions/compare`, {
+        params: { from, to }
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error comparing budget versions:', error);
+      throw new Error('Failed to compare budget versions');
+    }
+  }
+
   // Transaction Approval Management
-  async getPendingApprovals(charityId: string): Promise<TransactionApproval[]> {
+  async getPendingApprovals(charityId: string, statuses: ApprovalStatus[] = ['pending']): Promise<TransactionApproval[]> {
     try {
-      const response = await api.get(`/charities/${charityId}/approvals`);
+      const response = await api.get(`/charities/${charityId}/approvals`, {
+        params: { status: statuses.join(',') }
+      });
       return response.data.data;
     } catch (error) {
       console.error('Error fetching pending approvals:', error);
@@ -145,13 +431,14 @@ export class FundManagementService {
     }
   }
 
-  async createTransactionApproval(charityId: string, amount: string, description: string, category?: string): Promise<TransactionApproval> {
+  async createTransactionApproval(charityId: string, amount: string, description: string, categoryId?: number, escrow: boolean = false): Promise<TransactionApproval> {
     try {
       const response = await api.post(`/charities/${charityId}/approvals`, {
         amount,
         description,
-        category,
-        charityId
+        categoryId,
+        charityId,
+        escrow
       });
       return response.data.data;
     } catch (error) {
@@ -160,10 +447,11 @@ export class FundManagementService {
     }
   }
 
-  async addApprovalSignature(approvalId: string, email: string): Promise<TransactionApproval> {
+  // The signer is the signed-in user; cosigners pass their signature over the envelope
+  async addApprovalSignature(approvalId: string, signature?: string): Promise<TransactionApproval> {
     try {
       const response = await api.post(`/charities/approvals/${approvalId}/sign`, {
-        email: email
+        signature
       });
       return response.data.data;
     } catch (error) {
@@ -172,6 +460,48 @@ export class FundManagementService {
     }
   }
 
+  async rejectApproval(approvalId: string, reason: string): Promise<TransactionApproval> {
+    try {
+      const response = await api.post(`/charities/approvals/${approvalId}/reject`, {
+        reason
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error rejecting transaction approval:', error);
+      throw new Error('Failed to reject transaction approval');
+    }
+  }
+
+  async cancelApproval(approvalId: string): Promise<TransactionApproval> {
+    try {
+      const response = await api.post(`/charities/approvals/${approvalId}/cancel`);
+      return response.data.data;
+    } catch (error) {
+      console.error('Error cancelling transaction approval:', error);
+      throw new Error('Failed to cancel transaction approval');
+    }
+  }
+
+  async getApprovalHistory(approvalId: string): Promise<StatusTransition[]> {
+    try {
+      const response = await api.get(`/charities/approvals/${approvalId}/history`);
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching approval history:', error);
+      throw new Error('Failed to fetch approval history');
+    }
+  }
+
+  async getApprovalFunding(approvalId: string): Promise<{attributions: FundAttribution[], refunds: Refund[]}> {
+    try {
+      const response = await api.get(`/charities/approvals/${approvalId}/funding`);
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching approval funding:', error);
+      throw new Error('Failed to fetch approval funding');
+    }
+  }
+
   async executeApproval(approvalId: string): Promise<TransactionApproval> {
     try {
       const response = await api.post(`/charities/approvals/${approvalId}/execute`, {});
@@ -193,6 +523,16 @@ export class FundManagementService {
     }
   }
 
+  async getMilestoneSummary(approvalId: string): Promise<MilestoneSummary> {
+    try {
+      const response = await api.get(`/charities/approvals/${approvalId}/milestones/summary`);
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching milestone summary:', error);
+      throw new Error('Failed to fetch milestone summary');
+    }
+  }
+
   async createMilestone(approvalId: string, name: string, description: string, amount: string, dueDate: Date): Promise<Milestone> {
     try {
       const response = await api.post(`/charities/approvals/${approvalId}/milestones`, {
@@ -220,7 +560,7 @@ export class FundManagementService {
     }
   }
 
-  async verifyMilestone(milestoneId: string, comments: string, status: string): Promise<{milestone: Milestone, verification: MilestoneVerification}> {
+  async verifyMilestone(milestoneId: string, comments: string, status: 'approved' | 'rejected'): Promise<{milestone: Milestone, verification: MilestoneVerification, tally: VerificationTally}> {
     try {
       const response = await api.patch(`/charities/milestones/${milestoneId}/verify`, {
         comments,
@@ -233,10 +573,48 @@ export class FundManagementService {
     }
   }
 
-  async releaseMilestoneFunds(milestoneId: string): Promise<{milestone: Milestone, txHash: string}> {
+  // Milestone Evidence
+  async uploadMilestoneEvidence(milestoneId: string, file: File): Promise<MilestoneEvidence> {
     try {
-      const response = await api.post(`/charities/milestones/${milestoneId}/release`, {});
+      const form = new FormData();
+      form.append('file', file);
+      const response = await api.post(`/charities/milestones/${milestoneId}/evidence`, form, {
+        headers: { 'Content-Type': 'multipart/form-data' }
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error uploading milestone evidence:', error);
+      throw new Error('Failed to upload milestone evidence');
+    }
+  }
+
+  async getMilestoneEvidence(milestoneId: string): Promise<MilestoneEvidence[]> {
+    try {
+      const response = await api.get(`/charities/milestones/${milestoneId}/evidence`);
       return response.data.data;
+    } catch (error) {
+      console.error('Error fetching milestone evidence:', error);
+      throw new Error('Failed to fetch milestone evidence');
+    }
+  }
+
+  async downloadMilestoneEvidence(milestoneId: string, evidenceId: number): Promise<Blob> {
+    try {
+      const response = await api.get(`/charities/milestones/${milestoneId}/evidence/${evidenceId}`, {
+        responseType: 'blob'
+      });
+      return response.data;
+    } catch (error) {
+      console.error('Error downloading milestone evidence:', error);
+      throw new Error('Failed to download milestone evidence');
+    }
+  }
+
+  // Escrowed funds are released by a release approval, which is returned while it waits for cosigners
+  async releaseMilestoneFunds(milestoneId: string): Promise<{milestone?: Milestone, txHash?: string, approval?: TransactionApproval}> {
+    try {
+      const response = await api.post(`/charities/milestones/${milestoneId}/release`, {});
+      return response.data.data ?? { approval: response.data.approval };
     } catch (error) {
       console.error('Error releasing milestone funds:', error);
       throw new Error('Failed to release milestone funds');
@@ -244,7 +622,7 @@ export class FundManagementService {
   }
 
   // Automatic Fund Returns
-  async refundUnspentFunds(approvalId: string): Promise<{refundAmount: number, approval: TransactionApproval}> {
+  async refundUnspentFunds(approvalId: string): Promise<{refundAmount: string, refunds: Refund[], approval: TransactionApproval}> {
     try {
       const response = await api.post(`/charities/approvals/${approvalId}/refund`, {});
       return response.data;
@@ -253,4 +631,59 @@ export class FundManagementService {
       throw new Error('Failed to refund unspent funds');
     }
   }
-} 
\ No newline at end of file
+
+  async getRefundProposals(charityId: string, status?: RefundProposal['status']): Promise<RefundProposal[]> {
+    try {
+      const response = await api.get(`/charities/${charityId}/refund-proposals`, {
+        params: status ? { status } : {}
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching refund proposals:', error);
+      throw new Error('Failed to fetch refund proposals');
+    }
+  }
+
+  async acceptRefundProposal(proposalId: string): Promise<{data: RefundProposal, refundAmount: string, refunds: Refund[]}> {
+    try {
+      const response = await api.post(`/charities/refund-proposals/${proposalId}/accept`, {});
+      return response.data;
+    } catch (error) {
+      console.error('Error accepting refund proposal:', error);
+      throw new Error('Failed to accept refund proposal');
+    }
+  }
+
+  async dismissRefundProposal(proposalId: string): Promise<RefundProposal> {
+    try {
+      const response = await api.post(`/charities/refund-proposals/${proposalId}/dismiss`, {});
+      return response.data.data;
+    } catch (error) {
+      console.error('Error dismissing refund proposal:', error);
+      throw new Error('Failed to dismiss refund proposal');
+    }
+  }
+
+  // Notifications
+  async getNotifications(unreadOnly: boolean = false): Promise<Notification[]> {
+    try {
+      const response = await api.get('/notifications', {
+        params: unreadOnly ? { unread: true } : {}
+      });
+      return response.data.data;
+    } catch (error) {
+      console.error('Error fetching notifications:', error);
+      throw new Error('Failed to fetch notifications');
+    }
+  }
+
+  async markNotificationRead(notificationId: number): Promise<Notification> {
+    try {
+      const response = await api.patch(`/notifications/${notificationId}/read`, {});
+      return response.data.data;
+    } catch (error) {
+      console.error('Error marking notification as read:', error);
+      throw new Error('Failed to mark notification as read');
+    }
+  }
+}
@@ -1,4 +1,10 @@
-export type UserRole = 'USER' | 'CHARITY_OWNER';
+export type UserRole =
+  | 'USER'
+  | 'CHARITY_OWNER'
+  | 'ADMIN'
+  | 'COMPLIANCE_OFFICER'
+  | 'AUDITOR'
+  | 'MILESTONE_VERIFIER';
 
 export interface User {
   ID: string;
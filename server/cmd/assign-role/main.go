@@ -0,0 +1,60 @@
+// Command assign-role sets the role of a user stored in cleargive.db.
+//
+// Roles other than USER and CHARITY_OWNER cannot be chosen at signup and are
+// normally assigned by an admin through the API. Use this command to appoint
+// the first admin. Run it from the server directory:
+//
+//	go run ./cmd/assign-role -email admin@example.org -role ADMIN
+package main
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/policy"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the user")
+	role := flag.String("role", "", "role to assign")
+	flag.Parse()
+
+	if *email == "" || !policy.IsKnownRole(*role) {
+		log.Fatal("Usage: assign-role -email <email> -role <USER|CHARITY_OWNER|ADMIN|COMPLIANCE_OFFICER|AUDITOR|MILESTONE_VERIFIER>")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found")
+	}
+
+	config.ConnectDB()
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("email = ?", *email).First(&user).Error; err != nil {
+			return fmt.Errorf("user %s: %w", *email, err)
+		}
+
+		previousRole := user.Role
+		if err := tx.Model(&user).Update("role", *role).Error; err != nil {
+			return err
+		}
+		log.Printf("user %d: role changed from %s to %s", user.ID, previousRole, *role)
+
+		return tx.Create(&models.AuditRecord{
+			UserID:    user.FirebaseID,
+			Event:     "Role Assigned",
+			Details:   fmt.Sprintf("Role changed from %s to %s from the command line", previousRole, *role),
+			Timestamp: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		log.Fatal("Role assignment failed: ", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Command encrypt-secrets encrypts the Stellar secret keys stored in cleargive.db.
+//
+// Plaintext secrets written before encryption was introduced are encrypted, and secrets
+// encrypted with a master key other than SECRET_ACTIVE_KEY_ID are re-encrypted, which
+// completes a master key rotation. The server refuses to use plaintext secrets, so run it
+// before deploying. The database is not migrated. Run it from the server directory:
+//
+//	go run ./cmd/encrypt-secrets [-dry-run]
+package main
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"flag"
+	"log"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the rows that would change without writing them")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("Warning: .env file not found")
+	}
+
+	activeKeyID, err := services.ActiveSecretKeyID()
+	if err != nil {
+		log.Fatal("Could not load master keys: ", err)
+	}
+
+	db, err := config.OpenDB(filepath.Join("database", "cleargive.db"))
+	if err != nil {
+		log.Fatal("Failed to connect to database: ", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var charities []models.Charity
+		if err := tx.Where("wallet_secret <> ''").Find(&charities).Error; err != nil {
+			return err
+		}
+		for _, charity := range charities {
+			updated, changed, err := migrateSecret(services.CharityWalletSecret(&charity), activeKeyID)
+			if err != nil {
+				log.Printf("charity %d: %v", charity.ID, err)
+				return err
+			}
+			if !changed {
+				continue
+			}
+			log.Printf("charity %d: wallet secret updated", charity.ID)
+			if !*dryRun {
+				if err := tx.Model(&charity).Update("wallet_secret", updated).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		var users []models.User
+		if err := tx.Where("secret_key <> ''").Find(&users).Error; err != nil {
+			return err
+		}
+		for _, user := range users {
+			updated, changed, err := migrateSecret(services.UserWalletSecret(&user), activeKeyID)
+			if err != nil {
+				log.Printf("user %d: %v", user.ID, err)
+				return err
+			}
+			if !changed {
+				continue
+			}
+			log.Printf("user %d: wallet secret updated", user.ID)
+			if !*dryRun {
+				if err := tx.Model(&user).Update("secret_key", updated).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatal("Migration failed, no rows were changed: ", err)
+	}
+
+	log.Println("Secret migration complete")
+}
+
+// migrateSecret returns the stored secret encrypted under the active master key and bound to its row
+func migrateSecret(secret services.Secret, activeKeyID string) (string, bool, error) {
+	if services.SecretIsCurrent(secret.Stored, activeKeyID) {
+		return secret.Stored, false, nil
+	}
+
+	updated, err := services.ReencryptSecret(secret)
+	if err != nil {
+		return "", false, err
+	}
+	return updated, true, nil
+}
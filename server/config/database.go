@@ -2,9 +2,12 @@ package config
 
 import (
 	"cleargive/server/models"
+	"cleargive/server/services"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -13,8 +16,6 @@ import (
 var DB *gorm.DB
 
 func ConnectDB() {
-	var err error
-
 	// Create database directory if it doesn't exist
 	dbDir := "database"
 	if err := os.MkdirAll(dbDir, 0755); err != nil {
@@ -23,16 +24,217 @@ func ConnectDB() {
 
 	// Connect to SQLite database
 	dbPath := filepath.Join(dbDir, "cleargive.db")
-	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	db, err := OpenDB(dbPath)
 	if err != nil {
 		log.Fatal("Failed to connect to database: ", err)
 	}
+	DB = db
 
 	log.Printf("Connected Successfully to SQLite Database at %s", dbPath)
 
+	if err := MigrateDB(DB); err != nil {
+		log.Fatal("Failed to migrate database: ", err)
+	}
+}
+
+// OpenDB opens the SQLite database at dbPath without migrating it
+func OpenDB(dbPath string) (*gorm.DB, error) {
+	// Writers wait for the lock instead of failing, and transactions take the
+	// write lock up front so concurrent read-then-write transactions serialize
+	dsn := dbPath + "?_busy_timeout=5000&_txlock=immediate"
+	return gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true})
+}
+
+// MigrateDB brings the schema and data of db up to date
+func MigrateDB(db *gorm.DB) error {
+	if err := migrateCosignerUsers(db); err != nil {
+		return fmt.Errorf("cosigners: %w", err)
+	}
+
+	if err := dropDonationTxHashUnique(db); err != nil {
+		return fmt.Errorf("donations: %w", err)
+	}
+
+	rebuildTotals, err := dropCategoryNameTotals(db)
+	if err != nil {
+		return fmt.Errorf("charity totals: %w", err)
+	}
+
 	// Auto Migrate Models
-	err = DB.AutoMigrate(&models.Donation{}, &models.Charity{}, &models.BudgetCategory{}, &models.TransactionApproval{}, &models.ApprovalSignature{}, &models.Cosigner{}, &models.Milestone{}, &models.MilestoneVerification{})
+	err = db.AutoMigrate(&models.Donation{}, &models.Charity{}, &models.BudgetCategory{}, &models.TransactionApproval{}, &models.ApprovalSignature{}, &models.ApprovalRejection{}, &models.Cosigner{}, &models.Milestone{}, &models.MilestoneVerification{}, &models.MilestoneEvidence{}, &models.IngestCursor{}, &models.CharityTotal{}, &models.CharityAsset{}, &models.StatusTransition{}, &models.FundAttribution{}, &models.Refund{}, &models.RefundProposal{}, &models.Notification{}, &models.FiscalPeriod{}, &models.BudgetVersion{}, &models.BudgetVersionLine{})
 	if err != nil {
-		log.Fatal("Failed to migrate database: ", err)
+		return err
+	}
+
+	// Cosigners bound to a user before invitations existed are already active
+	if err := db.Exec("UPDATE cosigners SET status = ? WHERE status = ? AND user_id > 0", models.CosignerStatusActive, models.CosignerStatusPending).Error; err != nil {
+		return fmt.Errorf("cosigners: %w", err)
+	}
+
+	// Donations from before payment IDs are found by their transaction, which needs an empty ID
+	if err := db.Exec("UPDATE donations SET payment_id = '' WHERE payment_id IS NULL").Error; err != nil {
+		return fmt.Errorf("donations: %w", err)
+	}
+
+	if err := migrateApprovalBudgets(db); err != nil {
+		return fmt.Errorf("transaction approvals: %w", err)
+	}
+
+	if err := migrateBudgetPeriods(db); err != nil {
+		return fmt.Errorf("budget categories: %w", err)
+	}
+
+	if err := migrateDonationCategories(db, rebuildTotals); err != nil {
+		return fmt.Errorf("donations: %w", err)
+	}
+	return nil
+}
+
+// dropDonationTxHashUnique drops the unique constraint on donations.tx_hash, since a
+// transaction can make several payments; donations are keyed by their payment instead
+func dropDonationTxHashUnique(db *gorm.DB) error {
+	if !db.Migrator().HasConstraint(&models.Donation{}, "uni_donations_tx_hash") {
+		return nil
+	}
+	return db.Migrator().DropConstraint(&models.Donation{}, "uni_donations_tx_hash")
+}
+
+// migrateCosignerUsers converts cosigner user IDs stored as Firebase ID strings
+// into users.id references before the column becomes numeric. IDs that match no
+// user are cleared so the cosigner has to accept a new invitation.
+func migrateCosignerUsers(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.Cosigner{}) {
+		return nil
+	}
+	return db.Exec(`UPDATE cosigners SET user_id = COALESCE((SELECT id FROM users WHERE users.firebase_id = cosigners.user_id), 0)
+		WHERE user_id IS NULL OR user_id = '' OR user_id GLOB '*[^0-9]*'`).Error
+}
+
+// migrateApprovalBudgets links approvals from before budget categories were
+// referenced by ID to the category with their name, and dates executed approvals
+// by the transition that executed them
+func migrateApprovalBudgets(db *gorm.DB) error {
+	err := db.Exec(`UPDATE transaction_approvals SET category_id = (SELECT MIN(id) FROM budget_categories
+			WHERE budget_categories.charity_id = transaction_approvals.charity_id
+			AND budget_categories.name = transaction_approvals.category AND budget_categories.deleted_at IS NULL)
+		WHERE (category_id IS NULL OR category_id = 0) AND category <> ''
+		AND EXISTS (SELECT 1 FROM budget_categories WHERE budget_categories.charity_id = transaction_approvals.charity_id
+			AND budget_categories.name = transaction_approvals.category AND budget_categories.deleted_at IS NULL)`).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Exec(`UPDATE transaction_approvals SET executed_at = COALESCE((SELECT MIN(created_at) FROM status_transitions
+			WHERE status_transitions.entity = 'transaction_approval' AND status_transitions.entity_id = transaction_approvals.id
+			AND status_transitions.to_status = ?), updated_at)
+		WHERE executed_at IS NULL AND status IN ?`, models.ApprovalStatusExecuted,
+		[]string{models.ApprovalStatusExecuted, models.ApprovalStatusRefunded}).Error
+}
+
+// migrateBudgetPeriods moves budget categories from before fiscal periods existed
+// into an open period for the current year. Their Spent values are recounted from
+// the payments executed this year, and the charity's lumen donations become the
+// period's budget, as they were before.
+func migrateBudgetPeriods(db *gorm.DB) error {
+	var charityIDs []uint
+	err := db.Model(&models.BudgetCategory{}).Where("period_id IS NULL OR period_id = 0").
+		Distinct().Pluck("charity_id", &charityIDs).Error
+	if err != nil || len(charityIDs) == 0 {
+		return err
+	}
+
+	year := time.Now().UTC().Year()
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	startMonth, endMonth := start.Format("2006-01"), fmt.Sprintf("%d-12", year)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, charityID := range charityIDs {
+			var charity models.Charity
+			if err := tx.Select("id", "total_donations").First(&charity, charityID).Error; err != nil {
+				return err
+			}
+
+			period := models.FiscalPeriod{
+				CharityID:  charity.ID,
+				Name:       fmt.Sprintf("FY%d", year),
+				StartMonth: startMonth,
+				EndMonth:   endMonth,
+				Budget:     charity.TotalDonations,
+				Status:     models.FiscalPeriodOpen,
+			}
+			if err := tx.Create(&period).Error; err != nil {
+				return err
+			}
+
+			var categories []models.BudgetCategory
+			if err := tx.Where("charity_id = ? AND (period_id IS NULL OR period_id = 0)", charity.ID).Order("id").Find(&categories).Error; err != nil {
+				return err
+			}
+
+			version := models.BudgetVersion{PeriodID: period.ID, Version: 1, Budget: period.Budget, Note: "Migrated budget categories", CreatedByID: "system"}
+			for _, category := range categories {
+				var amounts []models.Money
+				err := tx.Model(&models.TransactionApproval{}).
+					Where("category_id = ? AND status IN ? AND asset_code = ? AND asset_issuer = '' AND executed_at >= ?", category.ID,
+						[]string{models.ApprovalStatusExecuted, models.ApprovalStatusRefunded}, models.NativeAssetCode, start).
+					Pluck("amount", &amounts).Error
+				if err != nil {
+					return err
+				}
+				var spent models.Money
+				for _, amount := range amounts {
+					spent += amount
+				}
+
+				if err := tx.Model(&category).Updates(map[string]interface{}{"period_id": period.ID, "spent": spent}).Error; err != nil {
+					return err
+				}
+				version.Lines = append(version.Lines, models.BudgetVersionLine{CategoryID: category.ID, Name: category.Name, Allocation: category.Allocation})
+			}
+
+			if err := tx.Create(&version).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropCategoryNameTotals drops charity totals from before they were keyed by budget
+// category ID, whose unique index still holds the category name. Totals are derived
+// from the donations and are rebuilt once the donations reference their category.
+func dropCategoryNameTotals(db *gorm.DB) (bool, error) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.CharityTotal{}) || migrator.HasColumn(&models.CharityTotal{}, "CategoryID") {
+		return false, nil
+	}
+	return true, migrator.DropTable(&models.CharityTotal{})
+}
+
+// migrateDonationCategories links donations from before budget categories were
+// referenced by ID to the category of their charity with their name, and rebuilds
+// the totals of every charity when rebuildTotals is set
+func migrateDonationCategories(db *gorm.DB, rebuildTotals bool) error {
+	err := db.Exec(`UPDATE donations SET category_id = (SELECT MIN(id) FROM budget_categories
+			WHERE budget_categories.charity_id = donations.charity_id
+			AND budget_categories.name = donations.category AND budget_categories.deleted_at IS NULL)
+		WHERE (category_id IS NULL OR category_id = 0) AND category <> ''
+		AND EXISTS (SELECT 1 FROM budget_categories WHERE budget_categories.charity_id = donations.charity_id
+			AND budget_categories.name = donations.category AND budget_categories.deleted_at IS NULL)`).Error
+	if err != nil || !rebuildTotals {
+		return err
+	}
+
+	var charityIDs []uint
+	if err := db.Model(&models.Charity{}).Pluck("id", &charityIDs).Error; err != nil {
+		return err
 	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, charityID := range charityIDs {
+			if err := services.RebuildCharityTotals(tx, charityID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
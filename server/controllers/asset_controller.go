@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetCharityAssets lists the assets a charity accepts
+func GetCharityAssets(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.Preload("Assets").First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Lumens are always accepted
+	assets := []models.Asset{models.NativeAsset()}
+	for _, accepted := range charity.Assets {
+		assets = append(assets, accepted.Asset())
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   assets,
+	})
+}
+
+// AddCharityAsset requests a trustline on the charity wallet. The charity accepts the
+// asset once the trustline approval is executed, right away for a single-signer wallet.
+func AddCharityAsset(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+	input := new(models.Asset)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner can add assets
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can add accepted assets",
+		})
+	}
+
+	asset := input.Normalized()
+	if asset.IsNative() {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Lumens are always accepted",
+		})
+	}
+	if _, err := services.StellarAsset(asset); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	accepted, err := services.CharityAcceptsAsset(config.DB, charity.ID, asset)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check accepted assets",
+			"error":   err.Error(),
+		})
+	}
+	if accepted {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity already accepts this asset",
+		})
+	}
+
+	// A trustline already waiting for signatures is not requested twice
+	var open int64
+	if err := config.DB.Model(&models.TransactionApproval{}).
+		Where("charity_id = ? AND kind = ? AND asset_code = ? AND asset_issuer = ? AND status IN ?", charity.ID, models.ApprovalKindTrustline,
+			asset.AssetCode, asset.AssetIssuer, []string{models.ApprovalStatusPending, models.ApprovalStatusApproved, models.ApprovalStatusExecuting}).
+		Count(&open).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check open trustline requests",
+		})
+	}
+	if open > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "A trustline for this asset is already waiting for signatures",
+		})
+	}
+
+	// The trustline is a wallet change the cosigners approve like a payment
+	tx, err := services.BuildTrustline(charity.WalletAddress, asset, services.ApprovalEnvelopeTimeout)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not build the trustline transaction",
+			"error":   err.Error(),
+		})
+	}
+	envelopeXDR, envelopeHash, err := services.EncodeEnvelope(tx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not encode transaction envelope",
+		})
+	}
+	required, err := services.SignaturesNeeded(charity.WalletAddress, charity.WalletAddress, false)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not load the charity wallet signers",
+			"error":   err.Error(),
+		})
+	}
+
+	approval := models.TransactionApproval{
+		Kind:               models.ApprovalKindTrustline,
+		Asset:              asset,
+		Description:        "Accept " + asset.Canonical(),
+		RequiredSignatures: required,
+		EnvelopeXDR:        envelopeXDR,
+		EnvelopeHash:       envelopeHash,
+	}
+	if err := proposeWalletChange(&charity, &approval, userID, nil); err != nil {
+		return err
+	}
+
+	// Executed right away, the trustline approval recorded the accepted asset
+	if approval.Status == models.ApprovalStatusExecuted {
+		var charityAsset models.CharityAsset
+		if err := config.DB.Where("charity_id = ? AND asset_code = ? AND asset_issuer = ?", charity.ID, asset.AssetCode, asset.AssetIssuer).
+			First(&charityAsset).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not load accepted asset",
+			})
+		}
+		return c.Status(201).JSON(fiber.Map{
+			"status": "success",
+			"data":   charityAsset,
+		})
+	}
+
+	return c.Status(202).JSON(fiber.Map{
+		"status":   "success",
+		"message":  "The trustline waits for cosigner signatures, the asset is accepted once it is executed",
+		"approval": approval,
+	})
+}
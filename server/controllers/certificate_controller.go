@@ -3,6 +3,7 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -37,6 +38,14 @@ func GenerateCertificate(c *fiber.Ctx) error {
 		})
 	}
 
+	// Only the donor can have a certificate issued
+	if !policy.CanReadDonor(policy.FromContext(c), donation.DonorID) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Certificates can only be generated for your own donations",
+		})
+	}
+
 	// Check if certificate already exists
 	var existingCert models.Certificate
 	if err := config.DB.Where("donation_id = ?", input.DonationID).First(&existingCert).Error; err == nil {
@@ -55,18 +64,19 @@ func GenerateCertificate(c *fiber.Ctx) error {
 
 	// Create metadata
 	metadata := models.CertificateMetadata{
-		Name:         fmt.Sprintf("Donation Certificate #%d", donation.ID),
-		Description:  fmt.Sprintf("Certificate of donation to %s", donation.Charity.Name),
-		Image:        fmt.Sprintf("https://cleargive.org/certificates/%s.png", tokenID),
-		Amount:       donation.Amount,
-		Currency:     "XLM",
-		DonatedTo:    donation.Charity.Name,
-		DonatedBy:    donation.Donor.Email,
-		DonationDate: donation.CreatedAt,
-		IssueDate:    time.Now(),
-		TxHash:       donation.TxHash,
-		Category:     donation.Category,
-		ImpactArea:   donation.Charity.Category,
+		Name:           fmt.Sprintf("Donation Certificate #%d", donation.ID),
+		Description:    fmt.Sprintf("Certificate of donation to %s", donation.Charity.Name),
+		Image:          fmt.Sprintf("https://cleargive.org/certificates/%s.png", tokenID),
+		Amount:         donation.Amount.String(),
+		Currency:       donation.Asset.Normalized().AssetCode,
+		CurrencyIssuer: donation.AssetIssuer,
+		DonatedTo:      donation.Charity.Name,
+		DonatedBy:      donation.Donor.Email,
+		DonationDate:   donation.CreatedAt,
+		IssueDate:      time.Now(),
+		TxHash:         donation.TxHash,
+		Category:       donation.Category,
+		ImpactArea:     donation.Charity.Category,
 	}
 
 	// Log metadata for demonstration purposes
@@ -128,6 +138,21 @@ func GetCertificate(c *fiber.Ctx) error {
 		})
 	}
 
+	// Certificates are readable by whoever can read the donation
+	allowed, err := policy.CanReadDonation(config.DB, policy.FromContext(c), &certificate.Donation)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check access to certificate",
+		})
+	}
+	if !allowed {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not allowed to view this certificate",
+		})
+	}
+
 	return c.JSON(fiber.Map{
 		"status": "success",
 		"data":   certificate,
@@ -166,18 +191,19 @@ func GetCertificateMetadata(c *fiber.Ctx) error {
 
 	// Create metadata
 	metadata := models.CertificateMetadata{
-		Name:         fmt.Sprintf("Donation Certificate #%d", certificate.Donation.ID),
-		Description:  fmt.Sprintf("Certificate of donation to %s", certificate.Donation.Charity.Name),
-		Image:        certificate.ImageURL,
-		Amount:       certificate.Donation.Amount,
-		Currency:     "XLM",
-		DonatedTo:    certificate.Donation.Charity.Name,
-		DonatedBy:    certificate.Donation.Donor.Email,
-		DonationDate: certificate.Donation.CreatedAt,
-		IssueDate:    certificate.IssueDate,
-		TxHash:       certificate.Donation.TxHash,
-		Category:     certificate.Donation.Category,
-		ImpactArea:   certificate.Donation.Charity.Category,
+		Name:           fmt.Sprintf("Donation Certificate #%d", certificate.Donation.ID),
+		Description:    fmt.Sprintf("Certificate of donation to %s", certificate.Donation.Charity.Name),
+		Image:          certificate.ImageURL,
+		Amount:         certificate.Donation.Amount.String(),
+		Currency:       certificate.Donation.Asset.Normalized().AssetCode,
+		CurrencyIssuer: certificate.Donation.AssetIssuer,
+		DonatedTo:      certificate.Donation.Charity.Name,
+		DonatedBy:      certificate.Donation.Donor.Email,
+		DonationDate:   certificate.Donation.CreatedAt,
+		IssueDate:      certificate.IssueDate,
+		TxHash:         certificate.Donation.TxHash,
+		Category:       certificate.Donation.Category,
+		ImpactArea:     certificate.Donation.Charity.Category,
 	}
 
 	return c.JSON(metadata)
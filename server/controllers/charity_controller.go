@@ -3,11 +3,19 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
 	"cleargive/server/services"
+	"cleargive/server/statemachine"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/stellar/go/keypair"
+	"gorm.io/gorm"
 )
 
 type CreateCharityInput struct {
@@ -19,14 +27,19 @@ type CreateCharityInput struct {
 }
 
 type CosignerInput struct {
-	UserID    string `json:"userId"`
 	Email     string `json:"email"`
 	IsPrimary bool   `json:"isPrimary"`
 }
 
+type AcceptInvitationInput struct {
+	Token     string `json:"token"`
+	PublicKey string `json:"publicKey"`
+}
+
 type BudgetCategoryInput struct {
 	Name       string  `json:"name"`
 	Allocation float64 `json:"allocation"`
+	PeriodID   uint    `json:"periodId"` // Open fiscal period to add the category to, the current period when 0
 }
 
 type MultiSigSettingsInput struct {
@@ -34,6 +47,18 @@ type MultiSigSettingsInput struct {
 	RequiredSignatures int  `json:"requiredSignatures"`
 }
 
+type ApprovalSettingsInput struct {
+	VetoRule          string `json:"vetoRule"`
+	ApprovalTTLHours  int    `json:"approvalTtlHours"`
+	AttributionMethod string `json:"attributionMethod"` // Unchanged when empty
+	OverspendRule     string `json:"overspendRule"`     // Unchanged when empty
+}
+
+type VerificationSettingsInput struct {
+	VerificationQuorum int  `json:"verificationQuorum"`
+	RequireIndependent bool `json:"requireIndependent"`
+}
+
 type OwnershipTransferInput struct {
 	NewOwnerID uint   `json:"newOwnerId"`
 	Email      string `json:"email"`
@@ -103,7 +128,7 @@ func CreateCharity(c *fiber.Ctx) error {
 	}
 
 	// Fund the account on testnet (remove in production)
-	if err := services.FundTestnetAccount(stellarAccount.PublicKey); err != nil {
+	if err := services.Horizon.FundAccount(stellarAccount.PublicKey); err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not fund Stellar wallet",
@@ -118,11 +143,29 @@ func CreateCharity(c *fiber.Ctx) error {
 		Website:       input.Website,
 		ImageURL:      input.ImageURL,
 		WalletAddress: stellarAccount.PublicKey,
-		WalletSecret:  stellarAccount.SecretKey, // In production, encrypt this
 		OwnerID:       userID.(uint),
 	}
 
-	if err := config.DB.Create(&charity).Error; err != nil {
+	// The wallet secret is encrypted for the charity's row, so it is stored once the row has an ID
+	var secretErr error
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		charity.WalletSecret = "pending:" + stellarAccount.PublicKey
+		if err := tx.Create(&charity).Error; err != nil {
+			return err
+		}
+		charity.WalletSecret, secretErr = services.EncryptSecret(stellarAccount.SecretKey, services.SecretRow("charities", charity.ID))
+		if secretErr != nil {
+			return secretErr
+		}
+		return tx.Model(&charity).Update("wallet_secret", charity.WalletSecret).Error
+	})
+	if secretErr != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not secure Stellar wallet",
+		})
+	}
+	if err != nil {
 		if strings.Contains(err.Error(), "wallet_address") {
 			return c.Status(400).JSON(fiber.Map{
 				"status":  "error",
@@ -149,7 +192,12 @@ func GetCharity(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var charity models.Charity
 
-	if err := config.DB.Preload("Owner").Preload("Cosigners").Preload("BudgetCategories").First(&charity, id).Error; err != nil {
+	// Only the categories of the current fiscal period, earlier periods are reported separately
+	month := time.Now().UTC().Format("2006-01")
+	currentPeriod := config.DB.Model(&models.FiscalPeriod{}).Select("id").
+		Where("status = ? AND start_month <= ? AND end_month >= ?", models.FiscalPeriodOpen, month, month)
+
+	if err := config.DB.Preload("Owner").Preload("Cosigners").Preload("BudgetCategories", "period_id IN (?)", currentPeriod).Preload("Assets").First(&charity, id).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Charity not found",
@@ -200,12 +248,27 @@ func AddCosigner(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create cosigner
+	// Check the address has not already been invited
+	var existing int64
+	if err := config.DB.Model(&models.Cosigner{}).Where("charity_id = ? AND LOWER(email) = LOWER(?)", charity.ID, input.Email).Count(&existing).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if existing > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "This email has already been invited as a cosigner",
+		})
+	}
+
+	// Create a pending cosigner, bound to a user once the invitation is accepted
 	cosigner := models.Cosigner{
 		CharityID: charity.ID,
-		UserID:    input.UserID,
 		Email:     input.Email,
 		IsPrimary: input.IsPrimary,
+		Status:    models.CosignerStatusPending,
 	}
 
 	if err := config.DB.Create(&cosigner).Error; err != nil {
@@ -215,10 +278,228 @@ func AddCosigner(c *fiber.Ctx) error {
 		})
 	}
 
+	token, err := issueInvitation(&cosigner)
+	if err != nil {
+		config.DB.Unscoped().Delete(&cosigner)
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not create cosigner invitation",
+			"error":   err.Error(),
+		})
+	}
+
 	return c.Status(201).JSON(fiber.Map{
+		"status":      "success",
+		"data":        cosigner,
+		"inviteToken": token,
+	})
+}
+
+// ReissueCosignerInvite replaces the invitation token of a pending cosigner
+func ReissueCosignerInvite(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+	cosignerID := c.Params("cosignerId")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner can invite cosigners
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can invite cosigners",
+		})
+	}
+
+	var cosigner models.Cosigner
+	if err := config.DB.Where("charity_id = ?", charity.ID).First(&cosigner, cosignerID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Cosigner not found",
+		})
+	}
+
+	if cosigner.Status == models.CosignerStatusActive {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Cosigner has already accepted the invitation",
+		})
+	}
+
+	token, err := issueInvitation(&cosigner)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not create cosigner invitation",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status":      "success",
+		"data":        cosigner,
+		"inviteToken": token,
+	})
+}
+
+// AcceptCosignerInvite binds a pending cosigner to the authenticated user.
+// The invitation must have been sent to the user's email address.
+func AcceptCosignerInvite(c *fiber.Ctx) error {
+	input := new(AcceptInvitationInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	if input.Token == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invitation token is required",
+		})
+	}
+
+	if input.PublicKey != "" {
+		if _, err := keypair.ParseAddress(input.PublicKey); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Public key must be a valid Stellar address",
+			})
+		}
+	}
+
+	cosignerID, err := services.ParseInvitationToken(input.Token, time.Now())
+	if errors.Is(err, services.ErrNoInviteSecret) {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Cosigner invitations are not configured",
+		})
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	// Only the latest token of a pending invitation can be used
+	var cosigner models.Cosigner
+	if err := config.DB.First(&cosigner, cosignerID).Error; err != nil ||
+		cosigner.Status != models.CosignerStatusPending ||
+		cosigner.InviteTokenHash != services.HashInvitationToken(input.Token) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": services.ErrInvalidInvitation.Error(),
+		})
+	}
+
+	principal := policy.FromContext(c)
+	if !strings.EqualFold(cosigner.Email, principal.Email) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "This invitation was sent to a different email address",
+		})
+	}
+
+	// A user can only be one cosigner of a charity
+	var existing int64
+	if err := config.DB.Model(&models.Cosigner{}).Where("charity_id = ? AND user_id = ?", cosigner.CharityID, principal.UserID).Count(&existing).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if existing > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are already a cosigner of this charity",
+		})
+	}
+
+	// Every cosigner signs with a key of their own, so one key cannot count twice
+	if input.PublicKey != "" {
+		var charity models.Charity
+		if err := config.DB.Select("id", "wallet_address").First(&charity, cosigner.CharityID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Charity not found",
+			})
+		}
+
+		var keyInUse int64
+		if err := config.DB.Model(&models.Cosigner{}).Where("charity_id = ? AND public_key = ?", cosigner.CharityID, input.PublicKey).Count(&keyInUse).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not check cosigners",
+			})
+		}
+		if keyInUse > 0 || input.PublicKey == charity.WalletAddress {
+			return c.Status(409).JSON(fiber.Map{
+				"status":  "error",
+				"message": "This public key is already a signer of the charity wallet",
+			})
+		}
+	}
+
+	now := time.Now()
+	cosigner.UserID = principal.UserID
+	cosigner.PublicKey = input.PublicKey
+	cosigner.Status = models.CosignerStatusActive
+	cosigner.InviteTokenHash = ""
+	cosigner.AcceptedAt = &now
+
+	var charity models.Charity
+	if err := config.DB.First(&charity, cosigner.CharityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Accept the invitation first, then propose adding the cosigner key to the wallet signers
+	if err := config.DB.Save(&cosigner).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not accept invitation",
+		})
+	}
+
+	response := fiber.Map{
 		"status": "success",
 		"data":   cosigner,
-	})
+	}
+	signerChange, err := requestSignerSync(&charity, principal.UserID)
+	if err != nil {
+		response["message"] = "Invitation accepted, but the wallet signers could not be updated yet: " + err.Error()
+	}
+	response["signerChange"] = signerChange
+
+	return c.Status(200).JSON(response)
+}
+
+// issueInvitation stores a new invitation token hash on the cosigner and returns the token
+func issueInvitation(cosigner *models.Cosigner) (string, error) {
+	expiresAt := time.Now().Add(services.InvitationTTL)
+	token, hash, err := services.NewInvitationToken(cosigner.ID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	cosigner.InviteTokenHash = hash
+	cosigner.InviteExpiresAt = expiresAt
+	if err := config.DB.Model(cosigner).Select("InviteTokenHash", "InviteExpiresAt").Updates(cosigner).Error; err != nil {
+		return "", err
+	}
+	return token, nil
 }
 
 // RemoveCosigner removes a cosigner from a charity
@@ -244,18 +525,50 @@ func RemoveCosigner(c *fiber.Ctx) error {
 		})
 	}
 
-	// Remove cosigner
-	if err := config.DB.Delete(&models.Cosigner{}, cosignerID).Error; err != nil {
+	var cosigner models.Cosigner
+	if err := config.DB.Where("charity_id = ?", charity.ID).First(&cosigner, cosignerID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Cosigner not found",
+		})
+	}
+
+	// The wallet must keep enough signers to reach the threshold
+	if charity.IsMultiSig && cosigner.Status == models.CosignerStatusActive && cosigner.PublicKey != "" {
+		var keyCount int64
+		if err := config.DB.Model(&models.Cosigner{}).Where("charity_id = ? AND status = ? AND public_key <> ''", charity.ID, models.CosignerStatusActive).Count(&keyCount).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not check cosigners",
+			})
+		}
+		if int64(charity.RequiredSignatures) > keyCount {
+			return c.Status(409).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Removing this cosigner leaves too few signers for the required signatures, lower them first",
+			})
+		}
+	}
+
+	// Remove the cosigner first, then propose removing its key from the wallet signers
+	if err := config.DB.Delete(&cosigner).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not remove cosigner",
 		})
 	}
 
-	return c.Status(200).JSON(fiber.Map{
+	response := fiber.Map{
 		"status":  "success",
 		"message": "Cosigner removed successfully",
-	})
+	}
+	signerChange, err := requestSignerSync(&charity, userID)
+	if err != nil {
+		response["message"] = "Cosigner removed, but the wallet signers could not be updated yet: " + err.Error()
+	}
+	response["signerChange"] = signerChange
+
+	return c.Status(200).JSON(response)
 }
 
 // UpdateMultiSigSettings updates the multi-signature settings for a charity
@@ -296,23 +609,363 @@ func UpdateMultiSigSettings(c *fiber.Ctx) error {
 		})
 	}
 
+	// The wallet must keep enough signers to reach the threshold
+	if input.IsMultiSig {
+		var keyCount int64
+		if err := config.DB.Model(&models.Cosigner{}).Where("charity_id = ? AND status = ? AND public_key <> ''", charity.ID, models.CosignerStatusActive).Count(&keyCount).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not check cosigners",
+			})
+		}
+		if int64(input.RequiredSignatures) > keyCount+1 {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Not enough cosigners with Stellar public keys to meet the required signatures",
+			})
+		}
+	}
+
 	// Update settings
 	charity.IsMultiSig = input.IsMultiSig
 	charity.RequiredSignatures = input.RequiredSignatures
 
-	if err := config.DB.Save(&charity).Error; err != nil {
+	// Save the settings first, then propose applying the new thresholds to the wallet
+	if err := config.DB.Model(&charity).Select("IsMultiSig", "RequiredSignatures").Updates(&charity).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not update multi-signature settings",
 		})
 	}
 
+	response := fiber.Map{"status": "success"}
+	signerChange, err := requestSignerSync(&charity, userID)
+	if err != nil {
+		response["message"] = "Settings saved, but the wallet signers could not be updated yet: " + err.Error()
+	}
+	response["data"] = charity
+	response["signerChange"] = signerChange
+
+	return c.Status(200).JSON(response)
+}
+
+// SyncWalletSigners proposes the signer change that brings the charity wallet in line
+// with its cosigners and settings again, after a proposal failed or was not executed
+func SyncWalletSigners(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner can update wallet signers
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can update wallet signers",
+		})
+	}
+
+	signerChange, err := requestSignerSync(&charity, userID)
+	if err != nil {
+		return err
+	}
+	if signerChange == nil {
+		return c.Status(200).JSON(fiber.Map{
+			"status":  "success",
+			"message": "The wallet signers are already in sync",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data":   signerChange,
+	})
+}
+
+// UpdateApprovalSettings updates how a charity's transaction approvals are rejected,
+// expire and are attributed to donations
+func UpdateApprovalSettings(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+	input := new(ApprovalSettingsInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner can update settings
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can update approval settings",
+		})
+	}
+
+	// Validate settings
+	if input.VetoRule != models.VetoRuleAny && input.VetoRule != models.VetoRuleBlocking {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Veto rule must be any or blocking",
+		})
+	}
+
+	// Approvals must expire before their envelope does
+	maxHours := services.ApprovalEnvelopeTimeout / 3600
+	if input.ApprovalTTLHours < 1 || input.ApprovalTTLHours > maxHours {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("Approval expiry must be between 1 and %d hours", maxHours),
+		})
+	}
+
+	// Executed approvals are attributed to donations with this method
+	if input.AttributionMethod != "" {
+		if input.AttributionMethod != models.AttributionFIFO && input.AttributionMethod != models.AttributionProRata {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Attribution method must be fifo or pro_rata",
+			})
+		}
+		charity.AttributionMethod = input.AttributionMethod
+	}
+
+	// Approvals that overspend a budget category are refused or need every signer
+	if input.OverspendRule != "" {
+		if input.OverspendRule != models.OverspendRuleBlock && input.OverspendRule != models.OverspendRuleQuorum {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Overspend rule must be block or quorum",
+			})
+		}
+		charity.OverspendRule = input.OverspendRule
+	}
+
+	// Update settings
+	charity.VetoRule = input.VetoRule
+	charity.ApprovalTTLHours = input.ApprovalTTLHours
+
+	if err := config.DB.Model(&charity).Select("VetoRule", "ApprovalTTLHours", "AttributionMethod", "OverspendRule").Updates(&charity).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not update approval settings",
+		})
+	}
+
 	return c.Status(200).JSON(fiber.Map{
 		"status": "success",
 		"data":   charity,
 	})
 }
 
+// UpdateVerificationSettings updates the quorum that verifies a charity's milestones
+func UpdateVerificationSettings(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+	input := new(VerificationSettingsInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner can update settings
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can update verification settings",
+		})
+	}
+
+	// Validate settings
+	if input.VerificationQuorum < 1 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "At least 1 verification is required",
+		})
+	}
+
+	// Update settings
+	charity.VerificationQuorum = input.VerificationQuorum
+	charity.RequireIndependent = input.RequireIndependent
+
+	if err := config.DB.Model(&charity).Select("VerificationQuorum", "RequireIndependent").Updates(&charity).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not update verification settings",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data":   charity,
+	})
+}
+
+// GetMultiSigStatus compares the wallet's on-chain signers with the last synced signer set
+func GetMultiSigStatus(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner can inspect wallet signers
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can view multi-signature status",
+		})
+	}
+
+	var signerSet []string
+	threshold := 0
+	if charity.SignerSet != "" {
+		signerSet = strings.Split(charity.SignerSet, ",")
+	}
+	if charity.IsMultiSig {
+		threshold = charity.RequiredSignatures
+	}
+
+	drifted, onChain, err := services.SignerDrift(charity.WalletAddress, signerSet, threshold)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not load wallet signers",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"inSync":           !drifted,
+			"signerSet":        signerSet,
+			"thresholdVersion": charity.ThresholdVersion,
+			"onChain":          onChain,
+		},
+	})
+}
+
+// requestSignerSync proposes the signer change that applies the charity's cosigner keys
+// and threshold to its wallet, replacing older proposals that were not executed. The
+// change to the cosigners or settings is saved before, so the wallet catches up with it
+// once the signers approval is executed: right away when the wallet key is all the wallet
+// needs, after the cosigners sign otherwise. It returns nil when the wallet is already in
+// sync. Errors are *fiber.Error with the response status.
+func requestSignerSync(charity *models.Charity, userID uint) (*models.TransactionApproval, error) {
+	var keys []string
+	threshold := 0
+
+	if charity.IsMultiSig {
+		var cosigners []models.Cosigner
+		if err := config.DB.Where("charity_id = ? AND status = ? AND public_key <> ''", charity.ID, models.CosignerStatusActive).Find(&cosigners).Error; err != nil {
+			return nil, fiber.NewError(500, "Could not fetch cosigners")
+		}
+		for _, cosigner := range cosigners {
+			keys = append(keys, cosigner.PublicKey)
+		}
+		threshold = charity.RequiredSignatures
+	}
+	sort.Strings(keys)
+	signerSet := strings.Join(keys, ",")
+
+	var superseded []models.TransactionApproval
+	if err := config.DB.Where("charity_id = ? AND kind = ? AND status IN ?", charity.ID, models.ApprovalKindSigners,
+		[]string{models.ApprovalStatusPending, models.ApprovalStatusApproved}).Find(&superseded).Error; err != nil {
+		return nil, fiber.NewError(500, "Could not fetch signer changes")
+	}
+	for _, approval := range superseded {
+		err := statemachine.Approvals.Fire(config.DB, statemachine.Transition{
+			ID:      approval.ID,
+			From:    approval.Status,
+			To:      models.ApprovalStatusCancelled,
+			ActorID: strconv.FormatUint(uint64(userID), 10),
+			Reason:  "Replaced by a newer signer change",
+		})
+		if err != nil && !errors.Is(err, statemachine.ErrStaleStatus) {
+			return nil, transitionError(err, "Could not cancel the previous signer change")
+		}
+	}
+
+	tx, err := services.BuildSignerUpdate(charity.WalletAddress, keys, threshold, services.ApprovalEnvelopeTimeout)
+	if err != nil {
+		return nil, fiber.NewError(502, "Could not build the wallet signer change: "+err.Error())
+	}
+	if tx == nil {
+		if charity.SignerSet != signerSet {
+			charity.SignerSet = signerSet
+			if err := config.DB.Model(charity).Update("signer_set", signerSet).Error; err != nil {
+				return nil, fiber.NewError(500, "Could not record the wallet signers")
+			}
+		}
+		return nil, nil
+	}
+
+	envelopeXDR, envelopeHash, err := services.EncodeEnvelope(tx)
+	if err != nil {
+		return nil, fiber.NewError(500, "Could not encode transaction envelope")
+	}
+	required, err := services.SignaturesNeeded(charity.WalletAddress, charity.WalletAddress, true)
+	if err != nil {
+		return nil, fiber.NewError(502, "Could not load the charity wallet signers: "+err.Error())
+	}
+
+	approval := &models.TransactionApproval{
+		Kind:               models.ApprovalKindSigners,
+		Asset:              models.NativeAsset(),
+		Description:        fmt.Sprintf("Set %d cosigner keys with a threshold of %d on the wallet", len(keys), threshold),
+		RequiredSignatures: required,
+		EnvelopeXDR:        envelopeXDR,
+		EnvelopeHash:       envelopeHash,
+		SignerSet:          signerSet,
+	}
+	if err := proposeWalletChange(charity, approval, userID, nil); err != nil {
+		return approval, err
+	}
+	if approval.Status == models.ApprovalStatusExecuted {
+		if err := config.DB.First(charity, charity.ID).Error; err != nil {
+			return approval, fiber.NewError(500, "Could not reload charity")
+		}
+	}
+	return approval, nil
+}
+
 // AddBudgetCategory adds a budget category to a charity
 func AddBudgetCategory(c *fiber.Ctx) error {
 	charityID := c.Params("id")
@@ -353,20 +1006,34 @@ func AddBudgetCategory(c *fiber.Ctx) error {
 		})
 	}
 
+	// Categories are budgeted within an open fiscal period
+	period, err := openFiscalPeriod(charity.ID, input.PeriodID)
+	if err != nil {
+		return err
+	}
+
 	// Create budget category
 	budgetCategory := models.BudgetCategory{
 		CharityID:  charity.ID,
+		PeriodID:   period.ID,
 		Name:       input.Name,
 		Allocation: input.Allocation,
 		Spent:      0, // Initially, nothing is spent
 	}
 
-	if err := config.DB.Create(&budgetCategory).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Could not add budget category",
-			"error":   err.Error(),
-		})
+	// Check the allocations in the same transaction so concurrent requests cannot exceed 100%
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.CheckAllocation(tx, period.ID, 0, input.Allocation); err != nil {
+			return err
+		}
+		if err := tx.Create(&budgetCategory).Error; err != nil {
+			return err
+		}
+		_, err := services.RecordBudgetVersion(tx, period, strconv.FormatUint(uint64(userID), 10), fmt.Sprintf("Added category %q", budgetCategory.Name))
+		return err
+	})
+	if err != nil {
+		return budgetCategoryFailed(c, err, "Could not add budget category")
 	}
 
 	return c.Status(201).JSON(fiber.Map{
@@ -417,15 +1084,28 @@ func UpdateBudgetCategory(c *fiber.Ctx) error {
 		})
 	}
 
+	// Only categories of open periods can change
+	period, err := openFiscalPeriod(charity.ID, budgetCategory.PeriodID)
+	if err != nil {
+		return err
+	}
+
 	// Update budget category
 	budgetCategory.Name = input.Name
 	budgetCategory.Allocation = input.Allocation
 
-	if err := config.DB.Save(&budgetCategory).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Could not update budget category",
-		})
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.CheckAllocation(tx, period.ID, budgetCategory.ID, input.Allocation); err != nil {
+			return err
+		}
+		if err := tx.Model(&budgetCategory).Select("Name", "Allocation").Updates(&budgetCategory).Error; err != nil {
+			return err
+		}
+		_, err := services.RecordBudgetVersion(tx, period, strconv.FormatUint(uint64(userID), 10), fmt.Sprintf("Updated category %q", budgetCategory.Name))
+		return err
+	})
+	if err != nil {
+		return budgetCategoryFailed(c, err, "Could not update budget category")
 	}
 
 	return c.Status(200).JSON(fiber.Map{
@@ -457,8 +1137,30 @@ func DeleteBudgetCategory(c *fiber.Ctx) error {
 		})
 	}
 
+	// Find budget category
+	var budgetCategory models.BudgetCategory
+	if err := config.DB.Where("id = ? AND charity_id = ?", categoryID, charityID).First(&budgetCategory).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Budget category not found",
+		})
+	}
+
+	// Only categories of open periods can change
+	period, err := openFiscalPeriod(charity.ID, budgetCategory.PeriodID)
+	if err != nil {
+		return err
+	}
+
 	// Delete budget category
-	if err := config.DB.Where("id = ? AND charity_id = ?", categoryID, charityID).Delete(&models.BudgetCategory{}).Error; err != nil {
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&budgetCategory).Error; err != nil {
+			return err
+		}
+		_, err := services.RecordBudgetVersion(tx, period, strconv.FormatUint(uint64(userID), 10), fmt.Sprintf("Deleted category %q", budgetCategory.Name))
+		return err
+	})
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not delete budget category",
@@ -471,6 +1173,79 @@ func DeleteBudgetCategory(c *fiber.Ctx) error {
 	})
 }
 
+// budgetCategoryFailed responds to an error saving a budget category
+func budgetCategoryFailed(c *fiber.Ctx, err error, message string) error {
+	var allocationErr *services.AllocationError
+	if errors.Is(err, services.ErrAllocationRange) || errors.As(err, &allocationErr) || errors.Is(err, services.ErrPeriodClosed) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+	})
+}
+
+// GetBudgetReport compares the budget categories of a fiscal period with what they
+// spent. The periodId query parameter defaults to the current period.
+func GetBudgetReport(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	if err := checkBudgetAccess(c, &charity); err != nil {
+		return err
+	}
+
+	var period *models.FiscalPeriod
+	if periodID := c.QueryInt("periodId"); periodID > 0 {
+		period = &models.FiscalPeriod{}
+		if err := config.DB.Where("charity_id = ?", charity.ID).First(period, periodID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Fiscal period not found",
+			})
+		}
+	} else {
+		var err error
+		period, err = services.CurrentFiscalPeriod(config.DB, charity.ID)
+		if errors.Is(err, services.ErrNoOpenPeriod) {
+			return c.Status(404).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Charity has no open fiscal period for the current month",
+			})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not fetch fiscal period",
+			})
+		}
+	}
+
+	report, err := services.BuildBudgetReport(config.DB, period)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not build budget report",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   report,
+	})
+}
+
 // TransferCharityOwnership transfers charity ownership to another user
 func TransferCharityOwnership(c *fiber.Ctx) error {
 	charityID := c.Params("id")
@@ -3,6 +3,8 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -89,6 +91,21 @@ func RunComplianceCheck(c *fiber.Ctx) error {
 		})
 	}
 
+	// Check the user may request a check of this subject
+	allowed, err := policy.CanRunComplianceCheck(config.DB, policy.FromContext(c), input.UserID, input.CharityID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check charity membership",
+		})
+	}
+	if !allowed {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not allowed to run this compliance check",
+		})
+	}
+
 	// Supported check types
 	validTypes := map[string]bool{
 		"donor_verification":    true,
@@ -140,7 +157,7 @@ func RunComplianceCheck(c *fiber.Ctx) error {
 		auditRecord = models.AuditRecord{
 			UserID:    "system",
 			Event:     "Charity Compliance Check Initiated",
-			Details:   "Compliance check of type " + input.Type + " was initiated for charity ID " + string(input.CharityID),
+			Details:   "Compliance check of type " + input.Type + " was initiated for charity ID " + strconv.FormatUint(uint64(input.CharityID), 10),
 			Timestamp: time.Now(),
 		}
 	}
@@ -200,7 +217,7 @@ func UpdateComplianceCheck(c *fiber.Ctx) error {
 
 	// Log the update in audit trail
 	auditRecord := models.AuditRecord{
-		UserID:    "system", // Use system as the user for compliance updates
+		UserID:    policy.FromContext(c).FirebaseID,
 		Event:     "Compliance Check Updated",
 		Details:   "Compliance check #" + id + " status updated to " + input.Status,
 		Timestamp: time.Now(),
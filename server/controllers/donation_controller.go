@@ -3,13 +3,34 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
+	"errors"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
+// GetDonations lists the donations the user may read: their own, those to
+// charities they own or cosign, or all of them for compliance officers
 func GetDonations(c *fiber.Ctx) error {
+	principal := policy.FromContext(c)
+
+	query := config.DB.Preload("Charity").Preload("Donor")
+	if !principal.Can(policy.PermDonorsRead) {
+		charityIDs, err := policy.MemberCharityIDs(config.DB, principal)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not fetch donations",
+				"error":   err.Error(),
+			})
+		}
+		query = query.Where("donor_id = ? OR charity_id IN ?", principal.FirebaseID, append(charityIDs, 0))
+	}
+
 	var donations []models.Donation
-	config.DB.Preload("Charity").Preload("Donor").Find(&donations)
+	query.Find(&donations)
 
 	return c.JSON(fiber.Map{
 		"status": "success",
@@ -28,16 +49,42 @@ func GetDonation(c *fiber.Ctx) error {
 		})
 	}
 
+	// Only the donor, the charity's members and compliance officers can read a donation
+	allowed, err := policy.CanReadDonation(config.DB, policy.FromContext(c), &donation)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check access to donation",
+		})
+	}
+	if !allowed {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not allowed to view this donation",
+		})
+	}
+
 	return c.JSON(fiber.Map{
 		"status": "success",
 		"data":   donation,
 	})
 }
 
+// CreateDonationInput holds a donation the donor reports having paid on the Stellar network
+type CreateDonationInput struct {
+	CharityID uint         `json:"charityId"`
+	Amount    models.Money `json:"amount"`
+	models.Asset
+	TxHash     string `json:"txHash"`
+	Message    string `json:"message"`
+	CategoryID uint   `json:"categoryId"` // Budget category of the charity, 0 for none
+	DonorID    string `json:"donorId"`    // The authenticated user, who is the default
+}
+
 func CreateDonation(c *fiber.Ctx) error {
-	donation := new(models.Donation)
+	input := new(CreateDonationInput)
 
-	if err := c.BodyParser(donation); err != nil {
+	if err := c.BodyParser(input); err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Invalid request body",
@@ -45,6 +92,24 @@ func CreateDonation(c *fiber.Ctx) error {
 		})
 	}
 
+	// Donations are recorded for the authenticated donor
+	principal := policy.FromContext(c)
+	if input.DonorID != "" && input.DonorID != principal.FirebaseID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Donations can only be recorded for yourself",
+		})
+	}
+	donation := &models.Donation{
+		Amount:     input.Amount,
+		Asset:      input.Asset,
+		CharityID:  input.CharityID,
+		DonorID:    principal.FirebaseID,
+		Message:    input.Message,
+		TxHash:     input.TxHash,
+		CategoryID: input.CategoryID,
+	}
+
 	// Verify charity exists
 	var charity models.Charity
 	if err := config.DB.First(&charity, donation.CharityID).Error; err != nil {
@@ -55,6 +120,11 @@ func CreateDonation(c *fiber.Ctx) error {
 		})
 	}
 
+	// The donation can be given to one of the charity's budget categories
+	if err := setDonationCategory(donation, donation.CategoryID); err != nil {
+		return err
+	}
+
 	// Verify donor exists
 	var donor models.User
 	if err := config.DB.Where("firebase_id = ?", donation.DonorID).First(&donor).Error; err != nil {
@@ -65,8 +135,125 @@ func CreateDonation(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create donation with proper associations
-	if err := config.DB.Create(&donation).Error; err != nil {
+	if donation.TxHash == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction hash is required",
+		})
+	}
+
+	// Only payments sent from the donor's own wallet are recorded as their donations
+	if donor.StellarWallet.PublicKey == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Link your Stellar wallet before recording donations made from it",
+		})
+	}
+
+	// Check the charity accepts the asset
+	donation.Asset = donation.Asset.Normalized()
+	if _, err := services.StellarAsset(donation.Asset); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	accepted, err := services.CharityAcceptsAsset(config.DB, charity.ID, donation.Asset)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check accepted assets",
+			"error":   err.Error(),
+		})
+	}
+	if !accepted {
+		return c.Status(422).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity does not accept " + donation.Asset.Canonical(),
+			"code":    services.VerificationAssetMismatch,
+		})
+	}
+
+	// Confirm the payment on the Stellar network
+	payment, err := services.VerifyDonation(donation.TxHash, donor.StellarWallet.PublicKey, charity.WalletAddress, donation.Amount, donation.Asset)
+	if err != nil {
+		var verificationErr *services.VerificationError
+		if errors.As(err, &verificationErr) {
+			return c.Status(422).JSON(fiber.Map{
+				"status":  "error",
+				"message": verificationErr.Message,
+				"code":    verificationErr.Code,
+			})
+		}
+		return c.Status(502).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not verify transaction on the Stellar network",
+			"error":   err.Error(),
+		})
+	}
+
+	// Check the payment has not been recorded already; donations recorded before payment
+	// IDs are found by their transaction
+	var existing models.Donation
+	if err := config.DB.Where("payment_id = ? OR (payment_id = '' AND tx_hash = ?)", payment.ID, payment.TxHash).First(&existing).Error; err == nil {
+		// The ledger watcher records payments without a donor; let the donor claim it
+		// when the payment came from their own wallet
+		if existing.DonorID == "" && existing.CharityID == donation.CharityID {
+			if existing.SourceAccount == "" || donor.StellarWallet.PublicKey != existing.SourceAccount {
+				return c.Status(409).JSON(fiber.Map{
+					"status":  "error",
+					"message": "Donation already recorded for this payment from a wallet that is not yours",
+				})
+			}
+
+			err := config.DB.Transaction(func(tx *gorm.DB) error {
+				if err := services.RemoveDonationFromTotals(tx, &existing); err != nil {
+					return err
+				}
+				existing.DonorID = donation.DonorID
+				existing.PaymentID = payment.ID
+				existing.Message = donation.Message
+				existing.CategoryID = donation.CategoryID
+				existing.Category = donation.Category
+				if err := tx.Save(&existing).Error; err != nil {
+					return err
+				}
+				return services.AddDonationToTotals(tx, &existing)
+			})
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{
+					"status":  "error",
+					"message": "Failed to update donation",
+					"error":   err.Error(),
+				})
+			}
+
+			config.DB.Preload("Charity").Preload("Donor").First(&existing, existing.ID)
+			return c.Status(200).JSON(fiber.Map{
+				"status": "success",
+				"data":   existing,
+			})
+		}
+
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Donation already recorded for this payment",
+			"data":    existing,
+		})
+	}
+
+	donation.Status = services.DonationStatusConfirmed
+	donation.SourceAccount = payment.From
+	donation.PaymentID = payment.ID
+
+	// Create donation with proper associations and count it in the charity totals
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&donation).Error; err != nil {
+			return err
+		}
+		return services.AddDonationToTotals(tx, donation)
+	})
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Failed to create donation",
@@ -83,6 +270,13 @@ func CreateDonation(c *fiber.Ctx) error {
 	})
 }
 
+// UpdateDonationInput holds the donation fields that can be edited, missing fields are unchanged
+type UpdateDonationInput struct {
+	Message    *string `json:"message"`
+	CategoryID *uint   `json:"categoryId"` // Budget category of the charity, 0 for none
+	DonorID    *string `json:"donorId"`    // Compliance staff only
+}
+
 func UpdateDonation(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var donation models.Donation
@@ -94,10 +288,17 @@ func UpdateDonation(c *fiber.Ctx) error {
 		})
 	}
 
-	// Store the current values
-	oldDonation := donation
+	// Only the donor and compliance officers can edit a donation
+	principal := policy.FromContext(c)
+	if !policy.CanUpdateDonation(principal, &donation) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not allowed to update this donation",
+		})
+	}
 
-	if err := c.BodyParser(&donation); err != nil {
+	input := new(UpdateDonationInput)
+	if err := c.BodyParser(input); err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Invalid request body",
@@ -105,19 +306,28 @@ func UpdateDonation(c *fiber.Ctx) error {
 		})
 	}
 
-	// Ensure donation ID doesn't change
-	donation.ID = oldDonation.ID
+	// Store the current values
+	oldDonation := donation
 
-	// Verify charity exists if charityId changed
-	if oldDonation.CharityID != donation.CharityID {
-		var charity models.Charity
-		if err := config.DB.First(&charity, donation.CharityID).Error; err != nil {
-			return c.Status(400).JSON(fiber.Map{
+	// Amount, asset, charity, transaction and status come from the ledger and are never edited
+	if input.Message != nil {
+		donation.Message = *input.Message
+	}
+	if input.CategoryID != nil {
+		if err := setDonationCategory(&donation, *input.CategoryID); err != nil {
+			return err
+		}
+	}
+
+	// Only compliance staff can attribute a donation to another donor
+	if input.DonorID != nil && *input.DonorID != donation.DonorID {
+		if !policy.CanReassignDonation(principal) {
+			return c.Status(403).JSON(fiber.Map{
 				"status":  "error",
-				"message": "Charity not found",
-				"error":   err.Error(),
+				"message": "You are not allowed to attribute this donation to another donor",
 			})
 		}
+		donation.DonorID = *input.DonorID
 	}
 
 	// Verify donor exists if donorId changed
@@ -132,7 +342,16 @@ func UpdateDonation(c *fiber.Ctx) error {
 		}
 	}
 
-	if err := config.DB.Save(&donation).Error; err != nil {
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.RemoveDonationFromTotals(tx, &oldDonation); err != nil {
+			return err
+		}
+		if err := tx.Save(&donation).Error; err != nil {
+			return err
+		}
+		return services.AddDonationToTotals(tx, &donation)
+	})
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Failed to update donation",
@@ -149,6 +368,25 @@ func UpdateDonation(c *fiber.Ctx) error {
 	})
 }
 
+// setDonationCategory gives donation the budget category of its charity with categoryID,
+// or no category when categoryID is 0. Totals are kept per category ID, the name is
+// copied for display.
+func setDonationCategory(donation *models.Donation, categoryID uint) error {
+	donation.CategoryID = 0
+	donation.Category = ""
+	if categoryID == 0 {
+		return nil
+	}
+
+	var category models.BudgetCategory
+	if err := config.DB.Where("charity_id = ?", donation.CharityID).First(&category, categoryID).Error; err != nil {
+		return fiber.NewError(400, "Budget category not found")
+	}
+	donation.CategoryID = category.ID
+	donation.Category = category.Name
+	return nil
+}
+
 func DeleteDonation(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var donation models.Donation
@@ -160,7 +398,27 @@ func DeleteDonation(c *fiber.Ctx) error {
 		})
 	}
 
-	config.DB.Delete(&donation)
+	// Donations are part of the audit trail
+	if !policy.CanDeleteDonation(policy.FromContext(c)) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only compliance officers can delete donations",
+		})
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.RemoveDonationFromTotals(tx, &donation); err != nil {
+			return err
+		}
+		return tx.Delete(&donation).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to delete donation",
+			"error":   err.Error(),
+		})
+	}
 
 	return c.JSON(fiber.Map{
 		"status":  "success",
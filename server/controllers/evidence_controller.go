@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
+	"cleargive/server/storage"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// UploadMilestoneEvidence stores a receipt, photo or PDF as evidence for a milestone
+func UploadMilestoneEvidence(c *fiber.Ctx) error {
+	milestone, charity, err := findEvidenceMilestone(c.Params("milestoneId"))
+	if err != nil {
+		return err
+	}
+
+	// Verify user is authorized (charity owner or cosigner)
+	principal := policy.FromContext(c)
+	isSigner, _, err := policy.IsCharitySigner(config.DB, principal, charity)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if !isSigner {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner and cosigners can upload evidence",
+		})
+	}
+
+	// Evidence is frozen once verifiers have decided on it
+	switch milestone.Status {
+	case models.MilestoneStatusPending, models.MilestoneStatusOverdue, models.MilestoneStatusCompleted:
+	default:
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Evidence can only be added to pending, overdue or completed milestones",
+		})
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "An evidence file is required",
+		})
+	}
+	if header.Size > services.MaxEvidenceSize {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": services.ErrEvidenceTooLarge.Error(),
+		})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not read evidence file",
+		})
+	}
+	defer file.Close()
+
+	hash, size, contentType, err := services.StoreEvidence(storage.Blobs, file)
+	if errors.Is(err, services.ErrEvidenceTooLarge) || errors.Is(err, services.ErrEvidenceType) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not store evidence file",
+		})
+	}
+
+	evidence := models.MilestoneEvidence{
+		MilestoneID:  milestone.ID,
+		UploadedByID: strconv.FormatUint(uint64(principal.UserID), 10),
+		FileName:     filepath.Base(header.Filename),
+		ContentType:  contentType,
+		Size:         size,
+		SHA256:       hash,
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&evidence).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AuditRecord{
+			UserID:    principal.FirebaseID,
+			Event:     "Milestone Evidence Uploaded",
+			Details:   fmt.Sprintf("Evidence #%d (%s, sha256 %s) added to milestone #%d by user #%d", evidence.ID, evidence.FileName, hash, milestone.ID, principal.UserID),
+			IPAddress: c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Timestamp: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not record evidence",
+		})
+	}
+
+	// Anchoring is best effort, the evidence is kept when the network is unavailable
+	if services.EvidenceAnchoring() {
+		if result, err := services.AnchorEvidence(&evidence); err != nil {
+			log.Printf("Could not anchor evidence #%d: %v", evidence.ID, err)
+		} else {
+			anchoredAt := time.Now()
+			evidence.AnchorTxHash = result.Hash
+			evidence.AnchoredAt = &anchoredAt
+			if err := config.DB.Model(&evidence).Updates(map[string]interface{}{
+				"anchor_tx_hash": evidence.AnchorTxHash,
+				"anchored_at":    evidence.AnchoredAt,
+			}).Error; err != nil {
+				log.Printf("Could not record anchor of evidence #%d: %v", evidence.ID, err)
+			}
+		}
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"status": "success",
+		"data":   evidence,
+	})
+}
+
+// GetMilestoneEvidence lists the evidence uploaded for a milestone
+func GetMilestoneEvidence(c *fiber.Ctx) error {
+	milestone, charity, err := findEvidenceMilestone(c.Params("milestoneId"))
+	if err != nil {
+		return err
+	}
+
+	if err := checkEvidenceAccess(c, charity); err != nil {
+		return err
+	}
+
+	var evidence []models.MilestoneEvidence
+	if err := config.DB.Where("milestone_id = ?", milestone.ID).Order("created_at").Find(&evidence).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch evidence",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   evidence,
+	})
+}
+
+// DownloadMilestoneEvidence returns the content of an evidence file.
+// The X-Content-SHA256 header carries the recorded hash so it can be checked against the file.
+func DownloadMilestoneEvidence(c *fiber.Ctx) error {
+	milestone, charity, err := findEvidenceMilestone(c.Params("milestoneId"))
+	if err != nil {
+		return err
+	}
+
+	if err := checkEvidenceAccess(c, charity); err != nil {
+		return err
+	}
+
+	var evidence models.MilestoneEvidence
+	if err := config.DB.Where("milestone_id = ?", milestone.ID).First(&evidence, c.Params("evidenceId")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Evidence not found",
+		})
+	}
+
+	content, err := storage.Blobs.Open(services.EvidenceKey(evidence.SHA256))
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Evidence file is missing from storage",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not open evidence file",
+		})
+	}
+
+	c.Attachment(evidence.FileName)
+	c.Set(fiber.HeaderContentType, evidence.ContentType)
+	c.Set("X-Content-SHA256", evidence.SHA256)
+	return c.SendStream(content, int(evidence.Size))
+}
+
+// findEvidenceMilestone loads a milestone and the charity it belongs to
+func findEvidenceMilestone(milestoneID string) (*models.Milestone, *models.Charity, error) {
+	var milestone models.Milestone
+	if err := config.DB.First(&milestone, milestoneID).Error; err != nil {
+		return nil, nil, fiber.NewError(404, "Milestone not found")
+	}
+
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, milestone.ApprovalID).Error; err != nil {
+		return nil, nil, fiber.NewError(500, "Could not fetch approval details")
+	}
+
+	var charity models.Charity
+	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
+		return nil, nil, fiber.NewError(500, "Could not fetch charity details")
+	}
+
+	return &milestone, &charity, nil
+}
+
+// checkEvidenceAccess allows charity members and milestone verifiers to read evidence
+func checkEvidenceAccess(c *fiber.Ctx, charity *models.Charity) error {
+	allowed, err := policy.CanReadMilestoneEvidence(config.DB, policy.FromContext(c), charity.ID)
+	if err != nil {
+		return fiber.NewError(500, "Could not check charity membership")
+	}
+	if !allowed {
+		return fiber.NewError(403, "Only charity members and milestone verifiers can view evidence")
+	}
+	return nil
+}
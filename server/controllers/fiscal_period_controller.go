@@ -0,0 +1,442 @@
+package controllers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type FiscalPeriodInput struct {
+	Name       string       `json:"name"`
+	StartMonth string       `json:"startMonth"` // YYYY-MM
+	EndMonth   string       `json:"endMonth"`   // YYYY-MM, inclusive
+	Budget     models.Money `json:"budget"`     // Lumens planned for the period
+}
+
+type UpdateFiscalPeriodInput struct {
+	Name   string        `json:"name"`   // Unchanged when empty
+	Budget *models.Money `json:"budget"` // Unchanged when missing
+}
+
+type RollForwardInput struct {
+	Name string `json:"name"` // Named after its months when empty
+}
+
+// GetFiscalPeriods lists a charity's fiscal periods, latest first
+func GetFiscalPeriods(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	if err := checkBudgetAccess(c, &charity); err != nil {
+		return err
+	}
+
+	var periods []models.FiscalPeriod
+	if err := config.DB.Where("charity_id = ?", charity.ID).Order("start_month desc").Find(&periods).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch fiscal periods",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   periods,
+	})
+}
+
+// CreateFiscalPeriod opens a fiscal period for a charity to budget
+func CreateFiscalPeriod(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+	input := new(FiscalPeriodInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner can plan budgets
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can create fiscal periods",
+		})
+	}
+
+	// Validate period
+	if input.Name == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Name is required",
+		})
+	}
+	if _, _, err := services.ParsePeriodMonths(input.StartMonth, input.EndMonth); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	if input.Budget < 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Budget cannot be negative",
+		})
+	}
+
+	period := models.FiscalPeriod{
+		CharityID:  charity.ID,
+		Name:       input.Name,
+		StartMonth: input.StartMonth,
+		EndMonth:   input.EndMonth,
+		Budget:     input.Budget,
+		Status:     models.FiscalPeriodOpen,
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := services.CheckPeriodOverlap(tx, charity.ID, period.StartMonth, period.EndMonth); err != nil {
+			return err
+		}
+		if err := tx.Create(&period).Error; err != nil {
+			return err
+		}
+		_, err := services.RecordBudgetVersion(tx, &period, strconv.FormatUint(uint64(userID), 10), "Period created")
+		return err
+	})
+	if errors.Is(err, services.ErrPeriodOverlap) {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not create fiscal period",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"status": "success",
+		"data":   period,
+	})
+}
+
+// UpdateFiscalPeriod renames an open fiscal period or changes its budget
+func UpdateFiscalPeriod(c *fiber.Ctx) error {
+	input := new(UpdateFiscalPeriodInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	charity, period, err := findFiscalPeriod(c)
+	if err != nil {
+		return err
+	}
+
+	// Only charity owner can plan budgets
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can update fiscal periods",
+		})
+	}
+
+	if period.Status != models.FiscalPeriodOpen {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Fiscal period is closed",
+		})
+	}
+	if input.Budget != nil && *input.Budget < 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Budget cannot be negative",
+		})
+	}
+
+	previousBudget := period.Budget
+	if input.Name != "" {
+		period.Name = input.Name
+	}
+	if input.Budget != nil {
+		period.Budget = *input.Budget
+	}
+
+	// A new budget is recorded as a new version of the period's budget
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(period).Select("Name", "Budget").Updates(period).Error; err != nil {
+			return err
+		}
+		if period.Budget == previousBudget {
+			return nil
+		}
+		note := fmt.Sprintf("Budget changed from %s to %s XLM", previousBudget, period.Budget)
+		_, err := services.RecordBudgetVersion(tx, period, strconv.FormatUint(uint64(userID), 10), note)
+		return err
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not update fiscal period",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   period,
+	})
+}
+
+// CloseFiscalPeriod closes a fiscal period. The Spent values of its categories
+// are frozen and its categories cannot be spent from or changed any more.
+func CloseFiscalPeriod(c *fiber.Ctx) error {
+	charity, period, err := findFiscalPeriod(c)
+	if err != nil {
+		return err
+	}
+
+	// Only charity owner can close periods
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can close fiscal periods",
+		})
+	}
+
+	closedAt := time.Now()
+	closedByID := strconv.FormatUint(uint64(userID), 10)
+	result := config.DB.Model(period).Where("status = ?", models.FiscalPeriodOpen).Updates(map[string]interface{}{
+		"status":       models.FiscalPeriodClosed,
+		"closed_at":    closedAt,
+		"closed_by_id": closedByID,
+	})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not close fiscal period",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Fiscal period is already closed",
+		})
+	}
+
+	period.Status = models.FiscalPeriodClosed
+	period.ClosedAt = &closedAt
+	period.ClosedByID = closedByID
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   period,
+	})
+}
+
+// RollForwardFiscalPeriod creates the period that follows a fiscal period, with
+// the same length, budget and categories
+func RollForwardFiscalPeriod(c *fiber.Ctx) error {
+	input := new(RollForwardInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	charity, period, err := findFiscalPeriod(c)
+	if err != nil {
+		return err
+	}
+
+	// Only charity owner can plan budgets
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can roll fiscal periods forward",
+		})
+	}
+
+	var next *models.FiscalPeriod
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		next, err = services.RollForward(tx, period, input.Name, strconv.FormatUint(uint64(userID), 10))
+		return err
+	})
+	if errors.Is(err, services.ErrPeriodOverlap) {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not roll fiscal period forward",
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"status": "success",
+		"data":   next,
+	})
+}
+
+// GetBudgetVersions lists the budget versions of a fiscal period with their allocations
+func GetBudgetVersions(c *fiber.Ctx) error {
+	charity, period, err := findFiscalPeriod(c)
+	if err != nil {
+		return err
+	}
+
+	if err := checkBudgetAccess(c, charity); err != nil {
+		return err
+	}
+
+	var versions []models.BudgetVersion
+	err = config.DB.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("period_id = ?", period.ID).Order("version").Find(&versions).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch budget versions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   versions,
+	})
+}
+
+// CompareBudgetVersions compares two budget versions of a fiscal period. The to
+// query parameter defaults to the latest version and from to the one before it.
+func CompareBudgetVersions(c *fiber.Ctx) error {
+	charity, period, err := findFiscalPeriod(c)
+	if err != nil {
+		return err
+	}
+
+	if err := checkBudgetAccess(c, charity); err != nil {
+		return err
+	}
+
+	to := c.QueryInt("to")
+	if to <= 0 {
+		err := config.DB.Model(&models.BudgetVersion{}).Where("period_id = ?", period.ID).
+			Select("COALESCE(MAX(version), 0)").Scan(&to).Error
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not fetch budget versions",
+			})
+		}
+	}
+	from := c.QueryInt("from", to-1)
+
+	comparison, err := services.CompareBudgetVersions(config.DB, period.ID, from, to)
+	if errors.Is(err, services.ErrVersionNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Budget version not found",
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not compare budget versions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   comparison,
+	})
+}
+
+// findFiscalPeriod loads the charity and fiscal period named by the route
+func findFiscalPeriod(c *fiber.Ctx) (*models.Charity, *models.FiscalPeriod, error) {
+	var charity models.Charity
+	if err := config.DB.First(&charity, c.Params("id")).Error; err != nil {
+		return nil, nil, fiber.NewError(404, "Charity not found")
+	}
+
+	var period models.FiscalPeriod
+	if err := config.DB.Where("charity_id = ?", charity.ID).First(&period, c.Params("periodId")).Error; err != nil {
+		return nil, nil, fiber.NewError(404, "Fiscal period not found")
+	}
+
+	return &charity, &period, nil
+}
+
+// openFiscalPeriod returns the open fiscal period a charity's budget categories are
+// changed in: periodID, or the current period when it is 0
+func openFiscalPeriod(charityID, periodID uint) (*models.FiscalPeriod, error) {
+	if periodID == 0 {
+		period, err := services.CurrentFiscalPeriod(config.DB, charityID)
+		if errors.Is(err, services.ErrNoOpenPeriod) {
+			return nil, fiber.NewError(400, "Charity has no open fiscal period for the current month, create one first")
+		}
+		if err != nil {
+			return nil, fiber.NewError(500, "Could not fetch fiscal period")
+		}
+		return period, nil
+	}
+
+	var period models.FiscalPeriod
+	if err := config.DB.Where("charity_id = ?", charityID).First(&period, periodID).Error; err != nil {
+		return nil, fiber.NewError(404, "Fiscal period not found")
+	}
+	if period.Status != models.FiscalPeriodOpen {
+		return nil, fiber.NewError(400, "Fiscal period is closed")
+	}
+	return &period, nil
+}
+
+// checkBudgetAccess allows whoever can read the charity to read its budget
+func checkBudgetAccess(c *fiber.Ctx, charity *models.Charity) error {
+	allowed, err := policy.CanReadCharity(config.DB, policy.FromContext(c), charity.ID)
+	if err != nil {
+		return fiber.NewError(500, "Could not check access to charity")
+	}
+	if !allowed {
+		return fiber.NewError(403, "You are not authorized to view this charity's budget")
+	}
+	return nil
+}
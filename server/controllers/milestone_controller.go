@@ -3,32 +3,61 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
+	"cleargive/server/statemachine"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type MilestoneInput struct {
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Amount      string    `json:"amount"`
-	DueDate     time.Time `json:"dueDate"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Amount      models.Money `json:"amount"`
+	DueDate     time.Time    `json:"dueDate"`
 }
 
 type MilestoneVerificationInput struct {
-	VerifierID string `json:"verifierId"`
-	Comments   string `json:"comments"`
-	Status     string `json:"status"`
-	Proof      string `json:"proof,omitempty"`
+	Comments string `json:"comments"`
+	Status   string `json:"status"`
+	Proof    string `json:"proof,omitempty"`
 }
 
 // GetMilestones gets all milestones for a transaction approval
 func GetMilestones(c *fiber.Ctx) error {
 	approvalID := c.Params("approvalId")
 
+	// Check if approval exists
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, approvalID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval not found",
+		})
+	}
+
+	// Only members of the charity and milestone verifiers can see its milestones
+	allowed, err := policy.CanReadMilestones(config.DB, policy.FromContext(c), approval.CharityID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if !allowed {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to view the milestones of this transaction approval",
+		})
+	}
+
 	var milestones []models.Milestone
-	if err := config.DB.Where("approval_id = ?", approvalID).Find(&milestones).Error; err != nil {
+	if err := config.DB.Where("approval_id = ?", approval.ID).Find(&milestones).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not fetch milestones",
@@ -64,9 +93,6 @@ func CreateMilestone(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verify user is authorized (only charity owner or cosigner can create milestones)
-	userID := c.Locals("userID").(uint)
-
 	// Get charity
 	var charity models.Charity
 	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
@@ -76,44 +102,57 @@ func CreateMilestone(c *fiber.Ctx) error {
 		})
 	}
 
-	var isAuthorized bool
-	if charity.OwnerID == userID {
-		isAuthorized = true
-	} else {
-		// Check if user is a cosigner
-		var cosigner models.Cosigner
-		if err := config.DB.Where("charity_id = ? AND user_id = ?", charity.ID, userID).First(&cosigner).Error; err == nil {
-			isAuthorized = true
-		}
+	// Verify user is authorized (only charity owner or cosigner can create milestones)
+	isSigner, _, err := policy.IsCharitySigner(config.DB, policy.FromContext(c), &charity)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
 	}
 
-	if !isAuthorized {
+	if !isSigner {
 		return c.Status(403).JSON(fiber.Map{
 			"status":  "error",
 			"message": "You are not authorized to create milestones for this transaction",
 		})
 	}
 
+	// Only payments release funds, not changes to the wallet
+	if approval.Kind != models.ApprovalKindPayment {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Milestones can only be created for payments",
+		})
+	}
+
 	// Validate milestone data
-	if input.Name == "" || input.Description == "" || input.Amount == "" {
+	if input.Name == "" || input.Description == "" || !input.Amount.IsPositive() {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Name, description, and amount are required",
+			"message": "Name, description, and a positive amount are required",
 		})
 	}
 
-	// Create milestone
-	approvalIDUint, _ := strconv.ParseUint(approvalID, 10, 64)
+	// Create milestone; its approval must be funded and have enough unallocated funds
 	milestone := models.Milestone{
 		Name:        input.Name,
 		Description: input.Description,
-		ApprovalID:  uint(approvalIDUint),
 		Amount:      input.Amount,
 		DueDate:     input.DueDate,
-		Status:      "pending",
+		Status:      models.MilestoneStatusPending,
 	}
 
-	if err := config.DB.Create(&milestone).Error; err != nil {
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		return services.AddMilestone(tx, &approval, &milestone)
+	})
+	if errors.Is(err, services.ErrApprovalNotFundable) || errors.Is(err, services.ErrMilestonesExceedApproval) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not create milestone",
@@ -127,6 +166,49 @@ func CreateMilestone(c *fiber.Ctx) error {
 	})
 }
 
+// GetMilestoneSummary shows how much of an approval is allocated to, released by
+// and still available for milestones
+func GetMilestoneSummary(c *fiber.Ctx) error {
+	approvalID := c.Params("approvalId")
+
+	// Check if approval exists
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, approvalID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval not found",
+		})
+	}
+
+	// Only members of the charity and milestone verifiers can see its milestones
+	allowed, err := policy.CanReadMilestones(config.DB, policy.FromContext(c), approval.CharityID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if !allowed {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to view the milestones of this transaction approval",
+		})
+	}
+
+	summary, err := services.SummarizeMilestones(config.DB, &approval)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not summarize milestones",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   summary,
+	})
+}
+
 // CompleteMilestone marks a milestone as completed by the charity
 func CompleteMilestone(c *fiber.Ctx) error {
 	milestoneID := c.Params("milestoneId")
@@ -175,19 +257,40 @@ func CompleteMilestone(c *fiber.Ctx) error {
 		})
 	}
 
-	// Update milestone
-	milestone.Status = "completed"
-	milestone.CompletionDate = time.Now()
-	milestone.VerificationProof = input.Proof
-
-	if err := config.DB.Save(&milestone).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
+	// Check milestone status
+	if !statemachine.Milestones.Can(milestone.Status, models.MilestoneStatusCompleted) {
+		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not update milestone",
-			"error":   err.Error(),
+			"message": "Only pending or overdue milestones can be completed",
 		})
 	}
 
+	// Update milestone
+	completionDate := time.Now()
+	completedByID := strconv.FormatUint(uint64(userID), 10)
+	err := statemachine.Milestones.Fire(config.DB, statemachine.Transition{
+		ID:      milestone.ID,
+		From:    milestone.Status,
+		To:      models.MilestoneStatusCompleted,
+		ActorID: completedByID,
+		Updates: map[string]interface{}{
+			"completion_date":    completionDate,
+			"verification_proof": input.Proof,
+			"completed_by_id":    completedByID,
+			// Votes from earlier rounds do not count towards this completion
+			"verification_round": gorm.Expr("verification_round + 1"),
+		},
+	})
+	if err != nil {
+		return transitionFailed(c, err, "Could not update milestone")
+	}
+
+	milestone.Status = models.MilestoneStatusCompleted
+	milestone.CompletionDate = completionDate
+	milestone.VerificationProof = input.Proof
+	milestone.CompletedByID = completedByID
+	milestone.VerificationRound++
+
 	return c.JSON(fiber.Map{
 		"status": "success",
 		"data":   milestone,
@@ -217,7 +320,7 @@ func VerifyMilestone(c *fiber.Ctx) error {
 	}
 
 	// Check milestone status
-	if milestone.Status != "completed" {
+	if milestone.Status != models.MilestoneStatusCompleted {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Milestone must be completed before verification",
@@ -233,54 +336,98 @@ func VerifyMilestone(c *fiber.Ctx) error {
 		})
 	}
 
-	// Verify user is authorized (must be a cosigner to verify milestones)
-	userID := c.Locals("userID").(uint)
-	userIDStr := strconv.FormatUint(uint64(userID), 10)
+	var charity models.Charity
+	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch charity details",
+		})
+	}
 
-	var cosigner models.Cosigner
-	if err := config.DB.Where("charity_id = ? AND user_id = ?", approval.CharityID, userID).First(&cosigner).Error; err != nil {
+	if input.Status != models.VerificationApproved && input.Status != models.VerificationRejected {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Verification status must be approved or rejected",
+		})
+	}
+
+	// Verify user is authorized (must be a cosigner or a milestone verifier).
+	// Verifiers outside the charity cast independent votes.
+	principal := policy.FromContext(c)
+	userIDStr := strconv.FormatUint(uint64(principal.UserID), 10)
+
+	isSigner, cosigner, err := policy.IsCharitySigner(config.DB, principal, &charity)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if cosigner == nil && !principal.Can(policy.PermMilestonesVerify) {
 		return c.Status(403).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Only cosigners can verify milestones",
+			"message": "Only cosigners and milestone verifiers can verify milestones",
 		})
 	}
 
-	// Create verification
+	// Whoever completed the milestone cannot verify their own work
+	if milestone.CompletedByID == userIDStr {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You completed this milestone and cannot verify it",
+		})
+	}
+
+	// Create verification for the current round
 	verification := models.MilestoneVerification{
 		MilestoneID: milestone.ID,
+		Round:       milestone.VerificationRound,
 		VerifierID:  userIDStr,
+		Independent: !isSigner,
 		Comments:    input.Comments,
 		Status:      input.Status,
 	}
 
-	if err := config.DB.Create(&verification).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Could not create verification",
-			"error":   err.Error(),
-		})
-	}
+	// Record the vote and move the milestone once the quorum decides it
+	rule := services.VerificationRuleFor(&charity, &approval)
+	var tally *services.VerificationTally
 
-	// Update milestone status based on verification
-	if input.Status == "approved" {
-		milestone.Status = "verified"
-	} else {
-		milestone.Status = "pending" // Reset to pending if rejected
-	}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&verification).Error; err != nil {
+			return err
+		}
 
-	if err := config.DB.Save(&milestone).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
+		tally, err = services.TallyVerifications(tx, &milestone, rule)
+		if err != nil || tally.Outcome == "" {
+			return err
+		}
+		return statemachine.Milestones.Fire(tx, statemachine.Transition{
+			ID:      milestone.ID,
+			From:    milestone.Status,
+			To:      tally.Outcome,
+			ActorID: userIDStr,
+			Reason:  fmt.Sprintf("Round %d: %d approvals, %d rejections, quorum %d", tally.Round, tally.Approvals, tally.Rejections, rule.Quorum),
+		})
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not update milestone status",
-			"error":   err.Error(),
+			"message": "You have already verified this milestone",
 		})
 	}
+	if err != nil {
+		return transitionFailed(c, err, "Could not update milestone status")
+	}
+	if tally.Outcome != "" {
+		milestone.Status = tally.Outcome
+	}
 
 	return c.JSON(fiber.Map{
 		"status": "success",
 		"data": fiber.Map{
 			"milestone":    milestone,
 			"verification": verification,
+			"tally":        tally,
 		},
 	})
 }
@@ -299,7 +446,7 @@ func ReleaseMilestoneFunds(c *fiber.Ctx) error {
 	}
 
 	// Check milestone status
-	if milestone.Status != "verified" {
+	if !statemachine.Milestones.Can(milestone.Status, models.MilestoneStatusReleased) {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Milestone must be verified before releasing funds",
@@ -332,26 +479,123 @@ func ReleaseMilestoneFunds(c *fiber.Ctx) error {
 		})
 	}
 
-	// In a real implementation, this would use the Stellar service to send the transaction
-	// For now, we'll just update the status
-	milestone.Status = "released"
+	// Escrowed funds are paid to the destination by a release approval, which the escrow
+	// account's signers sign; otherwise the approval's payment already paid the destination
+	// when it was executed
+	if approval.Escrowed {
+		return releaseEscrowedMilestone(c, &milestone, &approval, &charity, userID)
+	}
+
+	err := statemachine.Milestones.Fire(config.DB, statemachine.Transition{
+		ID:      milestone.ID,
+		From:    milestone.Status,
+		To:      models.MilestoneStatusReleased,
+		ActorID: strconv.FormatUint(uint64(userID), 10),
+	})
+	if err != nil {
+		return transitionFailed(c, err, "Could not update milestone status")
+	}
+	milestone.Status = models.MilestoneStatusReleased
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"milestone": milestone,
+			"txHash":    approval.TxHash,
+		},
+	})
+}
+
+// releaseEscrowedMilestone proposes the payment of a verified milestone's funds from the
+// escrow account of its approval to the destination and moves the milestone to releasing.
+// The milestone is released once the release approval is executed: right away when the
+// wallet key meets the escrow's threshold, after the cosigners sign otherwise.
+func releaseEscrowedMilestone(c *fiber.Ctx, milestone *models.Milestone, approval *models.TransactionApproval, charity *models.Charity, userID uint) error {
+	if approval.Status != models.ApprovalStatusExecuted {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the escrow of an executed payment can release milestone funds",
+		})
+	}
 
-	// Generate a mock transaction hash
-	txHash := "milestone-tx-" + milestoneID
+	if milestone.Status == models.MilestoneStatusReleasing {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The release of this milestone is already waiting for signatures",
+		})
+	}
 
-	if err := config.DB.Save(&milestone).Error; err != nil {
+	tx, err := services.BuildPayment(approval.EscrowAddress, approval.Destination, milestone.Amount, milestone.Asset, services.ApprovalEnvelopeTimeout)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not build transaction envelope",
+			"error":   err.Error(),
+		})
+	}
+	envelopeXDR, envelopeHash, err := services.EncodeEnvelope(tx)
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not update milestone status",
+			"message": "Could not encode transaction envelope",
+		})
+	}
+	required, err := services.SignaturesNeeded(approval.EscrowAddress, charity.WalletAddress, false)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not load the escrow account signers",
 			"error":   err.Error(),
 		})
 	}
 
+	release := &models.TransactionApproval{
+		Kind:               models.ApprovalKindRelease,
+		ParentID:           approval.ID,
+		MilestoneID:        milestone.ID,
+		Amount:             milestone.Amount,
+		Asset:              milestone.Asset,
+		Description:        fmt.Sprintf("Release of milestone %q of transaction approval #%d", milestone.Name, approval.ID),
+		Destination:        approval.Destination,
+		RequiredSignatures: required,
+		EnvelopeXDR:        envelopeXDR,
+		EnvelopeHash:       envelopeHash,
+	}
+	// The milestone is releasing with the envelope's hash before the release can be submitted
+	err = proposeWalletChange(charity, release, userID, func(tx *gorm.DB) error {
+		return statemachine.Milestones.Fire(tx, statemachine.Transition{
+			ID:      milestone.ID,
+			From:    milestone.Status,
+			To:      models.MilestoneStatusReleasing,
+			ActorID: strconv.FormatUint(uint64(userID), 10),
+			Reason:  "Release of the escrowed funds requested",
+			Updates: map[string]interface{}{"release_tx_hash": envelopeHash},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if release.Status != models.ApprovalStatusExecuted {
+		return c.Status(202).JSON(fiber.Map{
+			"status":   "success",
+			"message":  "The release waits for cosigner signatures, the milestone is released once it is executed",
+			"approval": release,
+		})
+	}
+
+	// The release approval's effects released the milestone
+	if err := config.DB.First(milestone, milestone.ID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch milestone",
+		})
+	}
 	return c.JSON(fiber.Map{
 		"status": "success",
 		"data": fiber.Map{
 			"milestone": milestone,
-			"txHash":    txHash,
+			"txHash":    release.TxHash,
 		},
 	})
 }
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetNotifications lists the current user's notifications, newest first
+func GetNotifications(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	query := config.DB.Where("user_id = ?", userID)
+	if c.QueryBool("unread") {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("created_at desc").Find(&notifications).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch notifications",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   notifications,
+	})
+}
+
+// MarkNotificationRead marks one of the current user's notifications as read
+func MarkNotificationRead(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	notificationID := c.Params("notificationId")
+
+	var notification models.Notification
+	if err := config.DB.Where("user_id = ?", userID).First(&notification, notificationID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Notification not found",
+		})
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := config.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not update notification",
+			})
+		}
+		notification.ReadAt = &now
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   notification,
+	})
+}
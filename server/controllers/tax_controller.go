@@ -3,6 +3,8 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
 	"fmt"
 	"strconv"
 	"time"
@@ -21,7 +23,7 @@ func GetTaxReports(c *fiber.Ctx) error {
 	}
 
 	var reports []models.TaxReport
-	if err := config.DB.Where("user_id = ?", userID).Order("year desc").Find(&reports).Error; err != nil {
+	if err := config.DB.Preload("Assets").Where("user_id = ?", userID).Order("year desc").Find(&reports).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not fetch tax reports",
@@ -40,13 +42,20 @@ func GetTaxReport(c *fiber.Ctx) error {
 	reportID := c.Params("id")
 	var report models.TaxReport
 
-	if err := config.DB.First(&report, reportID).Error; err != nil {
+	if err := config.DB.Preload("Assets").First(&report, reportID).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Tax report not found",
 		})
 	}
 
+	if !policy.CanReadDonor(policy.FromContext(c), report.UserID) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You can only access your own records",
+		})
+	}
+
 	return c.JSON(fiber.Map{
 		"status": "success",
 		"data":   report,
@@ -68,6 +77,14 @@ func GenerateTaxReport(c *fiber.Ctx) error {
 		})
 	}
 
+	// Reports can only be generated for yourself
+	if !policy.CanReadDonor(policy.FromContext(c), input.UserID) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You can only generate your own tax reports",
+		})
+	}
+
 	// Check if the user exists
 	var user models.User
 	if err := config.DB.Where("firebase_id = ?", input.UserID).First(&user).Error; err != nil {
@@ -79,7 +96,7 @@ func GenerateTaxReport(c *fiber.Ctx) error {
 
 	// Check for existing reports for this year
 	var existingReport models.TaxReport
-	if err := config.DB.Where("user_id = ? AND year = ?", input.UserID, input.Year).First(&existingReport).Error; err == nil {
+	if err := config.DB.Preload("Assets").Where("user_id = ? AND year = ?", input.UserID, input.Year).First(&existingReport).Error; err == nil {
 		// Report exists, return it
 		return c.JSON(fiber.Map{
 			"status":  "success",
@@ -93,7 +110,7 @@ func GenerateTaxReport(c *fiber.Ctx) error {
 	startDate := time.Date(input.Year, 1, 1, 0, 0, 0, 0, time.UTC)
 	endDate := time.Date(input.Year+1, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	if err := config.DB.Where("donor_id = ? AND created_at BETWEEN ? AND ?", input.UserID, startDate, endDate).Find(&donations).Error; err != nil {
+	if err := config.DB.Where("donor_id = ? AND status = ? AND created_at BETWEEN ? AND ?", input.UserID, services.DonationStatusConfirmed, startDate, endDate).Find(&donations).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not fetch donations",
@@ -101,19 +118,55 @@ func GenerateTaxReport(c *fiber.Ctx) error {
 		})
 	}
 
-	var totalDonations float64
+	// Total the donations per asset
+	var assets []models.TaxReportAsset
+	index := make(map[models.Asset]int)
 	for _, donation := range donations {
-		amount, err := strconv.ParseFloat(donation.Amount, 64)
-		if err == nil {
-			totalDonations += amount
+		asset := donation.Asset.Normalized()
+		i, ok := index[asset]
+		if !ok {
+			i = len(assets)
+			index[asset] = i
+			assets = append(assets, models.TaxReportAsset{Asset: asset})
 		}
+		assets[i].TotalDonations += donation.Amount
+		assets[i].DonationCount++
+	}
+
+	// Refunded shares of the year's donations are not deductible
+	if len(donations) > 0 {
+		donationIDs := make([]uint, len(donations))
+		for i, donation := range donations {
+			donationIDs[i] = donation.ID
+		}
+
+		var refunds []models.Refund
+		if err := config.DB.Where("donation_id IN ?", donationIDs).Find(&refunds).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not fetch refunds",
+				"error":   err.Error(),
+			})
+		}
+		for _, refund := range refunds {
+			if i, ok := index[refund.Asset.Normalized()]; ok {
+				assets[i].TotalDonations -= refund.Amount
+				assets[i].TotalRefunded += refund.Amount
+			}
+		}
+	}
+
+	var totalDonations models.Money
+	if i, ok := index[models.NativeAsset()]; ok {
+		totalDonations = assets[i].TotalDonations
 	}
 
-	// Create the tax report
+	// Create the tax report with its asset totals
 	report := models.TaxReport{
 		UserID:         input.UserID,
 		Year:           input.Year,
 		TotalDonations: totalDonations,
+		Assets:         assets,
 		Status:         "ready",
 		GeneratedAt:    time.Now(),
 		FileURL:        fmt.Sprintf("/api/tax-reports/%d/%d/download", input.Year, time.Now().Unix()),
@@ -184,7 +237,7 @@ func GetDonationsByYear(c *fiber.Ctx) error {
 	endDate := time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC)
 
 	var donations []models.Donation
-	if err := config.DB.Preload("Charity").Where("donor_id = ? AND created_at BETWEEN ? AND ?", userID, startDate, endDate).Find(&donations).Error; err != nil {
+	if err := config.DB.Preload("Charity").Where("donor_id = ? AND status = ? AND created_at BETWEEN ? AND ?", userID, services.DonationStatusConfirmed, startDate, endDate).Find(&donations).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not fetch donations",
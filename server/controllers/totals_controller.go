@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetCharityTotals returns a charity's donation totals per asset, budget category and month
+func GetCharityTotals(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	var totals []models.CharityTotal
+	if err := config.DB.Where("charity_id = ?", charity.ID).Order("month desc, asset_code, category_id").Find(&totals).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch donation totals",
+		})
+	}
+
+	// Roll the rows up per asset
+	type assetTotal struct {
+		AssetCode     string       `json:"assetCode"`
+		AssetIssuer   string       `json:"assetIssuer,omitempty"`
+		Amount        models.Money `json:"amount"`
+		DonationCount int64        `json:"donationCount"`
+	}
+	var byAsset []*assetTotal
+	index := make(map[string]*assetTotal)
+	for _, total := range totals {
+		key := total.AssetCode + ":" + total.AssetIssuer
+		rollup, ok := index[key]
+		if !ok {
+			rollup = &assetTotal{AssetCode: total.AssetCode, AssetIssuer: total.AssetIssuer}
+			index[key] = rollup
+			byAsset = append(byAsset, rollup)
+		}
+		rollup.Amount += models.Money(total.Stroops)
+		rollup.DonationCount += total.DonationCount
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"byAsset": byAsset,
+			"totals":  totals,
+		},
+	})
+}
+
+// RebuildCharityTotals recomputes a charity's donation totals from its confirmed donations
+func RebuildCharityTotals(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only charity owner or an admin can rebuild totals
+	principal := policy.FromContext(c)
+	if charity.OwnerID != principal.UserID && !principal.Can(policy.PermTotalsRebuild) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can rebuild donation totals",
+		})
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return services.RebuildCharityTotals(tx, charity.ID)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not rebuild donation totals",
+			"error":   err.Error(),
+		})
+	}
+
+	config.DB.First(&charity, charity.ID)
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Donation totals rebuilt successfully",
+		"data":    charity,
+	})
+}
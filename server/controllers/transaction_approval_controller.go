@@ -3,24 +3,60 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
+	"cleargive/server/statemachine"
+	"errors"
+	"fmt"
+	"log"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"gorm.io/gorm"
 )
 
 type CreateApprovalInput struct {
-	Amount      string `json:"amount"`
+	Amount models.Money `json:"amount"`
+	models.Asset
 	Description string `json:"description"`
-	Category    string `json:"category"`
+	CategoryID  uint   `json:"categoryId"` // Budget category the payment is spent from, 0 for none
+	Destination string `json:"destination"`
+	// Milestone verification rule for this approval, the charity's rule applies when the quorum is 0
+	VerificationQuorum int  `json:"verificationQuorum"`
+	RequireIndependent bool `json:"requireIndependent"`
+	// Pay into escrow and release the funds to the destination milestone by milestone
+	Escrow bool `json:"escrow"`
 }
 
+// AddSignatureInput is the body of a signature request. The signer is always
+// the authenticated user, never a value from the body.
 type AddSignatureInput struct {
-	SignerID  string `json:"signerId"`
-	Signature string `json:"signature"` // Could be empty in this implementation
-	Email     string `json:"email"`     // Added for direct email specification
+	Signature string `json:"signature"` // Base64 XDR decorated signature, not needed for the owner
 }
 
-// GetPendingApprovals returns all pending and approved transaction approvals for a charity
+type RejectApprovalInput struct {
+	Reason string `json:"reason"`
+}
+
+// approvalStatuses are the statuses GetPendingApprovals can filter by
+var approvalStatuses = map[string]bool{
+	models.ApprovalStatusPending:   true,
+	models.ApprovalStatusApproved:  true,
+	models.ApprovalStatusRejected:  true,
+	models.ApprovalStatusCancelled: true,
+	models.ApprovalStatusExpired:   true,
+	models.ApprovalStatusExecuting: true,
+	models.ApprovalStatusExecuted:  true,
+	models.ApprovalStatusRefunding: true,
+	models.ApprovalStatusRefunded:  true,
+}
+
+// GetPendingApprovals returns the transaction approvals of a charity. The status
+// query parameter is a comma separated list of statuses and defaults to pending.
 func GetPendingApprovals(c *fiber.Ctx) error {
 	charityID := c.Params("id")
 
@@ -43,21 +79,34 @@ func GetPendingApprovals(c *fiber.Ctx) error {
 	}
 
 	// Check if user is owner or cosigner of the charity
-	userID := c.Locals("userID").(uint)
-	if charity.OwnerID != userID {
-		// Check if user is a cosigner
-		var cosigner models.Cosigner
-		if err := config.DB.Where("charity_id = ? ", charity.ID).First(&cosigner).Error; err != nil {
-			return c.Status(403).JSON(fiber.Map{
+	isSigner, _, err := policy.IsCharitySigner(config.DB, policy.FromContext(c), &charity)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if !isSigner {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to view transaction approvals for this charity",
+		})
+	}
+
+	// Validate the status filter
+	statuses := strings.Split(c.Query("status", models.ApprovalStatusPending), ",")
+	for _, status := range statuses {
+		if !approvalStatuses[status] {
+			return c.Status(400).JSON(fiber.Map{
 				"status":  "error",
-				"message": "You are not authorized to view transaction approvals for this charity",
+				"message": "Invalid approval status: " + status,
 			})
 		}
 	}
 
-	// Get pending approvals
+	// Get approvals
 	var approvals []models.TransactionApproval
-	if err := config.DB.Where("charity_id = ? AND status = ?", charity.ID, "pending").Find(&approvals).Error; err != nil {
+	if err := config.DB.Where("charity_id = ? AND status IN ?", charity.ID, statuses).Order("created_at desc").Find(&approvals).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not fetch transaction approvals",
@@ -95,11 +144,24 @@ func CreateTransactionApproval(c *fiber.Ctx) error {
 	}
 
 	// Validate required fields
-	if input.Amount == "" || input.Description == "" {
+	if !input.Amount.IsPositive() || input.Description == "" || input.Destination == "" {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Amount and description are required",
-			"error":   err.Error(),
+			"message": "A positive amount, description and destination are required",
+		})
+	}
+
+	if input.VerificationQuorum < 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Verification quorum cannot be negative",
+		})
+	}
+
+	if _, err := keypair.ParseAddress(input.Destination); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Destination must be a valid Stellar address",
 		})
 	}
 
@@ -115,42 +177,158 @@ func CreateTransactionApproval(c *fiber.Ctx) error {
 
 	// Check if user is owner or cosigner of the charity
 	userID := c.Locals("userID").(uint)
-	email := c.Locals("email").(string)
-	var isAuthorized bool
+	isSigner, _, err := policy.IsCharitySigner(config.DB, policy.FromContext(c), &charity)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
 
-	if charity.OwnerID == userID {
-		isAuthorized = true
+	if !isSigner {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to create transaction approvals for this charity",
+		})
+	}
+
+	// Payments can only be made in assets the charity holds
+	asset := input.Asset.Normalized()
+	if _, err := services.StellarAsset(asset); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	accepted, err := services.CharityAcceptsAsset(config.DB, charity.ID, asset)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check accepted assets",
+			"error":   err.Error(),
+		})
+	}
+	if !accepted {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity does not hold " + asset.Canonical(),
+		})
+	}
+
+	// The payment is spent from a budget category of the charity, if one is given
+	var budgetCategory models.BudgetCategory
+	if input.CategoryID != 0 {
+		if err := config.DB.Where("charity_id = ?", charity.ID).First(&budgetCategory, input.CategoryID).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Budget category not found",
+			})
+		}
+	}
+
+	requiredSignatures, err := budgetSignatures(&charity, input.CategoryID, input.Amount, asset, charity.RequiredSignatures)
+	if err != nil {
+		return err
+	}
+
+	// Escrowed approvals pay into an escrow account of their own, which the wallet key and
+	// the cosigners' keys control; milestones release the funds to the destination
+	var tx *txnbuild.Transaction
+	var escrow *keypair.Full
+	if input.Escrow {
+		var cosigners []models.Cosigner
+		if err := config.DB.Where("charity_id = ? AND status = ? AND public_key <> ''", charity.ID, models.CosignerStatusActive).Find(&cosigners).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not fetch cosigners",
+			})
+		}
+		signers := []string{charity.WalletAddress}
+		for _, cosigner := range cosigners {
+			signers = append(signers, cosigner.PublicKey)
+		}
+		threshold, err := services.EscrowThreshold(charity.RequiredSignatures, len(signers))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": err.Error(),
+			})
+		}
+		escrow = keypair.MustRandom()
+		tx, err = services.BuildEscrowedPayment(charity.WalletAddress, escrow.Address(), input.Amount, asset, signers, threshold, services.ApprovalEnvelopeTimeout)
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not build transaction envelope",
+				"error":   err.Error(),
+			})
+		}
 	} else {
-		// Check if user is a cosigner
-		var cosigner models.Cosigner
-		if err := config.DB.Where("charity_id = ? AND email = ?", charity.ID, email).First(&cosigner).Error; err == nil {
-			isAuthorized = true
+		// Build the unsigned payment envelope that cosigners sign client-side
+		tx, err = services.BuildPayment(charity.WalletAddress, input.Destination, input.Amount, asset, services.ApprovalEnvelopeTimeout)
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not build transaction envelope",
+				"error":   err.Error(),
+			})
 		}
 	}
 
-	if !isAuthorized {
-		return c.Status(403).JSON(fiber.Map{
+	envelopeXDR, envelopeHash, err := services.EncodeEnvelope(tx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
-			"message": "You are not authorized to create transaction approvals for this charity",
+			"message": "Could not encode transaction envelope",
 		})
 	}
 
 	// Convert userID to string for storage
 	userIDStr := strconv.FormatUint(uint64(userID), 10)
 
+	expiresAt := approvalExpiry(&charity)
+
 	// Create transaction approval
 	approval := models.TransactionApproval{
 		CharityID:          charity.ID,
+		Kind:               models.ApprovalKindPayment,
 		Amount:             input.Amount,
+		Asset:              asset,
 		Description:        input.Description,
-		Category:           input.Category,
+		CategoryID:         budgetCategory.ID,
+		Category:           budgetCategory.Name,
+		Destination:        input.Destination,
 		RequestedByID:      userIDStr,
-		RequiredSignatures: charity.RequiredSignatures,
+		RequiredSignatures: requiredSignatures,
 		CurrentSignatures:  0,
-		Status:             "pending",
+		Status:             models.ApprovalStatusPending,
+		EnvelopeXDR:        envelopeXDR,
+		EnvelopeHash:       envelopeHash,
+		Escrowed:           input.Escrow,
+		OverBudget:         requiredSignatures > charity.RequiredSignatures,
+		ExpiresAt:          &expiresAt,
+		VerificationQuorum: input.VerificationQuorum,
+		RequireIndependent: input.RequireIndependent,
 	}
 
-	if err := config.DB.Create(&approval).Error; err != nil {
+	// The escrow key is encrypted for the approval's row, so it is stored once the row has an ID
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if escrow == nil {
+			return tx.Create(&approval).Error
+		}
+		approval.EscrowAddress = escrow.Address()
+		approval.EscrowSecret = "pending:" + escrow.Address()
+		if err := tx.Create(&approval).Error; err != nil {
+			return err
+		}
+		secret, err := services.EncryptSecret(escrow.Seed(), services.SecretRow("transaction_approvals", approval.ID))
+		if err != nil {
+			return err
+		}
+		approval.EscrowSecret = secret
+		return tx.Model(&approval).Update("escrow_secret", secret).Error
+	})
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Could not create transaction approval",
@@ -187,16 +365,13 @@ func AddApprovalSignature(c *fiber.Ctx) error {
 	}
 
 	// Check if approval is still pending
-	if approval.Status != "pending" {
+	if approval.Status != models.ApprovalStatusPending || approvalExpired(&approval) {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Transaction approval is no longer pending",
 		})
 	}
 
-	// Check if user is authorized to sign
-	userID := c.Locals("userID").(uint)
-
 	// Get charity
 	var charity models.Charity
 	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
@@ -206,94 +381,198 @@ func AddApprovalSignature(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get email from context or from request body
-	var email string
-	emailInterface := c.Locals("email")
-	if emailInterface != nil {
-		email = emailInterface.(string)
+	// Check if user is owner or an active cosigner of the charity
+	principal := policy.FromContext(c)
+	isSigner, cosigner, err := policy.IsCharitySigner(config.DB, principal, &charity)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
 	}
 
-	// If email is provided in the request body, use that instead
-	if input.Email != "" {
-		email = input.Email
+	if !isSigner {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to sign this transaction approval",
+		})
 	}
 
-	// Ensure we have an email
-	if email == "" {
+	if approval.EnvelopeXDR == "" {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Email is required for authorization",
+			"message": "Transaction approval has no envelope to sign",
 		})
 	}
 
-	var isAuthorized bool
-	if charity.OwnerID == userID {
-		isAuthorized = true
+	// The owner signs with the charity wallet key, cosigners sign the envelope client-side
+	// with their own key
+	var signatureXDR string
+	signerKey := charity.WalletAddress
+	if cosigner == nil {
+		signed, err := services.SignEnvelope(approval.EnvelopeXDR, services.CharityWalletSecret(&charity))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not sign transaction envelope",
+			})
+		}
+		signatureXDR = signed
 	} else {
-		// Check if user is a cosigner
-		var cosigner models.Cosigner
-		if err := config.DB.Where("charity_id = ? AND email = ?", charity.ID, email).First(&cosigner).Error; err == nil {
-			isAuthorized = true
+		if cosigner.PublicKey == "" || cosigner.PublicKey == charity.WalletAddress {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Cosigner has no Stellar public key of their own to verify the signature against",
+			})
 		}
-	}
-
-	if !isAuthorized {
-		return c.Status(403).JSON(fiber.Map{
-			"status":  "error",
-			"message": "You are not authorized to sign this transaction approval",
-		})
+		if _, err := services.VerifySignature(approval.EnvelopeXDR, cosigner.PublicKey, input.Signature); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid signature",
+				"error":   err.Error(),
+			})
+		}
+		signatureXDR = input.Signature
+		signerKey = cosigner.PublicKey
 	}
 
 	// Convert userID to string for storage
-	userIDStr := strconv.FormatUint(uint64(userID), 10)
+	userIDStr := strconv.FormatUint(uint64(principal.UserID), 10)
+
+	// Create signature and its audit record, and count the signatures in the
+	// same transaction so concurrent signers cannot overwrite each other's count
+	signature := models.ApprovalSignature{
+		ApprovalID: approval.ID,
+		SignerID:   userIDStr,
+		SignerKey:  signerKey,
+		Signature:  signatureXDR,
+	}
 
-	// Check if user has already signed
-	var existingSignature models.ApprovalSignature
-	if err := config.DB.Where("approval_id = ? AND signer_id = ?", approval.ID, userIDStr).First(&existingSignature).Error; err == nil {
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordApprovalSignature(tx, &approval, &signature); err != nil {
+			return err
+		}
+		return tx.Create(&models.AuditRecord{
+			UserID:    principal.FirebaseID,
+			Event:     "Approval Signed",
+			Details:   fmt.Sprintf("Transaction approval #%d signed by user #%d with key %s", approval.ID, principal.UserID, signerKey),
+			IPAddress: c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Timestamp: time.Now(),
+		}).Error
+	})
+	switch {
+	case errors.Is(err, errAlreadySigned):
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
 			"message": "You have already signed this transaction approval",
 		})
+	case errors.Is(err, errAlreadyVoted):
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You have already rejected this transaction approval",
+		})
+	case errors.Is(err, errApprovalNotPending):
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval is no longer pending",
+		})
+	case err != nil:
+		return transitionFailed(c, err, "Could not add signature")
 	}
 
-	// Create signature
-	signature := models.ApprovalSignature{
-		ApprovalID: approval.ID,
-		SignerID:   userIDStr,
-		Signature:  input.Signature,
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data":   approval,
+	})
+}
+
+var (
+	errAlreadySigned      = errors.New("approval already signed by this signer")
+	errAlreadyVoted       = errors.New("signer already voted on this approval")
+	errApprovalNotPending = errors.New("approval is no longer pending")
+)
+
+// recordApprovalSignature inserts a signature and recounts the approval's
+// signatures from the signature rows, approving it once the threshold is met.
+// It must run inside a transaction.
+func recordApprovalSignature(tx *gorm.DB, approval *models.TransactionApproval, signature *models.ApprovalSignature) error {
+	// A key shared by two cosigners must not count twice
+	var existing int64
+	if err := tx.Model(&models.ApprovalSignature{}).
+		Where("approval_id = ? AND (signer_id = ? OR signer_key = ?)", approval.ID, signature.SignerID, signature.SignerKey).
+		Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return errAlreadySigned
 	}
 
-	if err := config.DB.Create(&signature).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Could not add signature",
-		})
+	// Signers who rejected the approval cannot also approve it
+	if err := tx.Model(&models.ApprovalRejection{}).
+		Where("approval_id = ? AND signer_id = ?", approval.ID, signature.SignerID).
+		Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return errAlreadyVoted
 	}
 
-	// Update approval
-	approval.CurrentSignatures = approval.CurrentSignatures + 1
+	if err := tx.Create(signature).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errAlreadySigned
+		}
+		return err
+	}
 
-	// Check if approval is complete
-	if approval.CurrentSignatures >= approval.RequiredSignatures {
-		approval.Status = "approved"
+	var count int64
+	if err := tx.Model(&models.ApprovalSignature{}).Where("approval_id = ?", approval.ID).Count(&count).Error; err != nil {
+		return err
 	}
 
-	if err := config.DB.Save(&approval).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Could not update transaction approval",
-		})
+	// Only a pending approval can collect signatures
+	result := tx.Model(&models.TransactionApproval{}).
+		Where("id = ? AND status = ?", approval.ID, models.ApprovalStatusPending).
+		Update("current_signatures", count)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errApprovalNotPending
 	}
+	approval.CurrentSignatures = int(count)
 
-	return c.Status(200).JSON(fiber.Map{
-		"status": "success",
-		"data":   approval,
+	if int(count) < approval.RequiredSignatures {
+		return nil
+	}
+
+	err := statemachine.Approvals.Fire(tx, statemachine.Transition{
+		ID:      approval.ID,
+		From:    models.ApprovalStatusPending,
+		To:      models.ApprovalStatusApproved,
+		ActorID: signature.SignerID,
+		Reason:  "Signature threshold reached",
 	})
+	if err != nil {
+		return err
+	}
+	approval.Status = models.ApprovalStatusApproved
+	return nil
 }
 
-// ExecuteApproval executes an approved transaction
-func ExecuteApproval(c *fiber.Ctx) error {
+// RejectApproval records a cosigner's vote against a pending approval and
+// rejects it when the charity's veto rule is met
+func RejectApproval(c *fiber.Ctx) error {
 	approvalID := c.Params("approvalId")
+	input := new(RejectApprovalInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
 
 	// Check if approval exists
 	var approval models.TransactionApproval
@@ -304,11 +583,11 @@ func ExecuteApproval(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if approval is approved
-	if approval.Status != "approved" {
+	// Check if approval is still pending
+	if approval.Status != models.ApprovalStatusPending || approvalExpired(&approval) {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Transaction approval is not yet approved",
+			"message": "Transaction approval is no longer pending",
 		})
 	}
 
@@ -317,45 +596,59 @@ func ExecuteApproval(c *fiber.Ctx) error {
 	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not fetch charity details",
+			"message": "Could not verify charity",
 		})
 	}
 
-	// Check if user is owner
-	userID := c.Locals("userID").(uint)
-	if charity.OwnerID != userID {
-		return c.Status(403).JSON(fiber.Map{
+	// Check if user is owner or an active cosigner of the charity
+	principal := policy.FromContext(c)
+	isSigner, _, err := policy.IsCharitySigner(config.DB, principal, &charity)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Only the charity owner can execute transactions",
+			"message": "Could not check cosigners",
 		})
 	}
 
-	// Execute transaction
-	// This would involve sending funds on the Stellar network
-	// For now, we'll just update the status and add a mock transaction hash
-
-	// In a real implementation, this would use the Stellar service to send the transaction
-	approval.Status = "executed"
-	approval.TxHash = "mock-transaction-hash-" + approvalID // This would be a real transaction hash
-
-	if err := config.DB.Save(&approval).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
+	if !isSigner {
+		return c.Status(403).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not update transaction status",
+			"message": "You are not authorized to reject this transaction approval",
 		})
 	}
 
-	// Update spent amount in budget category
-	if approval.Category != "" {
-		var budgetCategory models.BudgetCategory
-		if err := config.DB.Where("charity_id = ? AND name = ?", charity.ID, approval.Category).First(&budgetCategory).Error; err == nil {
-			// Convert amount string to float64
-			amount, err := strconv.ParseFloat(approval.Amount, 64)
-			if err == nil {
-				budgetCategory.Spent += amount
-				config.DB.Save(&budgetCategory)
-			}
+	rejection := models.ApprovalRejection{
+		ApprovalID: approval.ID,
+		SignerID:   strconv.FormatUint(uint64(principal.UserID), 10),
+		Reason:     input.Reason,
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordApprovalRejection(tx, &charity, &approval, &rejection); err != nil {
+			return err
 		}
+		return tx.Create(&models.AuditRecord{
+			UserID:    principal.FirebaseID,
+			Event:     "Approval Rejected",
+			Details:   fmt.Sprintf("Transaction approval #%d rejected by user #%d: %s", approval.ID, principal.UserID, input.Reason),
+			IPAddress: c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Timestamp: time.Now(),
+		}).Error
+	})
+	switch {
+	case errors.Is(err, errAlreadyVoted), errors.Is(err, errAlreadySigned):
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You have already voted on this transaction approval",
+		})
+	case errors.Is(err, errApprovalNotPending):
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval is no longer pending",
+		})
+	case err != nil:
+		return transitionFailed(c, err, "Could not reject transaction approval")
 	}
 
 	return c.Status(200).JSON(fiber.Map{
@@ -364,8 +657,72 @@ func ExecuteApproval(c *fiber.Ctx) error {
 	})
 }
 
-// RefundUnspentFunds returns unspent funds to the donor
-func RefundUnspentFunds(c *fiber.Ctx) error {
+// recordApprovalRejection inserts a reject vote and applies the charity's veto
+// rule to the approval. It must run inside a transaction.
+func recordApprovalRejection(tx *gorm.DB, charity *models.Charity, approval *models.TransactionApproval, rejection *models.ApprovalRejection) error {
+	// Only a pending approval can collect votes
+	var current models.TransactionApproval
+	if err := tx.Select("id", "status").First(&current, approval.ID).Error; err != nil {
+		return err
+	}
+	if current.Status != models.ApprovalStatusPending {
+		return errApprovalNotPending
+	}
+
+	var existing int64
+	if err := tx.Model(&models.ApprovalSignature{}).
+		Where("approval_id = ? AND signer_id = ?", approval.ID, rejection.SignerID).
+		Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return errAlreadySigned
+	}
+
+	if err := tx.Create(rejection).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errAlreadyVoted
+		}
+		return err
+	}
+
+	var rejections int64
+	if err := tx.Model(&models.ApprovalRejection{}).Where("approval_id = ?", approval.ID).Count(&rejections).Error; err != nil {
+		return err
+	}
+
+	rejected := true
+	if charity.VetoRule == models.VetoRuleBlocking {
+		// The owner and every active cosigner with a key can sign
+		var signers int64
+		if err := tx.Model(&models.Cosigner{}).
+			Where("charity_id = ? AND status = ? AND public_key <> ''", charity.ID, models.CosignerStatusActive).
+			Count(&signers).Error; err != nil {
+			return err
+		}
+		rejected = signers+1-rejections < int64(approval.RequiredSignatures)
+	}
+
+	if !rejected {
+		return nil
+	}
+
+	err := statemachine.Approvals.Fire(tx, statemachine.Transition{
+		ID:      approval.ID,
+		From:    models.ApprovalStatusPending,
+		To:      models.ApprovalStatusRejected,
+		ActorID: rejection.SignerID,
+		Reason:  "Veto rule " + charity.VetoRule + " met",
+	})
+	if err != nil {
+		return err
+	}
+	approval.Status = models.ApprovalStatusRejected
+	return nil
+}
+
+// CancelApproval lets the requester withdraw an approval that has not been executed
+func CancelApproval(c *fiber.Ctx) error {
 	approvalID := c.Params("approvalId")
 
 	// Check if approval exists
@@ -377,86 +734,870 @@ func RefundUnspentFunds(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if approval was executed
-	if approval.Status != "executed" {
-		return c.Status(400).JSON(fiber.Map{
+	// Only the requester can cancel
+	principal := policy.FromContext(c)
+	userIDStr := strconv.FormatUint(uint64(principal.UserID), 10)
+	if approval.RequestedByID != userIDStr {
+		return c.Status(403).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Only executed transactions can be refunded",
+			"message": "Only the requester can cancel this transaction approval",
 		})
 	}
 
-	// Get charity
-	var charity models.Charity
-	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
+	if !statemachine.Approvals.Can(approval.Status, models.ApprovalStatusCancelled) {
+		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not fetch charity details",
+			"message": "Only pending or approved transaction approvals can be cancelled",
 		})
 	}
 
-	// Check if user is owner
-	userID := c.Locals("userID").(uint)
-	if charity.OwnerID != userID {
-		return c.Status(403).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Only the charity owner can initiate refunds",
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		err := statemachine.Approvals.Fire(tx, statemachine.Transition{
+			ID:      approval.ID,
+			From:    approval.Status,
+			To:      models.ApprovalStatusCancelled,
+			ActorID: userIDStr,
+			Reason:  "Cancelled by the requester",
 		})
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.AuditRecord{
+			UserID:    principal.FirebaseID,
+			Event:     "Approval Cancelled",
+			Details:   fmt.Sprintf("Transaction approval #%d cancelled by user #%d", approval.ID, principal.UserID),
+			IPAddress: c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Timestamp: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return transitionFailed(c, err, "Could not cancel transaction approval")
 	}
 
-	// Get all milestones for this approval
-	var milestones []models.Milestone
-	if err := config.DB.Where("approval_id = ?", approval.ID).Find(&milestones).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Could not fetch milestones",
-		})
+	approval.Status = models.ApprovalStatusCancelled
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data":   approval,
+	})
+}
+
+// approvalExpiry returns when a new approval of charity expires: after the charity's
+// window, before the envelope's time bounds run out
+func approvalExpiry(charity *models.Charity) time.Time {
+	ttlHours := charity.ApprovalTTLHours
+	if ttlHours <= 0 {
+		ttlHours = models.DefaultApprovalTTLHours
 	}
+	return time.Now().Add(time.Duration(ttlHours) * time.Hour)
+}
 
-	// Calculate total amount spent on released milestones
-	var totalSpent float64 = 0
-	for _, milestone := range milestones {
-		if milestone.Status == "released" {
-			amount, err := strconv.ParseFloat(milestone.Amount, 64)
-			if err == nil {
-				totalSpent += amount
-			}
-		}
+// approvalExpired reports whether an approval is past its expiry, even if the
+// expiry worker has not marked it yet
+func approvalExpired(approval *models.TransactionApproval) bool {
+	return approval.ExpiresAt != nil && time.Now().After(*approval.ExpiresAt)
+}
+
+// GetApprovalHistory returns the status transitions of an approval and its milestones
+func GetApprovalHistory(c *fiber.Ctx) error {
+	approvalID := c.Params("approvalId")
+
+	// Check if approval exists
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, approvalID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval not found",
+		})
 	}
 
-	// Calculate total approval amount
-	totalAmount, err := strconv.ParseFloat(approval.Amount, 64)
+	// Only members of the charity can read its history
+	member, err := policy.IsCharityMember(config.DB, policy.FromContext(c), approval.CharityID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not parse approval amount",
+			"message": "Could not check cosigners",
 		})
 	}
-
-	// Calculate refund amount
-	refundAmount := totalAmount - totalSpent
-	if refundAmount <= 0 {
+	if !member {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to view this transaction approval",
+		})
+	}
+
+	var transitions []models.StatusTransition
+	err = config.DB.
+		Where("entity = ? AND entity_id = ?", statemachine.Approvals.Entity(), approval.ID).
+		Or("entity = ? AND entity_id IN (?)", statemachine.Milestones.Entity(), config.DB.Model(&models.Milestone{}).Select("id").Where("approval_id = ?", approval.ID)).
+		Order("created_at, id").
+		Find(&transitions).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch approval history",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data":   transitions,
+	})
+}
+
+// GetApprovalFunding shows which donations paid for an executed approval and
+// what was refunded to each donor
+func GetApprovalFunding(c *fiber.Ctx) error {
+	approvalID := c.Params("approvalId")
+
+	// Check if approval exists
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, approvalID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval not found",
+		})
+	}
+
+	// Only members of the charity can see its donors
+	member, err := policy.IsCharityMember(config.DB, policy.FromContext(c), approval.CharityID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if !member {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to view this transaction approval",
+		})
+	}
+
+	var attributions []models.FundAttribution
+	if err := config.DB.Preload("Donation").Where("approval_id = ?", approval.ID).Order("id").Find(&attributions).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch fund attributions",
+		})
+	}
+
+	var refunds []models.Refund
+	if err := config.DB.Where("approval_id = ?", approval.ID).Order("id").Find(&refunds).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch refunds",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"attributions": attributions,
+			"refunds":      refunds,
+		},
+	})
+}
+
+// transitionFailed responds to an error returned while changing a status
+func transitionFailed(c *fiber.Ctx, err error, message string) error {
+	var transitionErr *statemachine.TransitionError
+	if errors.As(err, &transitionErr) {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": message,
+			"error":   transitionErr.Error(),
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+	})
+}
+
+// transitionError converts an error returned while changing a status into a *fiber.Error
+func transitionError(err error, message string) error {
+	var transitionErr *statemachine.TransitionError
+	if errors.As(err, &transitionErr) {
+		return fiber.NewError(409, message+": "+transitionErr.Error())
+	}
+	return fiber.NewError(500, message)
+}
+
+// budgetSignatures applies the charity's overspend rule to a payment from a budget
+// category. It returns the signatures the payment needs: required when it stays within
+// the budget and every signer when it overspends under the quorum rule. Overspending
+// under the block rule is refused, and so is spending from a category that was deleted
+// or whose fiscal period is closed or not current. The returned error is a *fiber.Error.
+func budgetSignatures(charity *models.Charity, categoryID uint, amount models.Money, asset models.Asset, required int) (int, error) {
+	err := services.CheckSpend(config.DB, charity, categoryID, amount, asset)
+	var overspend *services.OverspendError
+	if err == nil {
+		return required, nil
+	}
+	if errors.Is(err, services.ErrPeriodClosed) || errors.Is(err, services.ErrPeriodNotCurrent) || errors.Is(err, services.ErrCategoryDeleted) {
+		return 0, fiber.NewError(400, "Cannot spend from this budget category: "+err.Error())
+	}
+	if !errors.As(err, &overspend) {
+		return 0, fiber.NewError(500, "Could not check the budget")
+	}
+	if charity.OverspendRule != models.OverspendRuleQuorum {
+		return 0, fiber.NewError(400, "Payment would overspend its budget: "+err.Error())
+	}
+
+	signers, err := services.CharitySignerIDs(config.DB, charity.ID)
+	if err != nil {
+		return 0, fiber.NewError(500, "Could not check cosigners")
+	}
+	return max(required, len(signers)), nil
+}
+
+// ExecuteApproval executes an approved transaction
+func ExecuteApproval(c *fiber.Ctx) error {
+	approvalID := c.Params("approvalId")
+
+	// Check if approval exists
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, approvalID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval not found",
+		})
+	}
+
+	if approval.Status == models.ApprovalStatusExecuting {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The payment was already submitted and is waiting for the Stellar network",
+		})
+	}
+
+	// Check if approval is approved
+	if !statemachine.Approvals.Can(approval.Status, models.ApprovalStatusExecuting) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval is not yet approved",
+		})
+	}
+
+	if approvalExpired(&approval) {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "No funds available for refund",
+			"message": "Transaction approval has expired",
 		})
 	}
 
-	// Update approval status
-	approval.Status = "refunded"
-	if err := config.DB.Save(&approval).Error; err != nil {
+	// Get charity
+	var charity models.Charity
+	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Could not update approval status",
+			"message": "Could not fetch charity details",
 		})
 	}
 
-	// In a real implementation, this would use the Stellar service to send the funds back
-	// to the original donor, but for now we'll just update the status
+	// Check if user is owner
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can execute transactions",
+		})
+	}
+
+	err := executeApproval(&approval, &charity, strconv.FormatUint(uint64(userID), 10))
+	if errors.Is(err, errEnvelopeRenewed) {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": errEnvelopeRenewed.Message,
+			"data":    approval,
+		})
+	}
+	if err != nil {
+		return err
+	}
 
 	return c.Status(200).JSON(fiber.Map{
-		"status":       "success",
-		"message":      "Unspent funds refunded successfully",
-		"refundAmount": refundAmount,
-		"data":         approval,
+		"status": "success",
+		"data":   approval,
+	})
+}
+
+// errEnvelopeRenewed is returned by executeApproval when other transactions of the wallet
+// used up the envelope's sequence number. The envelope was rebuilt and goes back to the
+// cosigners to be signed again.
+var errEnvelopeRenewed = fiber.NewError(409, "The charity wallet has made other transactions since the approval was signed, the transaction was rebuilt and must be signed again")
+
+// executeApproval submits an approved approval with its collected signatures, plus the
+// wallet's own signature, and marks it executed. The transaction hash is stored before
+// submitting, so a transaction whose response is lost is found on the network instead
+// of being submitted again. Errors are *fiber.Error with the response status.
+func executeApproval(approval *models.TransactionApproval, charity *models.Charity, actorID string) error {
+	var signatures []models.ApprovalSignature
+	if err := config.DB.Where("approval_id = ?", approval.ID).Find(&signatures).Error; err != nil {
+		return fiber.NewError(500, "Could not fetch signatures")
+	}
+
+	// Check the budget again, other payments may have used it up since the approval was created
+	if approval.Kind == models.ApprovalKindPayment {
+		requiredSignatures, err := budgetSignatures(charity, approval.CategoryID, approval.Amount, approval.Asset, approval.RequiredSignatures)
+		if err != nil {
+			return err
+		}
+		if len(signatures) < requiredSignatures {
+			return fiber.NewError(409, "Transaction approval now overspends its budget category and needs the signature of every signer, cancel it and request it again")
+		}
+	}
+
+	collected := make([]string, 0, len(signatures))
+	for _, signature := range signatures {
+		collected = append(collected, signature.Signature)
+	}
+
+	err := services.CheckEnvelopeSequence(approval.EnvelopeXDR)
+	if errors.Is(err, services.ErrStaleEnvelope) {
+		if err := renewApprovalEnvelope(approval, actorID); err != nil {
+			return err
+		}
+		return errEnvelopeRenewed
+	}
+	if err != nil {
+		return fiber.NewError(502, "Could not check the charity wallet on the Stellar network: "+err.Error())
+	}
+
+	err = statemachine.Approvals.Fire(config.DB, statemachine.Transition{
+		ID:      approval.ID,
+		From:    approval.Status,
+		To:      models.ApprovalStatusExecuting,
+		ActorID: actorID,
+		Reason:  "Transaction submitted",
+		Updates: map[string]interface{}{"tx_hash": approval.EnvelopeHash},
 	})
+	if err != nil {
+		return transitionError(err, "Could not update transaction status")
+	}
+	approval.Status = models.ApprovalStatusExecuting
+	approval.TxHash = approval.EnvelopeHash
+
+	result, err := services.SubmitWithSignatures(approval.EnvelopeXDR, collected, approvalSecrets(approval, charity)...)
+	if err != nil {
+		outcome, outcomeErr := services.EnvelopeOutcome(approval.EnvelopeXDR)
+		switch {
+		case outcomeErr == nil && outcome == services.EnvelopeLanded:
+			result = &services.SubmitResult{Hash: approval.EnvelopeHash}
+		case outcomeErr == nil && outcome == services.EnvelopeFailed:
+			fireErr := statemachine.Approvals.Fire(config.DB, statemachine.Transition{
+				ID:      approval.ID,
+				From:    models.ApprovalStatusExecuting,
+				To:      models.ApprovalStatusApproved,
+				ActorID: actorID,
+				Reason:  "Transaction was not accepted by the network",
+				Updates: map[string]interface{}{"tx_hash": ""},
+			})
+			if fireErr != nil {
+				log.Printf("Could not return approval #%d to approved: %v", approval.ID, fireErr)
+			} else {
+				approval.Status = models.ApprovalStatusApproved
+				approval.TxHash = ""
+			}
+			return fiber.NewError(502, "Could not submit the transaction to the Stellar network: "+err.Error())
+		default:
+			// The approval stays executing until the transaction is found or can no longer land
+			return fiber.NewError(502, "The transaction was submitted but its outcome is not known yet, it will be reconciled with the Stellar network: "+err.Error())
+		}
+	}
+
+	// Mark the approval executed; the transition applies its effects, such as the budget spend
+	executedAt := time.Now()
+	err = statemachine.Approvals.Fire(config.DB, statemachine.Transition{
+		ID:      approval.ID,
+		From:    approval.Status,
+		To:      models.ApprovalStatusExecuted,
+		ActorID: actorID,
+		Reason:  "Transaction confirmed by the network",
+		Updates: map[string]interface{}{"tx_hash": result.Hash, "ledger": result.Ledger, "executed_at": executedAt},
+	})
+	if err != nil {
+		return transitionError(err, "Could not update transaction status")
+	}
+
+	approval.Status = models.ApprovalStatusExecuted
+	approval.TxHash = result.Hash
+	approval.Ledger = result.Ledger
+	approval.ExecutedAt = &executedAt
+	return nil
+}
+
+// approvalSecrets returns the stored secrets the server adds its signatures to an
+// approval's envelope with: the charity wallet's, and the escrow key of an escrowed
+// payment, which creates its escrow account
+func approvalSecrets(approval *models.TransactionApproval, charity *models.Charity) []services.Secret {
+	secrets := []services.Secret{services.CharityWalletSecret(charity)}
+	if approval.Kind == models.ApprovalKindPayment && approval.EscrowSecret != "" {
+		secrets = append(secrets, services.ApprovalEscrowSecret(approval))
+	}
+	return secrets
+}
+
+// proposeWalletChange creates an approval for a transaction the server builds itself,
+// such as a signer update, a trustline or a refund, and signs it with the wallet key on
+// behalf of the owner. prepare, when set, runs in the database transaction that creates
+// the approval. The approval is executed right away when that signature is all the
+// transaction needs; otherwise cosigners sign it like a payment and the owner executes it.
+// Errors are *fiber.Error with the response status.
+func proposeWalletChange(charity *models.Charity, approval *models.TransactionApproval, userID uint, prepare func(tx *gorm.DB) error) error {
+	actorID := strconv.FormatUint(uint64(userID), 10)
+	expiresAt := approvalExpiry(charity)
+	approval.CharityID = charity.ID
+	approval.RequestedByID = actorID
+	approval.Status = models.ApprovalStatusPending
+	approval.ExpiresAt = &expiresAt
+
+	signatureXDR, err := services.SignEnvelope(approval.EnvelopeXDR, services.CharityWalletSecret(charity))
+	if err != nil {
+		return fiber.NewError(500, "Could not sign transaction envelope")
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if prepare != nil {
+			if err := prepare(tx); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(approval).Error; err != nil {
+			return err
+		}
+		return recordApprovalSignature(tx, approval, &models.ApprovalSignature{
+			ApprovalID: approval.ID,
+			SignerID:   strconv.FormatUint(uint64(charity.OwnerID), 10),
+			SignerKey:  charity.WalletAddress,
+			Signature:  signatureXDR,
+		})
+	})
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return err
+	}
+	if err != nil {
+		return transitionError(err, "Could not create transaction approval")
+	}
+
+	if approval.Status != models.ApprovalStatusApproved {
+		return nil
+	}
+	return executeApproval(approval, charity, actorID)
+}
+
+// renewApprovalEnvelope rebuilds the envelope of an approved approval with the wallet's
+// current sequence number, drops the signatures of the old envelope and moves the
+// approval back to pending
+func renewApprovalEnvelope(approval *models.TransactionApproval, actorID string) error {
+	tx, err := services.RebuildEnvelope(approval.EnvelopeXDR, services.ApprovalEnvelopeTimeout)
+	if err != nil {
+		return fiber.NewError(502, "Could not rebuild transaction envelope: "+err.Error())
+	}
+	envelopeXDR, envelopeHash, err := services.EncodeEnvelope(tx)
+	if err != nil {
+		return fiber.NewError(500, "Could not encode transaction envelope")
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("approval_id = ?", approval.ID).Delete(&models.ApprovalSignature{}).Error; err != nil {
+			return err
+		}
+		return statemachine.Approvals.Fire(tx, statemachine.Transition{
+			ID:      approval.ID,
+			From:    approval.Status,
+			To:      models.ApprovalStatusPending,
+			ActorID: actorID,
+			Reason:  "Envelope sequence number used by another transaction, signatures collected again",
+			Updates: map[string]interface{}{"envelope_xdr": envelopeXDR, "envelope_hash": envelopeHash, "current_signatures": 0},
+		})
+	})
+	if err != nil {
+		return transitionError(err, "Could not rebuild transaction envelope")
+	}
+
+	approval.Status = models.ApprovalStatusPending
+	approval.EnvelopeXDR = envelopeXDR
+	approval.EnvelopeHash = envelopeHash
+	approval.CurrentSignatures = 0
+	return nil
+}
+
+// RefundUnspentFunds returns unspent funds to the donor
+func RefundUnspentFunds(c *fiber.Ctx) error {
+	approvalID := c.Params("approvalId")
+
+	// Check if approval exists
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, approvalID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Transaction approval not found",
+		})
+	}
+
+	// Check if approval was executed
+	if !statemachine.Approvals.Can(approval.Status, models.ApprovalStatusRefunding) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only executed transactions can be refunded",
+		})
+	}
+
+	// Get charity
+	var charity models.Charity
+	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch charity details",
+		})
+	}
+
+	// Check if user is owner
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can initiate refunds",
+		})
+	}
+
+	refundAmount, refunds, refundApproval, err := refundUnspentFunds(&approval, &charity, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	if approval.Status != models.ApprovalStatusRefunded {
+		return c.Status(202).JSON(fiber.Map{
+			"status":         "success",
+			"message":        "Refund requested, it is submitted once cosigners have signed the refund approval",
+			"refundAmount":   refundAmount,
+			"refunds":        refunds,
+			"refundApproval": refundApproval,
+			"data":           approval,
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status":         "success",
+		"message":        "Unspent funds refunded successfully",
+		"refundAmount":   refundAmount,
+		"refunds":        refunds,
+		"refundApproval": refundApproval,
+		"data":           approval,
+	})
+}
+
+// refundUnspentFunds refunds what an executed payment's released milestones have not
+// paid out to the donors that funded it. The refund is a refund approval proposed like a
+// wallet change. Before anything is submitted, the payment moves to refunding with the
+// refund's transaction hash and its refunds are recorded without one; the refund
+// approval's effects settle it once the refund lands, or return it to executed if the
+// refund is abandoned. A payment with nothing to pay back on the network is refunded
+// right away and has no refund approval. onRequested, when set, runs in the transaction
+// that records the refund. Errors are *fiber.Error with the response status.
+func refundUnspentFunds(approval *models.TransactionApproval, charity *models.Charity, userID uint, onRequested func(tx *gorm.DB) error) (models.Money, []models.Refund, *models.TransactionApproval, error) {
+	if approval.Kind != models.ApprovalKindPayment {
+		return 0, nil, nil, fiber.NewError(400, "Only payments can be refunded")
+	}
+
+	// The refund is whatever released milestones have not paid out
+	summary, err := services.SummarizeMilestones(config.DB, approval)
+	if err != nil {
+		return 0, nil, nil, fiber.NewError(500, "Could not fetch milestones")
+	}
+
+	refundAmount := summary.Remaining
+	if !refundAmount.IsPositive() {
+		return 0, nil, nil, fiber.NewError(400, "No funds available for refund")
+	}
+
+	// Each donor gets back their share of the unspent funds, in proportion to what
+	// their donations paid for the approval
+	shares, err := services.RefundShares(config.DB, approval, refundAmount)
+	if err != nil {
+		return 0, nil, nil, fiber.NewError(500, "Could not attribute unspent funds to donations")
+	}
+
+	// Donations recorded without a source account cannot be paid back, their share stays with the charity
+	var refunds []models.Refund
+	var payouts []services.Payout
+	var refunded models.Money
+	payoutIndex := make(map[string]int)
+	for _, share := range shares {
+		if share.Donation.SourceAccount == "" {
+			continue
+		}
+		refunds = append(refunds, models.Refund{
+			ApprovalID:  approval.ID,
+			DonationID:  share.Donation.ID,
+			DonorID:     share.Donation.DonorID,
+			Destination: share.Donation.SourceAccount,
+			Amount:      share.Amount,
+			Asset:       approval.Asset,
+		})
+		refunded += share.Amount
+
+		// Donors with several donations are paid once
+		if i, ok := payoutIndex[share.Donation.SourceAccount]; ok {
+			payouts[i].Amount += share.Amount
+			continue
+		}
+		payoutIndex[share.Donation.SourceAccount] = len(payouts)
+		payouts = append(payouts, services.Payout{Destination: share.Donation.SourceAccount, Amount: share.Amount})
+	}
+
+	// The escrow account of an escrowed payment refunds the donors, returns the rest to the
+	// charity wallet and is closed, once no release is under way. Funds paid straight to the
+	// destination are refunded from the charity wallet.
+	var refundTx *txnbuild.Transaction
+	requiredSignatures := 1
+	if approval.Escrowed {
+		var releasing int64
+		if err := config.DB.Model(&models.TransactionApproval{}).Where("parent_id = ? AND kind = ? AND status IN ?", approval.ID, models.ApprovalKindRelease,
+			[]string{models.ApprovalStatusPending, models.ApprovalStatusApproved, models.ApprovalStatusExecuting}).Count(&releasing).Error; err != nil {
+			return 0, nil, nil, fiber.NewError(500, "Could not fetch milestone releases")
+		}
+		if releasing > 0 {
+			return 0, nil, nil, fiber.NewError(409, "A milestone release from the escrow account is under way, cancel it or wait for it to be executed")
+		}
+
+		refundTx, err = services.BuildEscrowRefund(approval.EscrowAddress, charity.WalletAddress, approval.Asset, payouts, refundAmount-refunded, services.ApprovalEnvelopeTimeout)
+		if err == nil {
+			requiredSignatures, err = services.SignaturesNeeded(approval.EscrowAddress, charity.WalletAddress, true)
+		}
+	} else if len(payouts) > 0 {
+		refundTx, err = services.BuildPayouts(charity.WalletAddress, approval.Asset, payouts, services.ApprovalEnvelopeTimeout)
+		if err == nil {
+			requiredSignatures, err = services.SignaturesNeeded(charity.WalletAddress, charity.WalletAddress, false)
+		}
+	}
+	if errors.Is(err, services.ErrTooManyPayouts) {
+		return 0, nil, nil, fiber.NewError(400, "Too many donors to refund in a single transaction")
+	}
+	if err != nil {
+		return 0, nil, nil, fiber.NewError(502, "Could not build the refund transaction: "+err.Error())
+	}
+
+	// Move the approval on and record the refunds; the proposal accepting the refund is closed with them
+	actorID := strconv.FormatUint(uint64(userID), 10)
+	record := func(tx *gorm.DB, to string, updates map[string]interface{}) error {
+		err := statemachine.Approvals.Fire(tx, statemachine.Transition{
+			ID:      approval.ID,
+			From:    approval.Status,
+			To:      to,
+			ActorID: actorID,
+			Reason:  fmt.Sprintf("Refund of the unspent funds: %s, %s of it to %d donations", refundAmount, refunded, len(refunds)),
+			Updates: updates,
+		})
+		if err != nil {
+			return err
+		}
+		for i := range refunds {
+			if err := tx.Create(&refunds[i]).Error; err != nil {
+				return err
+			}
+		}
+		if onRequested != nil {
+			return onRequested(tx)
+		}
+		return nil
+	}
+
+	if refundTx == nil {
+		err := config.DB.Transaction(func(tx *gorm.DB) error {
+			return record(tx, models.ApprovalStatusRefunded, nil)
+		})
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			return 0, nil, nil, err
+		}
+		if err != nil {
+			return 0, nil, nil, transitionError(err, "Could not update approval status")
+		}
+		approval.Status = models.ApprovalStatusRefunded
+		return refundAmount, refunds, nil, nil
+	}
+
+	envelopeXDR, envelopeHash, err := services.EncodeEnvelope(refundTx)
+	if err != nil {
+		return 0, nil, nil, fiber.NewError(500, "Could not encode transaction envelope")
+	}
+	var paid models.Money
+	for _, payout := range payouts {
+		paid += payout.Amount
+	}
+	refundApproval := &models.TransactionApproval{
+		Kind:               models.ApprovalKindRefund,
+		ParentID:           approval.ID,
+		Amount:             paid,
+		Asset:              approval.Asset,
+		Description:        fmt.Sprintf("Refund of the unspent funds of transaction approval #%d", approval.ID),
+		RequiredSignatures: requiredSignatures,
+		EnvelopeXDR:        envelopeXDR,
+		EnvelopeHash:       envelopeHash,
+	}
+	err = proposeWalletChange(charity, refundApproval, userID, func(tx *gorm.DB) error {
+		return record(tx, models.ApprovalStatusRefunding, map[string]interface{}{"refund_tx_hash": envelopeHash})
+	})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	// The refund approval's effects settled the approval if it was executed right away
+	if err := config.DB.First(approval, approval.ID).Error; err != nil {
+		return 0, nil, nil, fiber.NewError(500, "Could not fetch approval details")
+	}
+	if approval.Status == models.ApprovalStatusRefunded {
+		for i := range refunds {
+			refunds[i].TxHash = approval.RefundTxHash
+		}
+	}
+	return refundAmount, refunds, refundApproval, nil
+}
+
+// GetRefundProposals lists the refund proposals opened for a charity's approvals
+func GetRefundProposals(c *fiber.Ctx) error {
+	charityID := c.Params("id")
+
+	// Check if charity exists
+	var charity models.Charity
+	if err := config.DB.First(&charity, charityID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Charity not found",
+		})
+	}
+
+	// Only members of the charity can see its proposals
+	member, err := policy.IsCharityMember(config.DB, policy.FromContext(c), charity.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not check cosigners",
+		})
+	}
+	if !member {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized to view this charity's refund proposals",
+		})
+	}
+
+	query := config.DB.Where("approval_id IN (?)", config.DB.Model(&models.TransactionApproval{}).Select("id").Where("charity_id = ?", charity.ID))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var proposals []models.RefundProposal
+	if err := query.Order("created_at desc").Find(&proposals).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch refund proposals",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   proposals,
+	})
+}
+
+// AcceptRefundProposal refunds the unspent funds of the proposal's approval
+func AcceptRefundProposal(c *fiber.Ctx) error {
+	return decideRefundProposal(c, models.RefundProposalAccepted)
+}
+
+// DismissRefundProposal closes a refund proposal without refunding
+func DismissRefundProposal(c *fiber.Ctx) error {
+	return decideRefundProposal(c, models.RefundProposalDismissed)
+}
+
+func decideRefundProposal(c *fiber.Ctx, decision string) error {
+	proposalID := c.Params("proposalId")
+
+	var proposal models.RefundProposal
+	if err := config.DB.First(&proposal, proposalID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Refund proposal not found",
+		})
+	}
+
+	if proposal.Status != models.RefundProposalOpen {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Refund proposal has already been " + proposal.Status,
+		})
+	}
+
+	var approval models.TransactionApproval
+	if err := config.DB.First(&approval, proposal.ApprovalID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch approval details",
+		})
+	}
+
+	var charity models.Charity
+	if err := config.DB.First(&charity, approval.CharityID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not fetch charity details",
+		})
+	}
+
+	// Check if user is owner
+	userID := c.Locals("userID").(uint)
+	if charity.OwnerID != userID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only the charity owner can decide on refund proposals",
+		})
+	}
+
+	// Close the proposal unless another request decided it first
+	decidedAt := time.Now()
+	closeProposal := func(tx *gorm.DB) error {
+		result := tx.Model(&proposal).Where("status = ?", models.RefundProposalOpen).Updates(map[string]interface{}{
+			"status":        decision,
+			"decided_by_id": strconv.FormatUint(uint64(userID), 10),
+			"decided_at":    decidedAt,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fiber.NewError(409, "Refund proposal was decided by another request")
+		}
+		return nil
+	}
+
+	response := fiber.Map{"status": "success"}
+	if decision == models.RefundProposalAccepted {
+		if !statemachine.Approvals.Can(approval.Status, models.ApprovalStatusRefunding) {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Only executed transactions can be refunded",
+			})
+		}
+		refundAmount, refunds, refundApproval, err := refundUnspentFunds(&approval, &charity, userID, closeProposal)
+		if err != nil {
+			return err
+		}
+		response["refundAmount"] = refundAmount
+		response["refunds"] = refunds
+		response["refundApproval"] = refundApproval
+	} else if err := closeProposal(config.DB); err != nil {
+		return err
+	}
+
+	proposal.Status = decision
+	proposal.DecidedByID = strconv.FormatUint(uint64(userID), 10)
+	proposal.DecidedAt = &decidedAt
+	response["data"] = proposal
+
+	return c.JSON(response)
 }
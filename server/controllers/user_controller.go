@@ -3,8 +3,15 @@ package controllers
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"cleargive/server/policy"
+	"cleargive/server/services"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/stellar/go/keypair"
+	"gorm.io/gorm"
 )
 
 type CreateUserInput struct {
@@ -24,10 +31,42 @@ func CreateUser(c *fiber.Ctx) error {
 	}
 
 	// Validate required fields
-	if input.FirebaseID == "" || input.Email == "" || input.Role == "" {
+	if input.FirebaseID == "" || input.Role == "" {
 		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "FirebaseID, email, and role are required",
+			"message": "FirebaseID and role are required",
+		})
+	}
+
+	// Privileged roles are assigned by an admin, not chosen at signup
+	if !policy.IsSelfAssignableRole(input.Role) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Role must be USER or CHARITY_OWNER",
+		})
+	}
+
+	// Users can only sign up for the account their ID token was issued to
+	if input.FirebaseID != c.Locals("firebaseID").(string) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "FirebaseID does not match the ID token",
+		})
+	}
+
+	// The email is taken from the ID token, so invitations sent to an address
+	// can only be accepted by its owner
+	email, _ := c.Locals("email").(string)
+	if email == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The ID token has no email address",
+		})
+	}
+	if input.Email != "" && !strings.EqualFold(input.Email, email) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Email does not match the ID token",
 		})
 	}
 
@@ -44,7 +83,7 @@ func CreateUser(c *fiber.Ctx) error {
 	// Create new user
 	user := models.User{
 		FirebaseID: input.FirebaseID,
-		Email:      input.Email,
+		Email:      email,
 		Role:       input.Role,
 	}
 
@@ -86,8 +125,13 @@ func GetUser(c *fiber.Ctx) error {
 	})
 }
 
+type StellarWalletInput struct {
+	PublicKey string `json:"publicKey"`
+	SecretKey string `json:"secretKey"`
+}
+
 type UpdateUserInput struct {
-	StellarWallet models.StellarAccount `json:"stellarWallet"`
+	StellarWallet StellarWalletInput `json:"stellarWallet"`
 }
 
 func UpdateUser(c *fiber.Ctx) error {
@@ -109,8 +153,37 @@ func UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Users can only change their own wallet
+	if user.ID != c.Locals("userID").(uint) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You can only update your own account",
+		})
+	}
+
+	// Verify the secret belongs to the public key
+	wallet, err := keypair.ParseFull(input.StellarWallet.SecretKey)
+	if err != nil || wallet.Address() != input.StellarWallet.PublicKey {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Secret key does not match the public key",
+		})
+	}
+
+	// Encrypt the secret before it is stored
+	secretKey, err := services.EncryptSecret(input.StellarWallet.SecretKey, services.SecretRow("users", user.ID))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not secure Stellar wallet",
+		})
+	}
+
 	// Update user's Stellar wallet
-	user.StellarWallet = input.StellarWallet
+	user.StellarWallet = models.StellarAccount{
+		PublicKey: input.StellarWallet.PublicKey,
+		SecretKey: secretKey,
+	}
 
 	if err := config.DB.Save(&user).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{
@@ -123,4 +196,71 @@ func UpdateUser(c *fiber.Ctx) error {
 		"status": "success",
 		"data":   user,
 	})
-} 
\ No newline at end of file
+}
+
+type AssignRoleInput struct {
+	Role string `json:"role"`
+}
+
+// AssignUserRole changes a user's role. Only admins can assign roles.
+func AssignUserRole(c *fiber.Ctx) error {
+	userID := c.Params("id")
+	input := new(AssignRoleInput)
+
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	if !policy.IsKnownRole(input.Role) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Unknown role",
+		})
+	}
+
+	var user models.User
+	if result := config.DB.First(&user, userID); result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User not found",
+		})
+	}
+
+	// Admins cannot remove their own admin role, so at least one admin remains
+	principal := policy.FromContext(c)
+	if user.ID == principal.UserID && input.Role != user.Role {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You cannot change your own role",
+		})
+	}
+
+	previousRole := user.Role
+	user.Role = input.Role
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", user.Role).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AuditRecord{
+			UserID:    user.FirebaseID,
+			Event:     "Role Assigned",
+			Details:   fmt.Sprintf("Role changed from %s to %s by user #%d", previousRole, user.Role, principal.UserID),
+			Timestamp: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not assign role",
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"status": "success",
+		"data":   user,
+	})
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"cleargive/server/config"
+	"cleargive/server/middleware"
 	"cleargive/server/models"
 	"cleargive/server/routes"
+	"cleargive/server/services"
+	"cleargive/server/storage"
+	"cleargive/server/workers"
+	"context"
 	"log"
 	"os"
 
@@ -23,19 +28,45 @@ func main() {
 	// Initialize database
 	config.ConnectDB()
 
+	// Initialize Stellar network access
+	services.ConnectHorizon()
+
+	// Initialize storage for uploaded evidence
+	storage.ConnectBlobStore()
+
+	// Initialize ID token verification
+	middleware.ConfigureAuth()
+
 	// Auto migrate models
 	config.DB.AutoMigrate(
 		&models.User{},
 		&models.Charity{},
 		&models.Donation{},
 		&models.TaxReport{},
+		&models.TaxReportAsset{},
 		&models.AuditRecord{},
 		&models.Certificate{},
 		&models.ComplianceCheck{},
 	)
 
+	// Record incoming payments to charity wallets as donations
+	if os.Getenv("DONATION_WATCHER") != "disabled" {
+		go workers.WatchDonations(context.Background())
+	}
+
+	// Expire transaction approvals left open past their charity's window
+	go workers.ExpireApprovals(context.Background())
+
+	// Settle approvals whose payment was submitted but not confirmed
+	go workers.ReconcileApprovals(context.Background())
+
+	// Mark milestones past their due date overdue and propose refunds for stalled approvals
+	go workers.NewMilestoneMonitor().Run(context.Background())
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
+		// Leave room for evidence uploads and the rest of the multipart form
+		BodyLimit: services.MaxEvidenceSize + 1<<20,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			// Default error handling
 			code := fiber.StatusInternalServerError
@@ -3,37 +3,106 @@ package middleware
 import (
 	"cleargive/server/config"
 	"cleargive/server/models"
+	"log"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func AuthMiddleware() fiber.Handler {
+// defaultClockSkew is the clock difference tolerated when checking token times
+const defaultClockSkew = time.Minute
+
+// Verifier checks the bearer tokens of every authenticated request.
+// Requests are rejected while it is nil.
+var Verifier TokenVerifier
+
+// ConfigureAuth sets up Verifier from the environment.
+//
+// AUTH_JWKS_FILE or AUTH_JWKS_URL points at the signing keys, AUTH_ISSUER and
+// AUTH_AUDIENCE are the expected token issuer and audience (both derived from
+// FIREBASE_PROJECT_ID when unset) and AUTH_CLOCK_SKEW is a duration such as "30s".
+func ConfigureAuth() {
+	issuer := os.Getenv("AUTH_ISSUER")
+	audience := os.Getenv("AUTH_AUDIENCE")
+	if projectID := os.Getenv("FIREBASE_PROJECT_ID"); projectID != "" {
+		if issuer == "" {
+			issuer = "https://securetoken.google.com/" + projectID
+		}
+		if audience == "" {
+			audience = projectID
+		}
+	}
+	if issuer == "" || audience == "" {
+		log.Println("auth: AUTH_ISSUER and AUTH_AUDIENCE are not set, all authenticated requests will be rejected")
+		return
+	}
+
+	clockSkew := defaultClockSkew
+	if value := os.Getenv("AUTH_CLOCK_SKEW"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid AUTH_CLOCK_SKEW: ", err)
+		}
+		clockSkew = parsed
+	}
+
+	var keys KeySource
+	var err error
+	switch {
+	case os.Getenv("AUTH_JWKS_FILE") != "":
+		keys, err = NewJWKSFromFile(os.Getenv("AUTH_JWKS_FILE"))
+	case os.Getenv("AUTH_JWKS_URL") != "":
+		keys, err = NewJWKSFromURL(os.Getenv("AUTH_JWKS_URL"))
+	default:
+		log.Println("auth: neither AUTH_JWKS_FILE nor AUTH_JWKS_URL is set, all authenticated requests will be rejected")
+		return
+	}
+	if err != nil {
+		log.Fatal("Failed to load JWKS: ", err)
+	}
+
+	Verifier = &RS256Verifier{
+		Keys:      keys,
+		Issuer:    issuer,
+		Audience:  audience,
+		ClockSkew: clockSkew,
+	}
+}
+
+// TokenMiddleware verifies the bearer token and stores its subject and email
+// without requiring a user record, for routes such as signup
+func TokenMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Get the Authorization header
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
+		claims, message := verifyRequest(c)
+		if claims == nil {
 			return c.Status(401).JSON(fiber.Map{
 				"status":  "error",
-				"message": "Authorization header is required",
+				"message": message,
 			})
 		}
 
-		// Check if it's a Bearer token
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		c.Locals("firebaseID", claims.Subject)
+		c.Locals("email", claims.Email)
+
+		return c.Next()
+	}
+}
+
+func AuthMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, message := verifyRequest(c)
+		if claims == nil {
 			return c.Status(401).JSON(fiber.Map{
 				"status":  "error",
-				"message": "Invalid authorization format",
+				"message": message,
 			})
 		}
 
-		// The Firebase ID is the token (in a real app, you'd verify the JWT token)
-		firebaseID := parts[1]
-
-		// Get user from database
+		// The token subject is the user's Firebase ID
 		var user models.User
-		if result := config.DB.Where("firebase_id = ?", firebaseID).First(&user); result.Error != nil {
+		if result := config.DB.Where("firebase_id = ?", claims.Subject).First(&user); result.Error != nil {
 			return c.Status(401).JSON(fiber.Map{
 				"status":  "error",
 				"message": "Unauthorized",
@@ -49,3 +118,29 @@ func AuthMiddleware() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// verifyRequest checks the bearer token of the Authorization header.
+// It returns the token claims, or nil and the reason the request is rejected.
+func verifyRequest(c *fiber.Ctx) (*Claims, string) {
+	// Get the Authorization header
+	authHeader := c.Get("Authorization")
+	if authHeader == "" {
+		return nil, "Authorization header is required"
+	}
+
+	// Check if it's a Bearer token
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, "Invalid authorization format"
+	}
+
+	if Verifier == nil {
+		return nil, "Authentication is not configured"
+	}
+
+	claims, err := Verifier.Verify(parts[1])
+	if err != nil {
+		return nil, "Invalid token: " + err.Error()
+	}
+	return claims, ""
+}
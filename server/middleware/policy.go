@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/policy"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireSelf only lets a user through routes keyed by their own Firebase ID in
+// the given parameter, unless their role grants perm.
+// It must run after AuthMiddleware.
+func RequireSelf(param string, perm policy.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !policy.IsSelfOr(policy.FromContext(c), c.Params(param), perm) {
+			return c.Status(403).JSON(fiber.Map{
+				"status":  "error",
+				"message": "You can only access your own records",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequireCharityMember only lets the owner and cosigners of the charity in the
+// given parameter through, and users whose role may read every charity.
+// It must run after AuthMiddleware.
+func RequireCharityMember(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		charityID, err := strconv.ParseUint(c.Params(param), 10, 64)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid charity ID",
+			})
+		}
+
+		allowed, err := policy.CanReadCharity(config.DB, policy.FromContext(c), uint(charityID))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Could not check charity membership",
+			})
+		}
+		if !allowed {
+			return c.Status(403).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Only members of this charity can access its records",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequirePermission only lets users whose role grants perm through.
+// It must run after AuthMiddleware.
+func RequirePermission(perm policy.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !policy.FromContext(c).Can(perm) {
+			return c.Status(403).JSON(fiber.Map{
+				"status":  "error",
+				"message": "You do not have permission to perform this action",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Token verification errors
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+	ErrBadSignature   = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token has expired")
+	ErrTokenNotYet    = errors.New("token is not valid yet")
+	ErrWrongIssuer    = errors.New("token issuer is not trusted")
+	ErrWrongAudience  = errors.New("token audience does not match")
+	ErrMissingSubject = errors.New("token has no subject")
+)
+
+// Claims are the verified claims of an ID token
+type Claims struct {
+	Subject   string
+	Issuer    string
+	Audience  []string
+	Email     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenVerifier checks a bearer token and returns its claims.
+// It is an interface so tests can verify tokens signed with a local key pair.
+type TokenVerifier interface {
+	Verify(token string) (*Claims, error)
+}
+
+// KeySource looks up the RSA public key a token was signed with by its key ID
+type KeySource interface {
+	Key(kid string) (*rsa.PublicKey, error)
+}
+
+// RS256Verifier verifies RS256 signed JWTs against a KeySource and checks
+// issuer, audience, expiry and not-before with an allowed clock skew
+type RS256Verifier struct {
+	Keys      KeySource
+	Issuer    string
+	Audience  string
+	ClockSkew time.Duration
+	Now       func() time.Time // defaults to time.Now
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type tokenClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	Email     string   `json:"email"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience accepts the JWT "aud" claim as a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Verify checks the token signature and claims
+func (v *RS256Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, ErrUnsupportedAlg
+	}
+
+	key, err := v.Keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrBadSignature
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(v.ClockSkew)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-v.ClockSkew)) {
+		return nil, ErrTokenNotYet
+	}
+	if claims.IssuedAt != 0 && now.Before(time.Unix(claims.IssuedAt, 0).Add(-v.ClockSkew)) {
+		return nil, ErrTokenNotYet
+	}
+	if claims.Issuer != v.Issuer {
+		return nil, ErrWrongIssuer
+	}
+	if !containsAudience(claims.Audience, v.Audience) {
+		return nil, ErrWrongAudience
+	}
+	if claims.Subject == "" {
+		return nil, ErrMissingSubject
+	}
+
+	verified := &Claims{
+		Subject:   claims.Subject,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		Email:     claims.Email,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	if claims.IssuedAt != 0 {
+		verified.IssuedAt = time.Unix(claims.IssuedAt, 0)
+	}
+	return verified, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	return nil
+}
+
+func containsAudience(audiences []string, want string) bool {
+	for _, aud := range audiences {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testAudience = "cleargive-test"
+)
+
+// signTestToken signs claims with key as an RS256 JWT naming kid
+func signTestToken(t *testing.T, key *rsa.PrivateKey, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestRS256VerifierWithInjectedKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	verifier := &RS256Verifier{
+		Keys:      StaticKeys{"current": &key.PublicKey},
+		Issuer:    testIssuer,
+		Audience:  testAudience,
+		ClockSkew: time.Minute,
+		Now:       func() time.Time { return now },
+	}
+
+	// claims returns valid claims with the given changes, nil removes a claim
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "firebase-user",
+			"iss":   testIssuer,
+			"aud":   testAudience,
+			"email": "user@example.org",
+			"iat":   now.Add(-time.Minute).Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+
+	valid := signTestToken(t, key, "RS256", "current", claims(nil))
+
+	// The payload of a token for another subject under the signature of the valid token
+	signed := strings.Split(valid, ".")
+	forged := strings.Split(signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"sub": "admin"})), ".")
+	tampered := signed[0] + "." + forged[1] + "." + signed[2]
+
+	for _, test := range []struct {
+		name  string
+		token string
+		err   error // nil when the token is accepted
+	}{
+		{"valid token", valid, nil},
+		{"audience list", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"aud": []string{"other", testAudience}})), nil},
+		{"expired within the clock skew", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), nil},
+		{"expired", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), ErrTokenExpired},
+		{"no expiry", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"exp": nil})), ErrTokenExpired},
+		{"not valid yet", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), ErrTokenNotYet},
+		{"issued in the future", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"iat": now.Add(time.Hour).Unix()})), ErrTokenNotYet},
+		{"other issuer", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"iss": "https://evil.test"})), ErrWrongIssuer},
+		{"other audience", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"aud": "other"})), ErrWrongAudience},
+		{"no subject", signTestToken(t, key, "RS256", "current", claims(map[string]interface{}{"sub": nil})), ErrMissingSubject},
+		{"signed with another key", signTestToken(t, other, "RS256", "current", claims(nil)), ErrBadSignature},
+		{"unknown key ID", signTestToken(t, key, "RS256", "rotated", claims(nil)), ErrUnknownKey},
+		{"other algorithm", signTestToken(t, key, "HS256", "current", claims(nil)), ErrUnsupportedAlg},
+		{"tampered payload", tampered, ErrBadSignature},
+		{"malformed", "not-a-token", ErrMalformedToken},
+	} {
+		verified, err := verifier.Verify(test.token)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if verified.Subject != "firebase-user" || verified.Email != "user@example.org" {
+				t.Errorf("%s: claims %+v", test.name, verified)
+			}
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestJWKSFileVerifiesInjectedKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "file-key",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, set, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewJWKSFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &RS256Verifier{Keys: keys, Issuer: testIssuer, Audience: testAudience}
+	token := signTestToken(t, key, "RS256", "file-key", map[string]interface{}{
+		"sub": "firebase-user",
+		"iss": testIssuer,
+		"aud": testAudience,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	if claims, err := verifier.Verify(token); err != nil || claims.Subject != "firebase-user" {
+		t.Fatalf("claims %+v, err %v", claims, err)
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// NativeAssetCode is the asset code used for lumens
+const NativeAssetCode = "XLM"
+
+// Asset identifies the Stellar asset an amount is denominated in.
+// Lumens use the code XLM and no issuer.
+type Asset struct {
+	AssetCode   string `json:"assetCode" gorm:"default:XLM"`
+	AssetIssuer string `json:"assetIssuer,omitempty"`
+}
+
+// NativeAsset returns the lumen asset
+func NativeAsset() Asset {
+	return Asset{AssetCode: NativeAssetCode}
+}
+
+// IsNative reports whether the asset is the lumen
+func (a Asset) IsNative() bool {
+	return a.AssetCode == NativeAssetCode && a.AssetIssuer == ""
+}
+
+// Normalized returns the asset with an empty code treated as lumens
+func (a Asset) Normalized() Asset {
+	if a.AssetCode == "" && a.AssetIssuer == "" {
+		return NativeAsset()
+	}
+	return a
+}
+
+// Canonical formats the asset as CODE or CODE:ISSUER
+func (a Asset) Canonical() string {
+	if a.AssetIssuer == "" {
+		return a.AssetCode
+	}
+	return a.AssetCode + ":" + a.AssetIssuer
+}
+
+// CharityAsset is a non-native asset a charity accepts.
+// Lumens are always accepted and have no row.
+type CharityAsset struct {
+	gorm.Model
+	CharityID       uint   `json:"charityId" gorm:"uniqueIndex:idx_charity_assets_key"`
+	AssetCode       string `json:"assetCode" gorm:"uniqueIndex:idx_charity_assets_key"`
+	AssetIssuer     string `json:"assetIssuer" gorm:"uniqueIndex:idx_charity_assets_key"`
+	TrustlineTxHash string `json:"trustlineTxHash"` // Transaction that added the trustline to the charity wallet
+}
+
+// Asset returns the asset the row describes
+func (a CharityAsset) Asset() Asset {
+	return Asset{AssetCode: a.AssetCode, AssetIssuer: a.AssetIssuer}
+}
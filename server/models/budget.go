@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Fiscal period statuses
+const (
+	FiscalPeriodOpen   = "open"
+	FiscalPeriodClosed = "closed" // the Spent values of its categories are frozen
+)
+
+// FiscalPeriod is a year, quarter or other run of months a charity plans a budget for.
+// Budget categories belong to a period and only spend while it is open and current.
+type FiscalPeriod struct {
+	gorm.Model
+	CharityID  uint       `json:"charityId" gorm:"index"`
+	Name       string     `json:"name"`       // e.g. FY2026 or 2026 Q1
+	StartMonth string     `json:"startMonth"` // YYYY-MM in UTC
+	EndMonth   string     `json:"endMonth"`   // YYYY-MM in UTC, inclusive
+	Budget     Money      `json:"budget"`     // Lumens planned for the period, categories are allocated a percentage of it
+	Status     string     `json:"status" gorm:"default:open"`
+	ClosedAt   *time.Time `json:"closedAt,omitempty"`
+	ClosedByID string     `json:"closedById,omitempty"`
+}
+
+// Contains reports whether month (YYYY-MM) is within the period
+func (p *FiscalPeriod) Contains(month string) bool {
+	return p.StartMonth <= month && month <= p.EndMonth
+}
+
+// BudgetVersion is a snapshot of a fiscal period's budget, recorded every time
+// the budget or its categories change
+type BudgetVersion struct {
+	gorm.Model
+	PeriodID    uint                `json:"periodId" gorm:"uniqueIndex:idx_budget_versions_period"`
+	Version     int                 `json:"version" gorm:"uniqueIndex:idx_budget_versions_period"`
+	Budget      Money               `json:"budget"`
+	Note        string              `json:"note"` // What changed
+	CreatedByID string              `json:"createdById"`
+	Lines       []BudgetVersionLine `json:"lines" gorm:"foreignKey:VersionID"`
+}
+
+// BudgetVersionLine is the allocation of one category in a budget version
+type BudgetVersionLine struct {
+	ID         uint    `json:"-" gorm:"primarykey"`
+	VersionID  uint    `json:"-" gorm:"index"`
+	CategoryID uint    `json:"categoryId"`
+	Name       string  `json:"name"`
+	Allocation float64 `json:"allocation"`
+}
@@ -22,16 +22,17 @@ type Certificate struct {
 
 // CertificateMetadata represents the metadata structure for an NFT certificate
 type CertificateMetadata struct {
-	Name         string    `json:"name"`
-	Description  string    `json:"description"`
-	Image        string    `json:"image"`
-	Amount       string    `json:"amount"`
-	Currency     string    `json:"currency"`
-	DonatedTo    string    `json:"donatedTo"`
-	DonatedBy    string    `json:"donatedBy"`
-	DonationDate time.Time `json:"donationDate"`
-	IssueDate    time.Time `json:"issueDate"`
-	TxHash       string    `json:"txHash"`
-	Category     string    `json:"category,omitempty"`
-	ImpactArea   string    `json:"impactArea,omitempty"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Image          string    `json:"image"`
+	Amount         string    `json:"amount"`
+	Currency       string    `json:"currency"`
+	CurrencyIssuer string    `json:"currencyIssuer,omitempty"`
+	DonatedTo      string    `json:"donatedTo"`
+	DonatedBy      string    `json:"donatedBy"`
+	DonationDate   time.Time `json:"donationDate"`
+	IssueDate      time.Time `json:"issueDate"`
+	TxHash         string    `json:"txHash"`
+	Category       string    `json:"category,omitempty"`
+	ImpactArea     string    `json:"impactArea,omitempty"`
 }
@@ -1,25 +1,57 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
-// Cosigner represents a person who can approve transactions
+// Veto rules decide when reject votes reject a transaction approval
+const (
+	VetoRuleAny      = "any"      // a single reject vote rejects the approval
+	VetoRuleBlocking = "blocking" // rejected once the remaining signers cannot reach the threshold
+)
+
+// Overspend rules decide what happens to approvals that would spend more than their
+// budget category has left
+const (
+	OverspendRuleBlock  = "block"  // the approval is refused
+	OverspendRuleQuorum = "quorum" // the approval needs the signature of every signer
+)
+
+// DefaultApprovalTTLHours is how long approvals stay open unless a charity sets
+// its own window. It cannot exceed the envelope timeout of seven days.
+const DefaultApprovalTTLHours = 7 * 24
+
+// Cosigner statuses
+const (
+	CosignerStatusPending = "pending" // invited, not yet accepted
+	CosignerStatusActive  = "active"  // bound to a user account
+)
+
+// Cosigner represents a person who can approve transactions.
+// A cosigner is invited by email and becomes active once a user accepts the invitation.
 type Cosigner struct {
 	gorm.Model
-	CharityID uint   `json:"charityId"`
-	UserID    string `json:"userId"`
-	Email     string `json:"email"`
-	IsPrimary bool   `json:"isPrimary" gorm:"default:false"`
+	CharityID       uint       `json:"charityId" gorm:"index"`
+	UserID          uint       `json:"userId" gorm:"index"` // User who accepted the invitation, 0 while pending
+	Email           string     `json:"email"`               // Address the invitation was sent to
+	PublicKey       string     `json:"publicKey"`           // Stellar key added as a signer on the charity wallet
+	IsPrimary       bool       `json:"isPrimary" gorm:"default:false"`
+	Status          string     `json:"status" gorm:"default:pending"`
+	InviteTokenHash string     `json:"-"` // SHA-256 of the outstanding invitation token
+	InviteExpiresAt time.Time  `json:"inviteExpiresAt"`
+	AcceptedAt      *time.Time `json:"acceptedAt,omitempty"`
 }
 
-// BudgetCategory represents a budget allocation category
+// BudgetCategory represents a budget allocation category of a fiscal period
 type BudgetCategory struct {
 	gorm.Model
 	CharityID  uint    `json:"charityId"`
+	PeriodID   uint    `json:"periodId" gorm:"index"` // Fiscal period the category is budgeted for
 	Name       string  `json:"name"`
-	Allocation float64 `json:"allocation"` // percentage of total budget
-	Spent      float64 `json:"spent"`      // amount spent
+	Allocation float64 `json:"allocation"` // percentage of the period's budget, the categories of a period add up to at most 100
+	Spent      Money   `json:"spent"`      // lumens spent, other assets are reported per asset
 }
 
 type Charity struct {
@@ -27,15 +59,25 @@ type Charity struct {
 	Name               string           `json:"name"`
 	Description        string           `json:"description"`
 	WalletAddress      string           `json:"walletAddress" gorm:"unique"`
-	WalletSecret       string           `json:"-" gorm:"unique"` // Secret key is not exposed in JSON
+	WalletSecret       string           `json:"-" gorm:"unique"` // Encrypted secret key, never exposed in JSON
 	OwnerID            uint             `json:"ownerId"`
-	TotalDonations     float64          `json:"totalDonations" gorm:"default:0"`
+	TotalDonations     Money            `json:"totalDonations" gorm:"default:0"` // Lumens only, see CharityTotal for other assets
 	Category           string           `json:"category"`
 	Website            string           `json:"website"`
 	ImageURL           string           `json:"imageUrl"`
 	IsMultiSig         bool             `json:"isMultiSig" gorm:"default:false"`
 	RequiredSignatures int              `json:"requiredSignatures" gorm:"default:1"`
+	SignerSet          string           `json:"signerSet"`        // Comma separated cosigner keys last synced to the wallet
+	ThresholdVersion   int              `json:"thresholdVersion"` // Incremented on every on-chain signer update
+	SignerSyncTxHash   string           `json:"signerSyncTxHash"` // Transaction that applied the current signer set
+	VetoRule           string           `json:"vetoRule" gorm:"default:any"`
+	ApprovalTTLHours   int              `json:"approvalTtlHours" gorm:"default:168"`
+	VerificationQuorum int              `json:"verificationQuorum" gorm:"default:1"`     // Approving votes needed to verify a milestone
+	RequireIndependent bool             `json:"requireIndependent" gorm:"default:false"` // One approving vote must come from an independent verifier
+	AttributionMethod  string           `json:"attributionMethod" gorm:"default:fifo"`   // How executed approvals are attributed to donations
+	OverspendRule      string           `json:"overspendRule" gorm:"default:block"`      // What happens to approvals that overspend a budget category
 	Owner              User             `json:"owner" gorm:"foreignKey:OwnerID"`
 	Cosigners          []Cosigner       `json:"cosigners" gorm:"foreignKey:CharityID"`
+	Assets             []CharityAsset   `json:"assets" gorm:"foreignKey:CharityID"` // Accepted assets besides lumens
 	BudgetCategories   []BudgetCategory `json:"budgetCategories" gorm:"foreignKey:CharityID"`
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CharityTotal is the running total of confirmed donations to a charity
+// for one asset, budget category and calendar month
+type CharityTotal struct {
+	ID            uint      `json:"-" gorm:"primarykey"`
+	CharityID     uint      `json:"charityId" gorm:"uniqueIndex:idx_charity_totals_key"`
+	AssetCode     string    `json:"assetCode" gorm:"uniqueIndex:idx_charity_totals_key"`
+	AssetIssuer   string    `json:"assetIssuer,omitempty" gorm:"uniqueIndex:idx_charity_totals_key"`
+	CategoryID    uint      `json:"categoryId" gorm:"uniqueIndex:idx_charity_totals_key"` // 0 for donations without a budget category
+	Month         string    `json:"month" gorm:"uniqueIndex:idx_charity_totals_key"`      // YYYY-MM in UTC
+	Stroops       int64     `json:"-"`                                                    // Exact total in stroops, kept as an integer for atomic updates
+	Amount        Money     `json:"amount" gorm:"-"`
+	DonationCount int64     `json:"donationCount"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// AfterFind exposes the stroop total as Money
+func (t *CharityTotal) AfterFind(tx *gorm.DB) error {
+	t.Amount = Money(t.Stroops)
+	return nil
+}
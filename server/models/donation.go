@@ -6,13 +6,17 @@ import (
 
 type Donation struct {
 	gorm.Model
-	Amount    string  `json:"amount"`
-	CharityID uint    `json:"charityId"`
-	DonorID   string  `json:"donorId"`
-	Message   string  `json:"message"`
-	TxHash    string  `json:"txHash" gorm:"unique"`
-	Status    string  `json:"status"`
-	Category  string  `json:"category"`
-	Charity   Charity `json:"charity" gorm:"foreignKey:CharityID"`
-	Donor     User    `json:"donor" gorm:"foreignKey:FirebaseID"`
+	Amount        Money `json:"amount"`
+	Asset         `gorm:"embedded"`
+	CharityID     uint    `json:"charityId"`
+	DonorID       string  `json:"donorId"`
+	Message       string  `json:"message"`
+	TxHash        string  `json:"txHash" gorm:"index"`                                   // Transaction of the payment, which can pay several donations
+	PaymentID     string  `json:"paymentId" gorm:"index:,unique,where:payment_id <> ''"` // Horizon ID of the payment operation, empty on donations recorded before
+	Status        string  `json:"status"`                                                // confirmed once the payment is verified on the ledger
+	SourceAccount string  `json:"sourceAccount"`                                         // Stellar account the payment came from
+	CategoryID    uint    `json:"categoryId" gorm:"index"`                               // Budget category the donation is given to, 0 for none
+	Category      string  `json:"category"`                                              // Name of the budget category, free text on older donations
+	Charity       Charity `json:"charity" gorm:"foreignKey:CharityID"`
+	Donor         User    `json:"donor" gorm:"foreignKey:FirebaseID"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// IngestCursor stores how far the ledger watcher has read the payments of a charity wallet
+type IngestCursor struct {
+	gorm.Model
+	CharityID uint   `json:"charityId" gorm:"uniqueIndex"`
+	Cursor    string `json:"cursor"` // Horizon paging token of the last processed payment
+}
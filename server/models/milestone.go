@@ -6,26 +6,63 @@ import (
 	"gorm.io/gorm"
 )
 
+// Milestone statuses, see statemachine.Milestones for the allowed transitions
+const (
+	MilestoneStatusPending   = "pending"
+	MilestoneStatusOverdue   = "overdue" // still pending after its due date
+	MilestoneStatusCompleted = "completed"
+	MilestoneStatusVerified  = "verified"
+	MilestoneStatusReleasing = "releasing" // its release approval pays the escrowed funds out
+	MilestoneStatusReleased  = "released"
+	MilestoneStatusCancelled = "cancelled"
+)
+
+// Milestone verification votes
+const (
+	VerificationApproved = "approved"
+	VerificationRejected = "rejected"
+)
+
 // Milestone represents a project milestone that triggers fund release
 type Milestone struct {
 	gorm.Model
 	Name                string              `json:"name"`
 	Description         string              `json:"description"`
 	ApprovalID          uint                `json:"approvalId"`
-	Amount              string              `json:"amount"`
+	Amount              Money               `json:"amount"`
+	Asset               `gorm:"embedded"`   // Same as the approval the milestone releases
 	DueDate             time.Time           `json:"dueDate"`
 	CompletionDate      time.Time           `json:"completionDate,omitempty"`
-	Status              string              `json:"status"` // pending, completed, verified, released, cancelled
+	Status              string              `json:"status"` // pending, overdue, completed, verified, releasing, released, cancelled
 	VerificationProof   string              `json:"verificationProof,omitempty"`
+	CompletedByID       string              `json:"completedById,omitempty"` // User who marked the milestone completed
+	VerificationRound   int                 `json:"verificationRound"`       // Incremented every time the milestone is completed
+	ReleaseTxHash       string              `json:"releaseTxHash"`           // Pays the escrowed funds out, set while releasing
 	TransactionApproval TransactionApproval `json:"transactionApproval" gorm:"foreignKey:ApprovalID"`
 }
 
 // MilestoneVerification represents a verification of a milestone
 type MilestoneVerification struct {
 	gorm.Model
-	MilestoneID uint      `json:"milestoneId"`
-	VerifierID  string    `json:"verifierId"`
+	MilestoneID uint      `json:"milestoneId" gorm:"uniqueIndex:idx_milestone_round_verifier"`
+	Round       int       `json:"round" gorm:"uniqueIndex:idx_milestone_round_verifier"` // Verification round the vote was cast in
+	VerifierID  string    `json:"verifierId" gorm:"uniqueIndex:idx_milestone_round_verifier"`
+	Independent bool      `json:"independent"` // Cast by a milestone verifier outside the charity
 	Comments    string    `json:"comments"`
 	Status      string    `json:"status"` // approved, rejected
 	Milestone   Milestone `json:"milestone" gorm:"foreignKey:MilestoneID"`
 }
+
+// MilestoneEvidence is a file uploaded as proof that a milestone was completed.
+// The content is kept in the blob store under its SHA-256 hash.
+type MilestoneEvidence struct {
+	gorm.Model
+	MilestoneID  uint       `json:"milestoneId" gorm:"index"`
+	UploadedByID string     `json:"uploadedById"`
+	FileName     string     `json:"fileName"`
+	ContentType  string     `json:"contentType"`
+	Size         int64      `json:"size"`
+	SHA256       string     `json:"sha256" gorm:"index"` // Hex encoded hash of the content
+	AnchorTxHash string     `json:"anchorTxHash,omitempty"`
+	AnchoredAt   *time.Time `json:"anchoredAt,omitempty"`
+}
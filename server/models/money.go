@@ -0,0 +1,127 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/stellar/go/amount"
+)
+
+// Money is an exact amount with the 7 decimal places used by Stellar.
+// It counts stroops (1e-7 of a unit), is stored as a decimal string and
+// marshalled to JSON as a string such as "12.5000000".
+type Money int64
+
+// ErrInvalidMoney is returned for amounts that are not decimals with at most 7 decimal places
+var ErrInvalidMoney = errors.New("amount must be a decimal number with at most 7 decimal places")
+
+// ParseMoney parses a decimal string into Money without rounding
+func ParseMoney(s string) (Money, error) {
+	stroops, err := amount.ParseInt64(s)
+	if err != nil {
+		return 0, ErrInvalidMoney
+	}
+	return Money(stroops), nil
+}
+
+// String formats the amount with 7 decimal places
+func (m Money) String() string {
+	return amount.StringFromInt64(int64(m))
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (m Money) IsPositive() bool {
+	return m > 0
+}
+
+// MarshalJSON encodes the amount as a JSON string
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts a JSON string or number with at most 7 decimal places
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return ErrInvalidMoney
+		}
+		s = n.String()
+	}
+	return m.UnmarshalText([]byte(s))
+}
+
+// MarshalText encodes the amount as a decimal string
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText parses a decimal string with at most 7 decimal places
+func (m *Money) UnmarshalText(text []byte) error {
+	parsed, err := ParseMoney(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// GormDataType stores Money in a text column so no precision is lost
+func (Money) GormDataType() string {
+	return "string"
+}
+
+// Value stores the amount as a decimal string
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads decimal strings as well as numbers written by older versions,
+// which stored some amounts as floating point
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case int64:
+		*m = Money(v * 10_000_000)
+		return nil
+	case float64:
+		return m.scanDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+	case []byte:
+		return m.scanDecimal(string(v))
+	case string:
+		return m.scanDecimal(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+}
+
+// scanDecimal parses a stored amount, rounding legacy values with more than
+// 7 decimal places to the nearest stroop
+func (m *Money) scanDecimal(s string) error {
+	if s == "" {
+		*m = 0
+		return nil
+	}
+	if parsed, err := ParseMoney(s); err == nil {
+		*m = parsed
+		return nil
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return fmt.Errorf("cannot scan %q into Money", s)
+	}
+	stroops, err := strconv.ParseInt(r.Mul(r, big.NewRat(10_000_000, 1)).FloatString(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money: %w", s, err)
+	}
+	*m = Money(stroops)
+	return nil
+}
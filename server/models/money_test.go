@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  Money
+		err   bool
+	}{
+		{"1", 10_000_000, false},
+		{"12.5", 125_000_000, false},
+		{"0.0000001", 1, false},
+		{"0.1234567", 1_234_567, false},
+		{"0.12345678", 0, true},
+		{"-2.0000001", -20_000_001, false},
+		{"922337203685.4775807", 9_223_372_036_854_775_807, false},
+		{"922337203685.4775808", 0, true},
+		{"-922337203685.4775809", 0, true},
+		{"", 0, true},
+		{"1.2.3", 0, true},
+		{"12abc", 0, true},
+		{"1e3", 0, true},
+	} {
+		got, err := ParseMoney(test.input)
+		switch {
+		case test.err && !errors.Is(err, ErrInvalidMoney):
+			t.Errorf("ParseMoney(%q) = %d, %v, expected ErrInvalidMoney", test.input, got, err)
+		case !test.err && (err != nil || got != test.want):
+			t.Errorf("ParseMoney(%q) = %d, %v, expected %d", test.input, got, err, test.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  Money
+		err   bool
+	}{
+		{`"0.1"`, 1_000_000, false},
+		{`0.1`, 1_000_000, false},
+		{`"100.0000001"`, 1_000_000_001, false},
+		{`-3`, -30_000_000, false},
+		{`0.00000001`, 0, true},
+		{`"ten"`, 0, true},
+		{`true`, 0, true},
+		{`null`, 0, true},
+	} {
+		var got Money
+		err := json.Unmarshal([]byte(test.input), &got)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("unmarshal %s = %d, expected an error", test.input, got)
+		case !test.err && (err != nil || got != test.want):
+			t.Errorf("unmarshal %s = %d, %v, expected %d", test.input, got, err, test.want)
+		}
+	}
+
+	out, err := json.Marshal(Money(-1))
+	if err != nil || string(out) != `"-0.0000001"` {
+		t.Errorf("marshal -1 stroop = %s, %v", out, err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	for _, test := range []struct {
+		src  interface{}
+		want Money
+		err  bool
+	}{
+		{nil, 0, false},
+		{"", 0, false},
+		{"12.3456789", 123_456_789, false},
+		{[]byte("0.0000001"), 1, false},
+		{int64(3), 30_000_000, false},
+		{0.1, 1_000_000, false},
+		// Legacy floating point amounts are rounded to the nearest stroop
+		{"0.30000000000000004", 3_000_000, false},
+		{"0.00000005", 1, false},
+		{"-0.00000015", -2, false},
+		{"abc", 0, true},
+		{true, 0, true},
+	} {
+		var got Money
+		err := got.Scan(test.src)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("Scan(%#v) = %d, expected an error", test.src, got)
+		case !test.err && (err != nil || got != test.want):
+			t.Errorf("Scan(%#v) = %d, %v, expected %d", test.src, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification is a message shown to a user in the app
+type Notification struct {
+	gorm.Model
+	UserID  uint       `json:"userId" gorm:"index"`
+	Event   string     `json:"event"`
+	Message string     `json:"message"`
+	Link    string     `json:"link,omitempty"` // API path of the record the notification is about
+	ReadAt  *time.Time `json:"readAt,omitempty"`
+}
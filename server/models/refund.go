@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Fund attribution methods decide which donations paid for an executed approval
+const (
+	AttributionFIFO    = "fifo"     // the oldest donations are spent first
+	AttributionProRata = "pro_rata" // every donation with unspent funds pays its share
+)
+
+// FundAttribution records how much of a donation an executed approval spent
+type FundAttribution struct {
+	gorm.Model
+	ApprovalID uint     `json:"approvalId" gorm:"index"`
+	DonationID uint     `json:"donationId" gorm:"index"`
+	Amount     Money    `json:"amount"`
+	Donation   Donation `json:"donation" gorm:"foreignKey:DonationID"`
+}
+
+// Refund returns a donor's share of the funds an approval did not spend
+type Refund struct {
+	gorm.Model
+	ApprovalID  uint   `json:"approvalId" gorm:"index"`
+	DonationID  uint   `json:"donationId" gorm:"index"`
+	DonorID     string `json:"donorId" gorm:"index"`
+	Destination string `json:"destination"` // Account the donation was paid from
+	Amount      Money  `json:"amount"`
+	Asset       `gorm:"embedded"`
+	TxHash      string `json:"txHash"`
+}
+
+// Refund proposal statuses
+const (
+	RefundProposalOpen      = "open"
+	RefundProposalAccepted  = "accepted"
+	RefundProposalDismissed = "dismissed"
+)
+
+// RefundProposal suggests refunding an approval's unspent funds because one of its
+// milestones stayed overdue past the grace period. The charity owner accepts or dismisses it.
+type RefundProposal struct {
+	gorm.Model
+	ApprovalID  uint       `json:"approvalId" gorm:"uniqueIndex"` // Proposed at most once per approval
+	MilestoneID uint       `json:"milestoneId"`                   // Overdue milestone that triggered the proposal
+	Amount      Money      `json:"amount"`                        // Unspent funds when the proposal was opened
+	Reason      string     `json:"reason"`
+	Status      string     `json:"status"`
+	DecidedByID string     `json:"decidedById,omitempty"`
+	DecidedAt   *time.Time `json:"decidedAt,omitempty"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// StatusTransition records one status change of a transaction approval or milestone
+type StatusTransition struct {
+	gorm.Model
+	Entity     string `json:"entity" gorm:"index:idx_status_transitions_entity"` // transaction_approval or milestone
+	EntityID   uint   `json:"entityId" gorm:"index:idx_status_transitions_entity"`
+	FromStatus string `json:"fromStatus"`
+	ToStatus   string `json:"toStatus"`
+	ActorID    string `json:"actorId"` // User ID of the actor, "system" for background jobs
+	Reason     string `json:"reason,omitempty"`
+}
@@ -9,13 +9,24 @@ import (
 // TaxReport represents a tax reporting document for a user's donations
 type TaxReport struct {
 	gorm.Model
-	UserID         string    `json:"userId" gorm:"index"`
-	Year           int       `json:"year"`
-	TotalDonations float64   `json:"totalDonations"`
-	Status         string    `json:"status"` // "ready", "processing", "error"
-	FileURL        string    `json:"fileUrl,omitempty"`
-	GeneratedAt    time.Time `json:"generatedAt"`
-	User           User      `json:"user" gorm:"foreignKey:UserID;references:FirebaseID"`
+	UserID         string           `json:"userId" gorm:"index"`
+	Year           int              `json:"year"`
+	TotalDonations Money            `json:"totalDonations"` // Lumens only, see Assets for every asset
+	Status         string           `json:"status"`         // "ready", "processing", "error"
+	FileURL        string           `json:"fileUrl,omitempty"`
+	GeneratedAt    time.Time        `json:"generatedAt"`
+	User           User             `json:"user" gorm:"foreignKey:UserID;references:FirebaseID"`
+	Assets         []TaxReportAsset `json:"assets" gorm:"foreignKey:TaxReportID"`
+}
+
+// TaxReportAsset is the donation total of a tax report in one asset
+type TaxReportAsset struct {
+	gorm.Model
+	TaxReportID    uint `json:"taxReportId" gorm:"index"`
+	Asset          `gorm:"embedded"`
+	TotalDonations Money `json:"totalDonations"` // Net of refunds
+	TotalRefunded  Money `json:"totalRefunded"`  // Returned to the donor from unspent approval funds
+	DonationCount  int64 `json:"donationCount"`
 }
 
 // AuditRecord represents an audit trail entry for compliance and transparency
@@ -24,6 +35,8 @@ type AuditRecord struct {
 	UserID    string    `json:"userId" gorm:"index"`
 	Event     string    `json:"event"`
 	Details   string    `json:"details"`
+	IPAddress string    `json:"ipAddress,omitempty"` // Client address of the request that caused the event
+	UserAgent string    `json:"userAgent,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 	User      User      `json:"user" gorm:"foreignKey:UserID;references:FirebaseID"`
 }
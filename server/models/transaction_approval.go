@@ -1,28 +1,81 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Transaction approval statuses, see statemachine.Approvals for the allowed transitions
+const (
+	ApprovalStatusPending   = "pending"
+	ApprovalStatusApproved  = "approved"
+	ApprovalStatusRejected  = "rejected"
+	ApprovalStatusCancelled = "cancelled"
+	ApprovalStatusExpired   = "expired"
+	ApprovalStatusExecuting = "executing" // payment submitted, its tx hash is stored until the network confirms it
+	ApprovalStatusExecuted  = "executed"
+	ApprovalStatusRefunding = "refunding" // refund requested, its refund approval is signed and submitted
+	ApprovalStatusRefunded  = "refunded"
+)
+
+// Transaction approval kinds. Payments are requested by signers, the other kinds are
+// changes to the charity wallet the server builds itself and cosigners approve the same way.
+const (
+	ApprovalKindPayment   = "payment"
+	ApprovalKindSigners   = "signers"   // sets the cosigner keys and thresholds of the wallet
+	ApprovalKindTrustline = "trustline" // lets the wallet hold an issued asset
+	ApprovalKindRefund    = "refund"    // returns the unspent funds of its parent payment to the donors
+	ApprovalKindRelease   = "release"   // pays a milestone's funds from the escrow of its parent payment
+)
+
 // TransactionApproval represents a transaction that requires multi-signature approval
 type TransactionApproval struct {
 	gorm.Model
-	CharityID          uint    `json:"charityId"`
-	Amount             string  `json:"amount"`
-	Description        string  `json:"description"`
-	Category           string  `json:"category"`
-	RequestedByID      string  `json:"requestedById"`
-	RequiredSignatures int     `json:"requiredSignatures"`
-	CurrentSignatures  int     `json:"currentSignatures" gorm:"default:0"`
-	Status             string  `json:"status"` // pending, approved, rejected, executed
-	TxHash             string  `json:"txHash"` // Set when executed
-	Charity            Charity `json:"charity" gorm:"foreignKey:CharityID"`
+	CharityID          uint   `json:"charityId"`
+	Kind               string `json:"kind" gorm:"default:payment"`
+	ParentID           uint   `json:"parentId,omitempty" gorm:"index"`    // Payment a refund or release approval pays from
+	MilestoneID        uint   `json:"milestoneId,omitempty" gorm:"index"` // Milestone a release approval pays out
+	Amount             Money  `json:"amount"`
+	Asset              `gorm:"embedded"`
+	Description        string     `json:"description"`
+	CategoryID         uint       `json:"categoryId" gorm:"index"` // Budget category the payment is spent from, 0 for none
+	Category           string     `json:"category"`
+	Destination        string     `json:"destination"` // Stellar address that receives the payment
+	RequestedByID      string     `json:"requestedById"`
+	RequiredSignatures int        `json:"requiredSignatures"`
+	CurrentSignatures  int        `json:"currentSignatures" gorm:"default:0"`
+	Status             string     `json:"status"`                           // pending, approved, rejected, cancelled, expired, executing, executed, refunding, refunded
+	EnvelopeXDR        string     `json:"envelopeXdr"`                      // Unsigned payment envelope signed by each cosigner
+	EnvelopeHash       string     `json:"envelopeHash"`                     // Hex network hash of the envelope
+	TxHash             string     `json:"txHash"`                           // Set when executed
+	Ledger             int32      `json:"ledger"`                           // Ledger the payment was included in
+	ExecutedAt         *time.Time `json:"executedAt,omitempty"`             // When the payment was submitted
+	OverBudget         bool       `json:"overBudget" gorm:"default:false"`  // Overspends its budget category, see Charity.OverspendRule
+	Escrowed           bool       `json:"escrowed" gorm:"default:false"`    // Paid into an escrow account of its own and released per milestone
+	EscrowAddress      string     `json:"escrowAddress,omitempty"`          // Escrow account of an escrowed payment
+	EscrowSecret       string     `json:"-"`                                // Key that creates the escrow account, it has no weight afterwards
+	RefundTxHash       string     `json:"refundTxHash,omitempty"`           // Returns the unspent funds, set before the refund is submitted
+	ExpiresAt          *time.Time `json:"expiresAt,omitempty" gorm:"index"` // Pending and approved approvals expire after this
+	VerificationQuorum int        `json:"verificationQuorum"`               // Overrides the charity's milestone verification rule when set
+	RequireIndependent bool       `json:"requireIndependent" gorm:"default:false"`
+	SignerSet          string     `json:"signerSet,omitempty"` // Comma separated cosigner keys a signers approval sets on the wallet
+	Charity            Charity    `json:"charity" gorm:"foreignKey:CharityID"`
 }
 
 // ApprovalSignature represents a signature on a transaction approval
 type ApprovalSignature struct {
 	gorm.Model
-	ApprovalID uint   `json:"approvalId"`
-	SignerID   string `json:"signerId"`
-	Signature  string `json:"signature"` // Could be actual signature data in production
+	ApprovalID uint   `json:"approvalId" gorm:"uniqueIndex:idx_approval_signer"`
+	SignerID   string `json:"signerId" gorm:"uniqueIndex:idx_approval_signer"`
+	SignerKey  string `json:"signerKey"` // Stellar key the signature was verified against
+	Signature  string `json:"signature"` // Base64 XDR decorated signature over the envelope hash
+}
+
+// ApprovalRejection is a cosigner's vote against a transaction approval
+type ApprovalRejection struct {
+	gorm.Model
+	ApprovalID uint   `json:"approvalId" gorm:"uniqueIndex:idx_approval_rejecter"`
+	SignerID   string `json:"signerId" gorm:"uniqueIndex:idx_approval_rejecter"`
+	Reason     string `json:"reason"`
 }
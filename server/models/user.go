@@ -7,19 +7,23 @@ import (
 type UserRole string
 
 const (
-	RoleUser         UserRole = "USER"
-	RoleCharityOwner UserRole = "CHARITY_OWNER"
+	RoleUser              UserRole = "USER"
+	RoleCharityOwner      UserRole = "CHARITY_OWNER"
+	RoleAdmin             UserRole = "ADMIN"
+	RoleComplianceOfficer UserRole = "COMPLIANCE_OFFICER"
+	RoleAuditor           UserRole = "AUDITOR"
+	RoleMilestoneVerifier UserRole = "MILESTONE_VERIFIER"
 )
 
 type StellarAccount struct {
 	PublicKey string `json:"publicKey"`
-	SecretKey string `json:"secretKey"`
+	SecretKey string `json:"-"` // Encrypted at rest, never returned in JSON
 }
 
 type User struct {
 	gorm.Model
-	FirebaseID string `json:"firebase_id" gorm:"unique"`
-	Email      string `json:"email"`
-	Role       string `json:"role"`
+	FirebaseID    string         `json:"firebase_id" gorm:"unique"`
+	Email         string         `json:"email"`
+	Role          string         `json:"role"`
 	StellarWallet StellarAccount `json:"stellarWallet" gorm:"embedded"`
-} 
\ No newline at end of file
+}
@@ -0,0 +1,173 @@
+// Package policy decides what an authenticated user may read or change.
+package policy
+
+import (
+	"cleargive/server/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Principal is the authenticated user a request acts for
+type Principal struct {
+	UserID     uint
+	FirebaseID string
+	Email      string
+	Role       string
+}
+
+// FromContext returns the principal stored by middleware.AuthMiddleware.
+// The zero Principal is returned for unauthenticated requests.
+func FromContext(c *fiber.Ctx) Principal {
+	p := Principal{}
+	p.UserID, _ = c.Locals("userID").(uint)
+	p.FirebaseID, _ = c.Locals("firebaseID").(string)
+	p.Email, _ = c.Locals("email").(string)
+	p.Role, _ = c.Locals("userRole").(string)
+	return p
+}
+
+// Authenticated reports whether the principal belongs to a user record
+func (p Principal) Authenticated() bool {
+	return p.UserID != 0
+}
+
+// IsSelfOr reports whether p is the user with the given Firebase ID or holds perm
+func IsSelfOr(p Principal, userID string, perm Permission) bool {
+	if !p.Authenticated() {
+		return false
+	}
+	return p.FirebaseID == userID || p.Can(perm)
+}
+
+// CanReadDonor reports whether p may read the donations, certificates and tax
+// reports of the donor with the given Firebase ID
+func CanReadDonor(p Principal, donorID string) bool {
+	return IsSelfOr(p, donorID, PermDonorsRead)
+}
+
+// IsCharitySigner reports whether p can act for the charity on approvals and
+// milestones: its owner or an active cosigner bound to p's user account.
+// The cosigner is returned when p signs as a cosigner, nil for the owner.
+func IsCharitySigner(db *gorm.DB, p Principal, charity *models.Charity) (bool, *models.Cosigner, error) {
+	if !p.Authenticated() {
+		return false, nil, nil
+	}
+	if charity.OwnerID == p.UserID {
+		return true, nil, nil
+	}
+
+	var cosigner models.Cosigner
+	err := db.Where("charity_id = ? AND user_id = ? AND status = ?", charity.ID, p.UserID, models.CosignerStatusActive).
+		Limit(1).Find(&cosigner).Error
+	if err != nil || cosigner.ID == 0 {
+		return false, nil, err
+	}
+	return true, &cosigner, nil
+}
+
+// IsCharityMember reports whether p owns or cosigns the charity
+func IsCharityMember(db *gorm.DB, p Principal, charityID uint) (bool, error) {
+	if !p.Authenticated() {
+		return false, nil
+	}
+
+	var charity models.Charity
+	if err := db.Select("id", "owner_id").Where("id = ?", charityID).Limit(1).Find(&charity).Error; err != nil {
+		return false, err
+	}
+	if charity.ID == 0 {
+		return false, nil
+	}
+
+	member, _, err := IsCharitySigner(db, p, &charity)
+	return member, err
+}
+
+// MemberCharityIDs returns the charities p owns or cosigns
+func MemberCharityIDs(db *gorm.DB, p Principal) ([]uint, error) {
+	if !p.Authenticated() {
+		return nil, nil
+	}
+
+	var ids []uint
+	err := db.Model(&models.Charity{}).
+		Where("owner_id = ?", p.UserID).
+		Or("id IN (?)", db.Model(&models.Cosigner{}).Select("charity_id").
+			Where("user_id = ? AND status = ?", p.UserID, models.CosignerStatusActive)).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// CanReadCharity reports whether p may read a charity's private data,
+// such as its compliance checks
+func CanReadCharity(db *gorm.DB, p Principal, charityID uint) (bool, error) {
+	if p.Can(PermCharitiesRead) {
+		return true, nil
+	}
+	return IsCharityMember(db, p, charityID)
+}
+
+// CanReadDonation reports whether p may read a donation: its donor, the
+// members of the receiving charity and compliance officers may
+func CanReadDonation(db *gorm.DB, p Principal, donation *models.Donation) (bool, error) {
+	if CanReadDonor(p, donation.DonorID) {
+		return true, nil
+	}
+	return IsCharityMember(db, p, donation.CharityID)
+}
+
+// CanUpdateDonation reports whether p may edit a donation's details
+func CanUpdateDonation(p Principal, donation *models.Donation) bool {
+	return p.Authenticated() && (p.FirebaseID == donation.DonorID || p.Can(PermDonationsDelete))
+}
+
+// CanReassignDonation reports whether p may attribute a donation to another donor
+func CanReassignDonation(p Principal) bool {
+	return p.Can(PermDonationsDelete)
+}
+
+// CanDeleteDonation reports whether p may delete a donation.
+// Donations are part of the audit trail, so only compliance staff may.
+func CanDeleteDonation(p Principal) bool {
+	return p.Can(PermDonationsDelete)
+}
+
+// CanRunComplianceCheck reports whether p may request a compliance check of a
+// user or charity: users may check themselves, charity members their charity
+// and compliance officers anyone
+func CanRunComplianceCheck(db *gorm.DB, p Principal, userID string, charityID uint) (bool, error) {
+	if p.Can(PermComplianceUpdate) {
+		return true, nil
+	}
+	if userID != "" && userID != p.FirebaseID {
+		return false, nil
+	}
+	if charityID != 0 {
+		return IsCharityMember(db, p, charityID)
+	}
+	return p.Authenticated(), nil
+}
+
+// CanUpdateCompliance reports whether p may change the outcome of compliance checks
+func CanUpdateCompliance(p Principal) bool {
+	return p.Can(PermComplianceUpdate)
+}
+
+// CanReadMilestones reports whether p may see the milestones of a charity's
+// approvals: its members and the milestone verifiers who vote on them may
+func CanReadMilestones(db *gorm.DB, p Principal, charityID uint) (bool, error) {
+	if p.Can(PermMilestonesVerify) {
+		return true, nil
+	}
+	return IsCharityMember(db, p, charityID)
+}
+
+// CanReadMilestoneEvidence reports whether p may see the evidence uploaded for
+// a charity's milestones. Milestone verifiers need it to cast their votes.
+func CanReadMilestoneEvidence(db *gorm.DB, p Principal, charityID uint) (bool, error) {
+	if p.Can(PermMilestonesVerify) {
+		return true, nil
+	}
+	return CanReadCharity(db, p, charityID)
+}
@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"cleargive/server/models"
+)
+
+// Permission is an action that is granted to roles rather than to owners
+type Permission string
+
+// Permissions granted through roles
+const (
+	PermDonorsRead       Permission = "donors:read"      // read any donor's donations, certificates and tax reports
+	PermDonationsDelete  Permission = "donations:delete" // delete donations
+	PermAuditRead        Permission = "audit:read"       // read any user's audit trail
+	PermComplianceRead   Permission = "compliance:read"  // read compliance checks of any user or charity
+	PermComplianceUpdate Permission = "compliance:update"
+	PermCharitiesRead    Permission = "charities:read" // read private data of every charity
+	PermTotalsRebuild    Permission = "totals:rebuild"
+	PermMilestonesVerify Permission = "milestones:verify"
+	PermRolesAssign      Permission = "roles:assign"
+)
+
+// rolePermissions is the permission matrix. Owners and cosigners get access to
+// their own records through the ownership rules, not through this matrix.
+var rolePermissions = map[models.UserRole][]Permission{
+	models.RoleUser:         {},
+	models.RoleCharityOwner: {},
+	models.RoleAdmin: {
+		PermDonorsRead, PermDonationsDelete, PermAuditRead, PermComplianceRead,
+		PermComplianceUpdate, PermCharitiesRead, PermTotalsRebuild, PermRolesAssign,
+	},
+	models.RoleComplianceOfficer: {
+		PermDonorsRead, PermDonationsDelete, PermAuditRead, PermComplianceRead,
+		PermComplianceUpdate, PermCharitiesRead,
+	},
+	models.RoleAuditor: {
+		PermDonorsRead, PermAuditRead, PermComplianceRead, PermCharitiesRead,
+	},
+	models.RoleMilestoneVerifier: {
+		PermMilestonesVerify,
+	},
+}
+
+// HasPermission reports whether role grants perm
+func HasPermission(role string, perm Permission) bool {
+	for _, granted := range rolePermissions[models.UserRole(role)] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKnownRole reports whether role is one of the defined roles
+func IsKnownRole(role string) bool {
+	_, ok := rolePermissions[models.UserRole(role)]
+	return ok
+}
+
+// IsSelfAssignableRole reports whether users may pick role when signing up.
+// Every other role is assigned by an admin.
+func IsSelfAssignableRole(role string) bool {
+	return role == string(models.RoleUser) || role == string(models.RoleCharityOwner)
+}
+
+// Can reports whether the principal's role grants perm
+func (p Principal) Can(perm Permission) bool {
+	return p.Authenticated() && HasPermission(p.Role, perm)
+}
@@ -0,0 +1,550 @@
+package routes
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"cleargive/server/services/horizontest"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
+)
+
+// droppingHorizon loses the response of every submission. The transaction still
+// reaches the network when land is set.
+type droppingHorizon struct {
+	*horizontest.Horizon
+	land bool
+}
+
+func (h *droppingHorizon) SubmitTransaction(envelopeXDR string) (*services.SubmitResult, error) {
+	if h.land {
+		if _, err := h.Horizon.SubmitTransaction(envelopeXDR); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("horizon: timeout")
+}
+
+// signEnvelope signs an envelope with key the way a cosigner's own wallet does
+func signEnvelope(t *testing.T, envelopeXDR string, key *keypair.Full) string {
+	t.Helper()
+	parsed, err := txnbuild.TransactionFromXDR(envelopeXDR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := parsed.Transaction()
+	hash, err := tx.Hash(services.NetworkPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := key.SignDecorated(hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := xdr.MarshalBase64(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// approvedPayment creates an approval paying destination from the charity and signs it as the owner
+func (s *testServer) approvedPayment(charity models.Charity, ownerToken, destination string) models.TransactionApproval {
+	s.t.Helper()
+
+	status, body := s.do("POST", fmt.Sprintf("/api/charities/%d/approvals", charity.ID), ownerToken, map[string]interface{}{
+		"amount":      "25",
+		"description": "Supplies",
+		"destination": destination,
+	})
+	if status != 201 {
+		s.t.Fatalf("create approval: status %d, %v", status, body)
+	}
+
+	var approval models.TransactionApproval
+	config.DB.Where("charity_id = ?", charity.ID).Last(&approval)
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/sign", approval.ID), ownerToken, map[string]interface{}{}); status != 200 {
+		s.t.Fatalf("sign approval: status %d, %v", status, body)
+	}
+	config.DB.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusApproved {
+		s.t.Fatalf("approval is %s after signing", approval.Status)
+	}
+	return approval
+}
+
+func TestExecuteApprovalSubmitsPayment(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, _ := s.charity(owner)
+	destination := keypair.MustRandom().Address()
+	s.horizon.CreateAccount(destination)
+
+	approval := s.approvedPayment(charity, ownerToken, destination)
+	path := fmt.Sprintf("/api/charities/approvals/%d/execute", approval.ID)
+	if status, body := s.do("POST", path, ownerToken, nil); status != 200 {
+		t.Fatalf("execute: status %d, %v", status, body)
+	}
+
+	config.DB.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusExecuted || approval.TxHash != approval.EnvelopeHash || approval.ExecutedAt == nil {
+		t.Fatalf("approval after execute: status %s, tx %s, envelope %s", approval.Status, approval.TxHash, approval.EnvelopeHash)
+	}
+	payments, err := s.horizon.TransactionPayments(approval.TxHash)
+	if err != nil || len(payments) != 1 || payments[0].To != destination || payments[0].Amount != "25.0000000" {
+		t.Fatalf("payments of %s: %v, %v", approval.TxHash, payments, err)
+	}
+
+	// An executed approval cannot be paid again
+	if status, _ := s.do("POST", path, ownerToken, nil); status != 400 {
+		t.Fatalf("second execute: status %d", status)
+	}
+	if len(s.horizon.Submitted) != 1 {
+		t.Fatalf("%d transactions submitted", len(s.horizon.Submitted))
+	}
+}
+
+func TestExecuteApprovalFindsPaymentWhoseResponseWasLost(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, _ := s.charity(owner)
+	destination := keypair.MustRandom().Address()
+
+	approval := s.approvedPayment(charity, ownerToken, destination)
+	services.Horizon = &droppingHorizon{Horizon: s.horizon, land: true}
+
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/execute", approval.ID), ownerToken, nil); status != 200 {
+		t.Fatalf("execute: status %d, %v", status, body)
+	}
+	config.DB.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusExecuted || approval.TxHash != approval.EnvelopeHash {
+		t.Fatalf("approval after lost response: status %s, tx %s", approval.Status, approval.TxHash)
+	}
+}
+
+func TestExecuteApprovalStaysExecutingWhileOutcomeIsUnknown(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, _ := s.charity(owner)
+	destination := keypair.MustRandom().Address()
+
+	approval := s.approvedPayment(charity, ownerToken, destination)
+	services.Horizon = &droppingHorizon{Horizon: s.horizon}
+
+	path := fmt.Sprintf("/api/charities/approvals/%d/execute", approval.ID)
+	if status, body := s.do("POST", path, ownerToken, nil); status != 502 {
+		t.Fatalf("execute: status %d, %v", status, body)
+	}
+	config.DB.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusExecuting || approval.TxHash != approval.EnvelopeHash {
+		t.Fatalf("approval with unknown outcome: status %s, tx %s", approval.Status, approval.TxHash)
+	}
+
+	// The payment may still land, so it is not submitted again
+	services.Horizon = s.horizon
+	if status, _ := s.do("POST", path, ownerToken, nil); status != 409 {
+		t.Fatalf("execute while executing: status %d", status)
+	}
+	if len(s.horizon.Submitted) != 0 {
+		t.Fatalf("%d transactions submitted", len(s.horizon.Submitted))
+	}
+}
+
+// cosigner adds user as an active cosigner of charity with a signing key of their own
+func (s *testServer) cosigner(charity models.Charity, user models.User) *keypair.Full {
+	s.t.Helper()
+	key := keypair.MustRandom()
+	cosigner := models.Cosigner{
+		CharityID: charity.ID,
+		UserID:    user.ID,
+		Email:     user.Email,
+		PublicKey: key.Address(),
+		Status:    models.CosignerStatusActive,
+	}
+	if err := config.DB.Create(&cosigner).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return key
+}
+
+func TestNonCosignerCannotReachThreshold(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, _ := s.charity(owner)
+	cosignerUser, cosignerToken := s.user("cosigner", "")
+	cosignerKey := s.cosigner(charity, cosignerUser)
+	_, strangerToken := s.user("stranger", "")
+	stranger := keypair.MustRandom()
+	config.DB.Model(&charity).Updates(map[string]interface{}{"is_multi_sig": true, "required_signatures": 2})
+
+	status, body := s.do("POST", fmt.Sprintf("/api/charities/%d/approvals", charity.ID), ownerToken, map[string]interface{}{
+		"amount":      "25",
+		"description": "Supplies",
+		"destination": keypair.MustRandom().Address(),
+	})
+	if status != 201 {
+		t.Fatalf("create approval: status %d, %v", status, body)
+	}
+	var approval models.TransactionApproval
+	config.DB.Where("charity_id = ?", charity.ID).Last(&approval)
+	if approval.RequiredSignatures != 2 {
+		t.Fatalf("approval requires %d signatures", approval.RequiredSignatures)
+	}
+
+	sign := func(key *keypair.Full) string {
+		return signEnvelope(t, approval.EnvelopeXDR, key)
+	}
+	signPath := fmt.Sprintf("/api/charities/approvals/%d/sign", approval.ID)
+	if status, body := s.do("POST", signPath, ownerToken, map[string]interface{}{}); status != 200 {
+		t.Fatalf("owner signature: status %d, %v", status, body)
+	}
+
+	attempts := []struct {
+		name   string
+		token  string
+		body   map[string]interface{}
+		status int
+	}{
+		{"stranger claiming the cosigner's email", strangerToken, map[string]interface{}{"email": cosignerUser.Email, "signature": sign(stranger)}, 403},
+		{"stranger replaying the cosigner's signature", strangerToken, map[string]interface{}{"email": cosignerUser.Email, "signature": sign(cosignerKey)}, 403},
+		{"cosigner signing with another key", cosignerToken, map[string]interface{}{"signature": sign(stranger)}, 400},
+		{"owner signing twice", ownerToken, map[string]interface{}{}, 400},
+	}
+	for _, attempt := range attempts {
+		if status, body := s.do("POST", signPath, attempt.token, attempt.body); status != attempt.status {
+			t.Errorf("%s: status %d, %v", attempt.name, status, body)
+		}
+	}
+
+	var signatures int64
+	config.DB.Model(&models.ApprovalSignature{}).Where("approval_id = ?", approval.ID).Count(&signatures)
+	config.DB.First(&approval, approval.ID)
+	if signatures != 1 || approval.Status != models.ApprovalStatusPending {
+		t.Fatalf("after the refused signatures: %d signatures, status %s", signatures, approval.Status)
+	}
+	executePath := fmt.Sprintf("/api/charities/approvals/%d/execute", approval.ID)
+	if status, _ := s.do("POST", executePath, ownerToken, nil); status != 400 {
+		t.Fatalf("execute below the threshold: status %d", status)
+	}
+	if len(s.horizon.Submitted) != 0 {
+		t.Fatalf("%d transactions submitted below the threshold", len(s.horizon.Submitted))
+	}
+
+	// The cosigner's own signature completes the approval
+	if status, body := s.do("POST", signPath, cosignerToken, map[string]interface{}{"signature": sign(cosignerKey)}); status != 200 {
+		t.Fatalf("cosigner signature: status %d, %v", status, body)
+	}
+	if status, body := s.do("POST", executePath, ownerToken, nil); status != 200 {
+		t.Fatalf("execute: status %d, %v", status, body)
+	}
+}
+
+func TestParallelSignaturesReachThresholdOnce(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, _ := s.charity(owner)
+
+	// The owner and eight cosigners sign, each of them twice, while five signatures are required
+	type signer struct {
+		token string
+		key   *keypair.Full // nil for the owner
+	}
+	signers := []signer{{token: ownerToken}}
+	for i := 0; i < 8; i++ {
+		user, token := s.user(fmt.Sprintf("cosigner-%d", i), "")
+		signers = append(signers, signer{token: token, key: s.cosigner(charity, user)})
+	}
+	const required = 5
+	config.DB.Model(&charity).Updates(map[string]interface{}{"is_multi_sig": true, "required_signatures": required})
+
+	status, body := s.do("POST", fmt.Sprintf("/api/charities/%d/approvals", charity.ID), ownerToken, map[string]interface{}{
+		"amount":      "25",
+		"description": "Supplies",
+		"destination": keypair.MustRandom().Address(),
+	})
+	if status != 201 {
+		t.Fatalf("create approval: status %d, %v", status, body)
+	}
+	var approval models.TransactionApproval
+	config.DB.Where("charity_id = ?", charity.ID).Last(&approval)
+
+	requests := make([]*http.Request, 0, 2*len(signers))
+	for _, signer := range signers {
+		body := "{}"
+		if signer.key != nil {
+			body = fmt.Sprintf(`{"signature":%q}`, signEnvelope(t, approval.EnvelopeXDR, signer.key))
+		}
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("POST", fmt.Sprintf("/api/charities/approvals/%d/sign", approval.ID), strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+signer.token)
+			requests = append(requests, req)
+		}
+	}
+
+	statuses := make([]int, len(requests))
+	errs := make([]error, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func(i int, req *http.Request) {
+			defer wg.Done()
+			resp, err := s.app.Test(req, -1)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}(i, req)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for i, status := range statuses {
+		switch {
+		case errs[i] != nil:
+			t.Fatalf("request %d: %v", i, errs[i])
+		case status == 200:
+			accepted++
+		case status != 400:
+			t.Errorf("request %d: status %d", i, status)
+		}
+	}
+	if accepted != required {
+		t.Errorf("%d signatures accepted, want %d", accepted, required)
+	}
+
+	var signatures, approvals int64
+	config.DB.Model(&models.ApprovalSignature{}).Where("approval_id = ?", approval.ID).Count(&signatures)
+	config.DB.Model(&models.StatusTransition{}).
+		Where("entity = ? AND entity_id = ? AND to_status = ?", "transaction_approval", approval.ID, models.ApprovalStatusApproved).
+		Count(&approvals)
+	config.DB.First(&approval, approval.ID)
+	if signatures != required || approval.CurrentSignatures != required || approval.Status != models.ApprovalStatusApproved || approvals != 1 {
+		t.Fatalf("%d signatures, %d counted, status %s, approved %d times", signatures, approval.CurrentSignatures, approval.Status, approvals)
+	}
+}
+
+func TestRefundIsRecordedBeforeCosignersSignIt(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, _ := s.charity(owner)
+	cosignerUser, cosignerToken := s.user("cosigner", "")
+	cosigner := s.cosigner(charity, cosignerUser)
+	destination := keypair.MustRandom().Address()
+	s.horizon.CreateAccount(destination)
+	donor := keypair.MustRandom().Address()
+	s.horizon.CreateAccount(donor)
+
+	donation := models.Donation{Amount: 1_000_000_000, Asset: models.NativeAsset(), CharityID: charity.ID, TxHash: "donation",
+		Status: services.DonationStatusConfirmed, SourceAccount: donor}
+	config.DB.Create(&donation)
+	payment := s.approvedPayment(charity, ownerToken, destination)
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/execute", payment.ID), ownerToken, nil); status != 200 {
+		t.Fatalf("execute: status %d, %v", status, body)
+	}
+
+	// From now on the wallet needs the cosigner's signature as well
+	status, body := s.do("PATCH", fmt.Sprintf("/api/charities/%d/multisig", charity.ID), ownerToken, map[string]interface{}{
+		"isMultiSig":         true,
+		"requiredSignatures": 2,
+	})
+	if status != 200 {
+		t.Fatalf("enable multisig: status %d, %v", status, body)
+	}
+
+	refundPath := fmt.Sprintf("/api/charities/approvals/%d/refund", payment.ID)
+	requestRefund := func() models.TransactionApproval {
+		t.Helper()
+		if status, body := s.do("POST", refundPath, ownerToken, nil); status != 202 {
+			t.Fatalf("refund: status %d, %v", status, body)
+		}
+		refund := s.lastApproval(charity, models.ApprovalKindRefund)
+		config.DB.First(&payment, payment.ID)
+		if payment.Status != models.ApprovalStatusRefunding || payment.RefundTxHash != refund.EnvelopeHash || refund.ParentID != payment.ID {
+			t.Fatalf("payment %s with refund tx %s, refund approval of #%d with envelope %s", payment.Status, payment.RefundTxHash, refund.ParentID, refund.EnvelopeHash)
+		}
+		var pending []models.Refund
+		config.DB.Where("approval_id = ?", payment.ID).Find(&pending)
+		if len(pending) != 1 || pending[0].Amount != 250_000_000 || pending[0].Destination != donor || pending[0].TxHash != "" {
+			t.Fatalf("refunds while refunding: %+v", pending)
+		}
+		return refund
+	}
+
+	// The refund is recorded before it is signed, so it cannot be requested twice
+	refund := requestRefund()
+	if status, _ := s.do("POST", refundPath, ownerToken, nil); status != 400 {
+		t.Fatalf("second refund: status %d", status)
+	}
+
+	// Cancelling the refund approval returns the payment to executed
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/cancel", refund.ID), ownerToken, nil); status != 200 {
+		t.Fatalf("cancel refund: status %d, %v", status, body)
+	}
+	var refunds int64
+	config.DB.Model(&models.Refund{}).Where("approval_id = ?", payment.ID).Count(&refunds)
+	config.DB.First(&payment, payment.ID)
+	if payment.Status != models.ApprovalStatusExecuted || payment.RefundTxHash != "" || refunds != 0 {
+		t.Fatalf("payment %s with refund tx %q and %d refunds after cancelling", payment.Status, payment.RefundTxHash, refunds)
+	}
+
+	// Once the cosigner signs, the refund is paid and settled
+	refund = requestRefund()
+	signature := signEnvelope(t, refund.EnvelopeXDR, cosigner)
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/sign", refund.ID), cosignerToken, map[string]interface{}{"signature": signature}); status != 200 {
+		t.Fatalf("sign refund: status %d, %v", status, body)
+	}
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/execute", refund.ID), ownerToken, nil); status != 200 {
+		t.Fatalf("execute refund: status %d, %v", status, body)
+	}
+
+	config.DB.First(&refund, refund.ID)
+	config.DB.First(&payment, payment.ID)
+	var settled models.Refund
+	config.DB.Where("approval_id = ?", payment.ID).First(&settled)
+	if payment.Status != models.ApprovalStatusRefunded || payment.RefundTxHash != refund.TxHash || settled.TxHash != refund.TxHash {
+		t.Fatalf("payment %s with refund tx %s, refund tx %s", payment.Status, payment.RefundTxHash, settled.TxHash)
+	}
+	payments, err := s.horizon.TransactionPayments(refund.TxHash)
+	if err != nil || len(payments) != 1 || payments[0].To != donor || payments[0].Amount != "25.0000000" {
+		t.Fatalf("refund payments: %v, %v", payments, err)
+	}
+}
+
+func TestEscrowedPaymentNeedsCosignersToMoveFunds(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, wallet := s.charity(owner)
+	cosignerUser, cosignerToken := s.user("cosigner", "")
+	cosigner := s.cosigner(charity, cosignerUser)
+	destination := keypair.MustRandom().Address()
+	s.horizon.CreateAccount(destination)
+	donor := keypair.MustRandom().Address()
+	s.horizon.CreateAccount(donor)
+
+	donation := models.Donation{Amount: 1_000_000_000, Asset: models.NativeAsset(), CharityID: charity.ID, TxHash: "donation",
+		Status: services.DonationStatusConfirmed, SourceAccount: donor}
+	config.DB.Create(&donation)
+
+	// The payment creates an escrow account that only the wallet key together with the cosigner's key controls
+	status, body := s.do("POST", fmt.Sprintf("/api/charities/%d/approvals", charity.ID), ownerToken, map[string]interface{}{
+		"amount":      "25",
+		"description": "Supplies",
+		"destination": destination,
+		"escrow":      true,
+	})
+	if status != 201 {
+		t.Fatalf("create approval: status %d, %v", status, body)
+	}
+	payment := s.lastApproval(charity, models.ApprovalKindPayment)
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/sign", payment.ID), ownerToken, map[string]interface{}{}); status != 200 {
+		t.Fatalf("sign approval: status %d, %v", status, body)
+	}
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/execute", payment.ID), ownerToken, nil); status != 200 {
+		t.Fatalf("execute: status %d, %v", status, body)
+	}
+	config.DB.First(&payment, payment.ID)
+	escrow, err := s.horizon.AccountAuth(payment.EscrowAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.Signers[payment.EscrowAddress] != 0 || escrow.Signers[wallet.Address()] != 1 || escrow.Signers[cosigner.Address()] != 1 ||
+		escrow.MedThreshold != 2 || escrow.HighThreshold != 2 {
+		t.Fatalf("escrow signers %v, thresholds %d/%d", escrow.Signers, escrow.MedThreshold, escrow.HighThreshold)
+	}
+
+	signAndExecute := func(approval models.TransactionApproval) models.TransactionApproval {
+		t.Helper()
+		signature := signEnvelope(t, approval.EnvelopeXDR, cosigner)
+		if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/sign", approval.ID), cosignerToken, map[string]interface{}{"signature": signature}); status != 200 {
+			t.Fatalf("cosigner signature: status %d, %v", status, body)
+		}
+		if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/execute", approval.ID), ownerToken, nil); status != 200 {
+			t.Fatalf("execute: status %d, %v", status, body)
+		}
+		config.DB.First(&approval, approval.ID)
+		return approval
+	}
+
+	// A verified milestone is released once the cosigner signs the release
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/milestones", payment.ID), ownerToken, map[string]interface{}{
+		"name":        "First delivery",
+		"description": "Half of the supplies",
+		"amount":      "10",
+	}); status != 201 {
+		t.Fatalf("create milestone: status %d, %v", status, body)
+	}
+	var milestone models.Milestone
+	config.DB.Where("approval_id = ?", payment.ID).First(&milestone)
+	config.DB.Model(&milestone).Update("status", models.MilestoneStatusVerified)
+
+	releasePath := fmt.Sprintf("/api/charities/milestones/%d/release", milestone.ID)
+	requestRelease := func() models.TransactionApproval {
+		t.Helper()
+		if status, body := s.do("POST", releasePath, ownerToken, nil); status != 202 {
+			t.Fatalf("release: status %d, %v", status, body)
+		}
+		release := s.lastApproval(charity, models.ApprovalKindRelease)
+		if release.Status != models.ApprovalStatusPending || release.CurrentSignatures != 1 || release.RequiredSignatures != 2 || release.MilestoneID != milestone.ID {
+			t.Fatalf("release %s with %d of %d signatures for milestone #%d", release.Status, release.CurrentSignatures, release.RequiredSignatures, release.MilestoneID)
+		}
+		config.DB.First(&milestone, milestone.ID)
+		if milestone.Status != models.MilestoneStatusReleasing || milestone.ReleaseTxHash != release.EnvelopeHash {
+			t.Fatalf("milestone %s with release tx %q while its release waits", milestone.Status, milestone.ReleaseTxHash)
+		}
+		return release
+	}
+
+	// The release is recorded before it is signed, so it cannot be requested twice
+	release := requestRelease()
+	if status, _ := s.do("POST", releasePath, ownerToken, nil); status != 409 {
+		t.Fatalf("second release: status %d", status)
+	}
+
+	// Cancelling the release approval returns the milestone to verified
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/cancel", release.ID), ownerToken, nil); status != 200 {
+		t.Fatalf("cancel release: status %d, %v", status, body)
+	}
+	config.DB.First(&milestone, milestone.ID)
+	if milestone.Status != models.MilestoneStatusVerified || milestone.ReleaseTxHash != "" {
+		t.Fatalf("milestone %s with release tx %q after cancelling", milestone.Status, milestone.ReleaseTxHash)
+	}
+
+	release = signAndExecute(requestRelease())
+	config.DB.First(&milestone, milestone.ID)
+	if milestone.Status != models.MilestoneStatusReleased || milestone.ReleaseTxHash != release.TxHash {
+		t.Fatalf("milestone %s with release tx %q", milestone.Status, milestone.ReleaseTxHash)
+	}
+	payments, err := s.horizon.TransactionPayments(release.TxHash)
+	if err != nil || len(payments) != 1 || payments[0].From != payment.EscrowAddress || payments[0].To != destination || payments[0].Amount != "10.0000000" {
+		t.Fatalf("release payments: %v, %v", payments, err)
+	}
+
+	// The refund pays the donor back from the escrow account and closes it
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/refund", payment.ID), ownerToken, nil); status != 202 {
+		t.Fatalf("refund: status %d, %v", status, body)
+	}
+	refund := signAndExecute(s.lastApproval(charity, models.ApprovalKindRefund))
+	config.DB.First(&payment, payment.ID)
+	if payment.Status != models.ApprovalStatusRefunded {
+		t.Fatalf("payment %s after the refund", payment.Status)
+	}
+	payments, err = s.horizon.TransactionPayments(refund.TxHash)
+	if err != nil || len(payments) != 1 || payments[0].To != donor || payments[0].Amount != "15.0000000" {
+		t.Fatalf("refund payments: %v, %v", payments, err)
+	}
+	if _, err := s.horizon.AccountAuth(payment.EscrowAddress); err == nil {
+		t.Fatal("escrow account left open after the refund")
+	}
+}
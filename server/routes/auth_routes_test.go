@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// routeCase is a request to one route of the API
+type routeCase struct {
+	method string
+	path   string
+	body   interface{}
+}
+
+func (r routeCase) String() string {
+	return r.method + " " + r.path
+}
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	s := newTestServer(t)
+	s.user("known", "")
+
+	routes := []routeCase{
+		{"POST", "/api/users", map[string]interface{}{"firebase_id": "x", "role": "USER"}},
+		{"PUT", "/api/users/1", map[string]interface{}{}},
+		{"PUT", "/api/users/1/role", map[string]interface{}{"role": "ADMIN"}},
+		{"GET", "/api/donations", nil},
+		{"GET", "/api/donations/1", nil},
+		{"POST", "/api/donations", map[string]interface{}{}},
+		{"PUT", "/api/donations/1", map[string]interface{}{}},
+		{"DELETE", "/api/donations/1", nil},
+		{"POST", "/api/charities", map[string]interface{}{}},
+		{"GET", "/api/charities/1/multisig", nil},
+		{"PATCH", "/api/charities/1/multisig", map[string]interface{}{}},
+		{"POST", "/api/charities/1/cosigners", map[string]interface{}{}},
+		{"POST", "/api/charities/1/budget", map[string]interface{}{}},
+		{"GET", "/api/charities/1/periods", nil},
+		{"POST", "/api/charities/1/totals/rebuild", nil},
+		{"PATCH", "/api/charities/1/transfer-ownership", map[string]interface{}{}},
+		{"GET", "/api/charities/1/approvals", nil},
+		{"POST", "/api/charities/approvals/1/sign", map[string]interface{}{}},
+		{"POST", "/api/charities/approvals/1/execute", nil},
+		{"GET", "/api/charities/approvals/1/milestones", nil},
+		{"PATCH", "/api/charities/milestones/1/verify", map[string]interface{}{}},
+		{"GET", "/api/charities/milestones/1/evidence", nil},
+		{"GET", "/api/charities/1/refund-proposals", nil},
+		{"POST", "/api/certificates", map[string]interface{}{}},
+		{"GET", "/api/certificates/user/known", nil},
+		{"GET", "/api/certificates/1", nil},
+		{"GET", "/api/tax-reports/user/known", nil},
+		{"GET", "/api/tax-reports/1", nil},
+		{"POST", "/api/tax-reports", map[string]interface{}{}},
+		{"GET", "/api/tax-reports/user/known/year/2026", nil},
+		{"GET", "/api/audit/user/known", nil},
+		{"GET", "/api/compliance/user/known", nil},
+		{"GET", "/api/compliance/charity/1", nil},
+		{"POST", "/api/compliance", map[string]interface{}{}},
+		{"PUT", "/api/compliance/1", map[string]interface{}{}},
+		{"GET", "/api/notifications", nil},
+		{"PATCH", "/api/notifications/1/read", nil},
+	}
+
+	expired := s.signToken(map[string]interface{}{
+		"sub": "known",
+		"iss": testIssuer,
+		"aud": testAudience,
+		"iat": time.Now().Add(-2 * time.Hour).Unix(),
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	// The payload of another token under the signature of the known user's token
+	signed := strings.Split(s.token("known", "known@example.org"), ".")
+	forged := strings.Split(s.token("admin", "admin@example.org"), ".")
+	tampered := signed[0] + "." + forged[1] + "." + signed[2]
+
+	tokens := []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"malformed token", "not-a-token"},
+		{"expired token", expired},
+		{"token with a tampered payload", tampered},
+	}
+
+	for _, route := range routes {
+		for _, token := range tokens {
+			if status, body := s.do(route.method, route.path, token.token, route.body); status != 401 {
+				t.Errorf("%s with %s: status %d, %v", route, token.name, status, body)
+			}
+		}
+	}
+
+	// Signup only needs a valid token, every other route needs a user for it
+	for _, route := range routes[1:] {
+		if status, body := s.do(route.method, route.path, s.token("unknown", "unknown@example.org"), route.body); status != 401 {
+			t.Errorf("%s for a user without an account: status %d, %v", route, status, body)
+		}
+	}
+}
+
+func TestRoutesCheckOwnership(t *testing.T) {
+	s := newTestServer(t)
+
+	owner, ownerToken := s.user("owner", string(models.RoleCharityOwner))
+	charity, _ := s.charity(owner)
+	donor, donorToken := s.user("donor", string(models.RoleUser))
+	stranger, strangerToken := s.user("stranger", string(models.RoleUser))
+	_, officerToken := s.user("officer", string(models.RoleComplianceOfficer))
+
+	donation := models.Donation{Amount: 10_000_000, Asset: models.NativeAsset(), CharityID: charity.ID, DonorID: donor.FirebaseID, TxHash: "donation"}
+	report := models.TaxReport{UserID: donor.FirebaseID, Year: 2026, Status: "ready"}
+	check := models.ComplianceCheck{UserID: donor.FirebaseID, Type: "kyc", Status: "pending"}
+	notification := models.Notification{UserID: donor.ID, Event: "test", Message: "Hello"}
+	approval := models.TransactionApproval{CharityID: charity.ID, Amount: 10_000_000, Asset: models.NativeAsset(), Status: models.ApprovalStatusPending, RequiredSignatures: 1}
+	approved := models.TransactionApproval{CharityID: charity.ID, Amount: 10_000_000, Asset: models.NativeAsset(), Status: models.ApprovalStatusApproved, RequiredSignatures: 1}
+	for _, record := range []interface{}{&donation, &report, &check, &notification, &approval, &approved} {
+		if err := config.DB.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	certificate := models.Certificate{DonationID: donation.ID, TokenID: "token", Status: "minted"}
+	if err := config.DB.Create(&certificate).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		route    routeCase
+		owner    string // Token of a caller the route is for
+		stranger int    // Status for anyone else when not 403
+	}{
+		// Users
+		{routeCase{"PUT", fmt.Sprintf("/api/users/%d", donor.ID), map[string]interface{}{}}, "", 0},
+		{routeCase{"PUT", fmt.Sprintf("/api/users/%d/role", donor.ID), map[string]interface{}{"role": "ADMIN"}}, "", 0},
+
+		// Donations
+		{routeCase{"GET", fmt.Sprintf("/api/donations/%d", donation.ID), nil}, donorToken, 0},
+		{routeCase{"PUT", fmt.Sprintf("/api/donations/%d", donation.ID), map[string]interface{}{"message": "Mine"}}, "", 0},
+		{routeCase{"DELETE", fmt.Sprintf("/api/donations/%d", donation.ID), nil}, "", 0},
+		{routeCase{"POST", "/api/donations", map[string]interface{}{"charityId": charity.ID, "donorId": donor.FirebaseID, "txHash": "other", "amount": "1"}}, "", 0},
+
+		// Charities
+		{routeCase{"GET", fmt.Sprintf("/api/charities/%d/multisig", charity.ID), nil}, ownerToken, 0},
+		{routeCase{"PATCH", fmt.Sprintf("/api/charities/%d/multisig", charity.ID), map[string]interface{}{"enabled": true, "requiredSignatures": 1}}, "", 0},
+		{routeCase{"PATCH", fmt.Sprintf("/api/charities/%d/approval-settings", charity.ID), map[string]interface{}{}}, "", 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/%d/cosigners", charity.ID), map[string]interface{}{"email": "x@example.org"}}, "", 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/%d/budget", charity.ID), map[string]interface{}{"name": "Food", "allocation": 10}}, "", 0},
+		{routeCase{"GET", fmt.Sprintf("/api/charities/%d/periods", charity.ID), nil}, ownerToken, 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/%d/totals/rebuild", charity.ID), nil}, "", 0},
+		{routeCase{"PATCH", fmt.Sprintf("/api/charities/%d/transfer-ownership", charity.ID), map[string]interface{}{"newOwnerId": stranger.ID, "email": stranger.Email}}, "", 0},
+		{routeCase{"GET", fmt.Sprintf("/api/charities/%d/approvals", charity.ID), nil}, ownerToken, 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/%d/approvals", charity.ID), map[string]interface{}{"amount": "1", "description": "x", "destination": charity.WalletAddress}}, "", 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/approvals/%d/sign", approval.ID), map[string]interface{}{}}, "", 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/approvals/%d/reject", approval.ID), map[string]interface{}{"reason": "No"}}, "", 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/approvals/%d/cancel", approval.ID), map[string]interface{}{}}, "", 0},
+		{routeCase{"GET", fmt.Sprintf("/api/charities/approvals/%d/history", approval.ID), nil}, ownerToken, 0},
+		{routeCase{"GET", fmt.Sprintf("/api/charities/approvals/%d/milestones", approval.ID), nil}, ownerToken, 0},
+		{routeCase{"GET", fmt.Sprintf("/api/charities/approvals/%d/milestones/summary", approval.ID), nil}, ownerToken, 0},
+		{routeCase{"POST", fmt.Sprintf("/api/charities/approvals/%d/execute", approved.ID), nil}, "", 0},
+		{routeCase{"GET", fmt.Sprintf("/api/charities/%d/refund-proposals", charity.ID), nil}, ownerToken, 0},
+
+		// Certificates
+		{routeCase{"GET", "/api/certificates/user/donor", nil}, donorToken, 0},
+		{routeCase{"GET", fmt.Sprintf("/api/certificates/%d", certificate.ID), nil}, donorToken, 0},
+		{routeCase{"POST", "/api/certificates", map[string]interface{}{"donationId": donation.ID}}, "", 0},
+
+		// Tax reports, audit and compliance
+		{routeCase{"GET", "/api/tax-reports/user/donor", nil}, donorToken, 0},
+		{routeCase{"GET", fmt.Sprintf("/api/tax-reports/%d", report.ID), nil}, donorToken, 0},
+		{routeCase{"GET", "/api/tax-reports/user/donor/year/2026", nil}, donorToken, 0},
+		{routeCase{"POST", "/api/tax-reports", map[string]interface{}{"userId": "donor", "year": 2026}}, "", 0},
+		{routeCase{"GET", "/api/audit/user/donor", nil}, donorToken, 0},
+		{routeCase{"GET", "/api/compliance/user/donor", nil}, officerToken, 0},
+		{routeCase{"GET", fmt.Sprintf("/api/compliance/charity/%d", charity.ID), nil}, ownerToken, 0},
+		{routeCase{"POST", "/api/compliance", map[string]interface{}{"userId": "donor", "type": "kyc"}}, "", 0},
+		{routeCase{"PUT", fmt.Sprintf("/api/compliance/%d", check.ID), map[string]interface{}{"status": "passed"}}, "", 0},
+
+		// Notifications
+		{routeCase{"PATCH", fmt.Sprintf("/api/notifications/%d/read", notification.ID), nil}, "", 404}, // Other users' notifications are not disclosed
+	}
+
+	for _, test := range tests {
+		refused := test.stranger
+		if refused == 0 {
+			refused = 403
+		}
+		if status, body := s.do(test.route.method, test.route.path, strangerToken, test.route.body); status != refused {
+			t.Errorf("%s as a stranger: status %d, %v", test.route, status, body)
+		}
+		if test.owner == "" {
+			continue
+		}
+		if status, body := s.do(test.route.method, test.route.path, test.owner, test.route.body); status != 200 {
+			t.Errorf("%s as its owner: status %d, %v", test.route, status, body)
+		}
+	}
+
+	// Records the stranger was refused are unchanged
+	var unchanged models.Donation
+	config.DB.First(&unchanged, donation.ID)
+	if unchanged.Message != "" || unchanged.DeletedAt.Valid {
+		t.Fatalf("donation changed by a stranger: %+v", unchanged)
+	}
+	config.DB.First(&approval, approval.ID)
+	config.DB.First(&approved, approved.ID)
+	if approval.Status != models.ApprovalStatusPending || approved.Status != models.ApprovalStatusApproved {
+		t.Fatalf("approvals are %s and %s", approval.Status, approved.Status)
+	}
+	config.DB.First(&charity, charity.ID)
+	if charity.OwnerID != owner.ID {
+		t.Fatalf("charity is owned by user #%d", charity.OwnerID)
+	}
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"cleargive/server/controllers"
+	"cleargive/server/middleware"
+	"cleargive/server/policy"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -9,15 +11,19 @@ import (
 func SetupCertificateRoutes(router fiber.Router) {
 	certificates := router.Group("/certificates")
 
-	certificates.Post("/", controllers.GenerateCertificate)
-
-	certificates.Get("/:id", controllers.GetCertificate)
-
+	// Public routes, certificates are verifiable by anyone holding the token ID
 	certificates.Get("/token/:tokenId", controllers.GetCertificateByToken)
 
 	certificates.Get("/:tokenId/metadata", controllers.GetCertificateMetadata)
 
-	certificates.Get("/user/:userId", controllers.GetUserCertificates)
-
 	certificates.Get("/verify/:tokenId", controllers.VerifyCertificate)
+
+	// Protected routes
+	certificates.Use(middleware.AuthMiddleware())
+
+	certificates.Post("/", controllers.GenerateCertificate)
+
+	certificates.Get("/user/:userId", middleware.RequireSelf("userId", policy.PermDonorsRead), controllers.GetUserCertificates)
+
+	certificates.Get("/:id", controllers.GetCertificate)
 }
@@ -13,21 +13,45 @@ func SetupCharityRoutes(router fiber.Router) {
 	// Public routes
 	charities.Get("/", controllers.GetCharities)
 	charities.Get("/:id", controllers.GetCharity)
+	charities.Get("/:id/totals", controllers.GetCharityTotals)
+	charities.Get("/:id/assets", controllers.GetCharityAssets)
 
 	// Protected routes
 	charities.Use(middleware.AuthMiddleware())
 	charities.Post("/", controllers.CreateCharity)
 
 	// Multi-signature wallet management
+	charities.Get("/:id/multisig", controllers.GetMultiSigStatus)
 	charities.Patch("/:id/multisig", controllers.UpdateMultiSigSettings)
+	charities.Post("/:id/multisig/sync", controllers.SyncWalletSigners)
+	charities.Patch("/:id/approval-settings", controllers.UpdateApprovalSettings)
+	charities.Patch("/:id/verification-settings", controllers.UpdateVerificationSettings)
 	charities.Post("/:id/cosigners", controllers.AddCosigner)
+	charities.Post("/:id/cosigners/:cosignerId/invite", controllers.ReissueCosignerInvite)
 	charities.Delete("/:id/cosigners/:cosignerId", controllers.RemoveCosigner)
+	charities.Post("/cosigners/accept", controllers.AcceptCosignerInvite)
+
+	// Accepted assets
+	charities.Post("/:id/assets", controllers.AddCharityAsset)
 
 	// Budget category management
+	charities.Get("/:id/budget/report", controllers.GetBudgetReport)
 	charities.Post("/:id/budget", controllers.AddBudgetCategory)
 	charities.Patch("/:id/budget/:categoryId", controllers.UpdateBudgetCategory)
 	charities.Delete("/:id/budget/:categoryId", controllers.DeleteBudgetCategory)
 
+	// Fiscal periods and budget versions
+	charities.Get("/:id/periods", controllers.GetFiscalPeriods)
+	charities.Post("/:id/periods", controllers.CreateFiscalPeriod)
+	charities.Patch("/:id/periods/:periodId", controllers.UpdateFiscalPeriod)
+	charities.Post("/:id/periods/:periodId/close", controllers.CloseFiscalPeriod)
+	charities.Post("/:id/periods/:periodId/roll-forward", controllers.RollForwardFiscalPeriod)
+	charities.Get("/:id/periods/:periodId/versions", controllers.GetBudgetVersions)
+	charities.Get("/:id/periods/:periodId/versions/compare", controllers.CompareBudgetVersions)
+
+	// Donation totals
+	charities.Post("/:id/totals/rebuild", controllers.RebuildCharityTotals)
+
 	// Ownership transfer route
 	charities.Patch("/:id/transfer-ownership", controllers.TransferCharityOwnership)
 
@@ -35,13 +59,28 @@ func SetupCharityRoutes(router fiber.Router) {
 	charities.Get("/:id/approvals", controllers.GetPendingApprovals)
 	charities.Post("/:id/approvals", controllers.CreateTransactionApproval)
 	charities.Post("/approvals/:approvalId/sign", controllers.AddApprovalSignature)
+	charities.Post("/approvals/:approvalId/reject", controllers.RejectApproval)
+	charities.Post("/approvals/:approvalId/cancel", controllers.CancelApproval)
+	charities.Get("/approvals/:approvalId/history", controllers.GetApprovalHistory)
+	charities.Get("/approvals/:approvalId/funding", controllers.GetApprovalFunding)
 	charities.Post("/approvals/:approvalId/execute", controllers.ExecuteApproval)
 	charities.Post("/approvals/:approvalId/refund", controllers.RefundUnspentFunds)
 
+	// Refund proposals for overdue milestones
+	charities.Get("/:id/refund-proposals", controllers.GetRefundProposals)
+	charities.Post("/refund-proposals/:proposalId/accept", controllers.AcceptRefundProposal)
+	charities.Post("/refund-proposals/:proposalId/dismiss", controllers.DismissRefundProposal)
+
 	// Milestone management routes
 	charities.Get("/approvals/:approvalId/milestones", controllers.GetMilestones)
 	charities.Post("/approvals/:approvalId/milestones", controllers.CreateMilestone)
+	charities.Get("/approvals/:approvalId/milestones/summary", controllers.GetMilestoneSummary)
 	charities.Patch("/milestones/:milestoneId/complete", controllers.CompleteMilestone)
 	charities.Patch("/milestones/:milestoneId/verify", controllers.VerifyMilestone)
 	charities.Post("/milestones/:milestoneId/release", controllers.ReleaseMilestoneFunds)
+
+	// Milestone evidence routes
+	charities.Get("/milestones/:milestoneId/evidence", controllers.GetMilestoneEvidence)
+	charities.Post("/milestones/:milestoneId/evidence", controllers.UploadMilestoneEvidence)
+	charities.Get("/milestones/:milestoneId/evidence/:evidenceId", controllers.DownloadMilestoneEvidence)
 }
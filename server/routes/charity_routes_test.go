@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"fmt"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+)
+
+// lastApproval returns the newest approval of a kind for charity
+func (s *testServer) lastApproval(charity models.Charity, kind string) models.TransactionApproval {
+	s.t.Helper()
+	var approval models.TransactionApproval
+	if err := config.DB.Where("charity_id = ? AND kind = ?", charity.ID, kind).Last(&approval).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return approval
+}
+
+func TestWalletChangesNeedCosignersOnceMultiSig(t *testing.T) {
+	s := newTestServer(t)
+	owner, ownerToken := s.user("owner", "")
+	charity, wallet := s.charity(owner)
+	firstUser, firstToken := s.user("first", "")
+	first := s.cosigner(charity, firstUser)
+	secondUser, _ := s.user("second", "")
+	second := s.cosigner(charity, secondUser)
+
+	// The single-signer wallet applies the new thresholds with its own key right away
+	status, body := s.do("PATCH", fmt.Sprintf("/api/charities/%d/multisig", charity.ID), ownerToken, map[string]interface{}{
+		"isMultiSig":         true,
+		"requiredSignatures": 2,
+	})
+	if status != 200 || body["message"] != nil {
+		t.Fatalf("enable multisig: status %d, %v", status, body)
+	}
+	signers := s.lastApproval(charity, models.ApprovalKindSigners)
+	config.DB.First(&charity, charity.ID)
+	if signers.Status != models.ApprovalStatusExecuted || charity.ThresholdVersion != 1 || charity.SignerSyncTxHash != signers.TxHash {
+		t.Fatalf("signer change %s, threshold version %d, sync tx %s", signers.Status, charity.ThresholdVersion, charity.SignerSyncTxHash)
+	}
+	auth, err := s.horizon.AccountAuth(wallet.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Signers[wallet.Address()] != 1 || auth.Signers[first.Address()] != 1 || auth.Signers[second.Address()] != 1 || auth.HighThreshold != 2 {
+		t.Fatalf("wallet signers %v, high threshold %d", auth.Signers, auth.HighThreshold)
+	}
+
+	// A trustline now waits for a cosigner before the asset is accepted
+	usdc := map[string]interface{}{"assetCode": "USDC", "assetIssuer": keypair.MustRandom().Address()}
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/%d/assets", charity.ID), ownerToken, usdc); status != 202 {
+		t.Fatalf("add asset: status %d, %v", status, body)
+	}
+	if status, _ := s.do("POST", fmt.Sprintf("/api/charities/%d/assets", charity.ID), ownerToken, usdc); status != 409 {
+		t.Fatalf("add asset twice: status %d", status)
+	}
+	trustline := s.lastApproval(charity, models.ApprovalKindTrustline)
+	if trustline.Status != models.ApprovalStatusPending || trustline.CurrentSignatures != 1 || trustline.RequiredSignatures != 2 {
+		t.Fatalf("trustline %s with %d of %d signatures", trustline.Status, trustline.CurrentSignatures, trustline.RequiredSignatures)
+	}
+	signAndExecute := func(approval models.TransactionApproval) {
+		t.Helper()
+		signature := signEnvelope(t, approval.EnvelopeXDR, first)
+		if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/sign", approval.ID), firstToken, map[string]interface{}{"signature": signature}); status != 200 {
+			t.Fatalf("cosigner signature: status %d, %v", status, body)
+		}
+		if status, body := s.do("POST", fmt.Sprintf("/api/charities/approvals/%d/execute", approval.ID), ownerToken, nil); status != 200 {
+			t.Fatalf("execute: status %d, %v", status, body)
+		}
+	}
+	signAndExecute(trustline)
+	var accepted int64
+	config.DB.Model(&models.CharityAsset{}).Where("charity_id = ? AND asset_code = ?", charity.ID, "USDC").Count(&accepted)
+	if accepted != 1 {
+		t.Fatal("asset not accepted after the trustline was executed")
+	}
+
+	// Removing a cosigner is saved at once, its key leaves the wallet once a cosigner signs
+	var secondCosigner models.Cosigner
+	config.DB.Where("public_key = ?", second.Address()).First(&secondCosigner)
+	if status, body := s.do("DELETE", fmt.Sprintf("/api/charities/%d/cosigners/%d", charity.ID, secondCosigner.ID), ownerToken, nil); status != 200 {
+		t.Fatalf("remove cosigner: status %d, %v", status, body)
+	}
+	removal := s.lastApproval(charity, models.ApprovalKindSigners)
+	if removal.ID == signers.ID || removal.Status != models.ApprovalStatusPending || removal.SignerSet != first.Address() {
+		t.Fatalf("signer change %s for %q", removal.Status, removal.SignerSet)
+	}
+	if auth, _ := s.horizon.AccountAuth(wallet.Address()); auth.Signers[second.Address()] != 1 {
+		t.Fatal("cosigner key removed from the wallet without a cosigner signature")
+	}
+	signAndExecute(removal)
+
+	auth, err = s.horizon.AccountAuth(wallet.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB.First(&charity, charity.ID)
+	if _, kept := auth.Signers[second.Address()]; kept || charity.SignerSet != first.Address() || charity.ThresholdVersion != 2 {
+		t.Fatalf("wallet signers %v, signer set %q, threshold version %d", auth.Signers, charity.SignerSet, charity.ThresholdVersion)
+	}
+
+	// The wallet is in sync, so there is nothing to propose
+	if status, body := s.do("POST", fmt.Sprintf("/api/charities/%d/multisig/sync", charity.ID), ownerToken, nil); status != 200 || body["data"] != nil {
+		t.Fatalf("sync: status %d, %v", status, body)
+	}
+}
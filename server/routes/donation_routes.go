@@ -2,14 +2,15 @@ package routes
 
 import (
 	"cleargive/server/controllers"
+	"cleargive/server/middleware"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 func SetupDonationRoutes(router fiber.Router) {
-	donations := router.Group("/donations")
+	donations := router.Group("/donations", middleware.AuthMiddleware())
 
-	// Get all donations
+	// Get the donations the user may read
 	donations.Get("/", controllers.GetDonations)
 
 	// Get single donation
@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"fmt"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+)
+
+func TestClaimIngestedDonationRequiresSendingWallet(t *testing.T) {
+	s := newTestServer(t)
+
+	owner, _ := s.user("owner", "")
+	charity, _ := s.charity(owner)
+
+	sender := keypair.MustRandom().Address()
+	donor, donorToken := s.user("donor", "")
+	config.DB.Model(&donor).Update("public_key", sender)
+	other, otherToken := s.user("other", "")
+	config.DB.Model(&other).Update("public_key", keypair.MustRandom().Address())
+
+	// The ledger watcher recorded the payment without a donor
+	payment := s.horizon.AddPayment(services.Payment{
+		TxHash:     "ingested",
+		Successful: true,
+		From:       sender,
+		To:         charity.WalletAddress,
+		Amount:     "10.0000000",
+		AssetCode:  services.NativeAssetCode,
+	})
+	ingested := models.Donation{
+		Amount:        100_000_000,
+		Asset:         models.NativeAsset(),
+		CharityID:     charity.ID,
+		TxHash:        payment.TxHash,
+		PaymentID:     payment.ID,
+		Status:        services.DonationStatusConfirmed,
+		SourceAccount: sender,
+	}
+	if err := config.DB.Create(&ingested).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	claim := map[string]interface{}{"charityId": charity.ID, "txHash": "ingested", "amount": "10", "message": "Mine"}
+
+	if status, body := s.do("POST", "/api/donations", otherToken, claim); status != 422 || body["code"] != services.VerificationSenderMismatch {
+		t.Fatalf("claim from another wallet: status %d, %v", status, body)
+	}
+	config.DB.First(&ingested, ingested.ID)
+	if ingested.DonorID != "" {
+		t.Fatalf("donation was claimed by %q", ingested.DonorID)
+	}
+
+	if status, body := s.do("POST", "/api/donations", donorToken, claim); status != 200 {
+		t.Fatalf("claim from the sending wallet: status %d, %v", status, body)
+	}
+	config.DB.First(&ingested, ingested.ID)
+	if ingested.DonorID != donor.FirebaseID || ingested.Amount != 100_000_000 {
+		t.Fatalf("claimed donation: donor %q, amount %s", ingested.DonorID, ingested.Amount)
+	}
+}
+
+func TestDonationTotalsAreKeyedByBudgetCategory(t *testing.T) {
+	s := newTestServer(t)
+
+	owner, _ := s.user("owner", "")
+	charity, _ := s.charity(owner)
+	other, _ := s.charity(models.User{FirebaseID: "other"})
+	donor, donorToken := s.user("donor", "")
+	wallet := keypair.MustRandom().Address()
+	config.DB.Model(&donor).Update("public_key", wallet)
+
+	food := models.BudgetCategory{CharityID: charity.ID, Name: "Food"}
+	foreign := models.BudgetCategory{CharityID: other.ID, Name: "Food"}
+	config.DB.Create(&food)
+	config.DB.Create(&foreign)
+
+	donate := func(categoryID uint) (int, map[string]interface{}) {
+		payment := s.horizon.AddPayment(services.Payment{
+			TxHash:     fmt.Sprintf("donation-%d", categoryID),
+			Successful: true,
+			From:       wallet,
+			To:         charity.WalletAddress,
+			Amount:     "10.0000000",
+			AssetCode:  services.NativeAssetCode,
+		})
+		return s.do("POST", "/api/donations", donorToken, map[string]interface{}{
+			"charityId":  charity.ID,
+			"txHash":     payment.TxHash,
+			"amount":     "10",
+			"categoryId": categoryID,
+			"category":   "Anything",
+		})
+	}
+
+	if status, body := donate(foreign.ID); status != 400 {
+		t.Fatalf("donation to another charity's category: status %d, %v", status, body)
+	}
+	if status, body := donate(food.ID); status != 201 {
+		t.Fatalf("donation to a category: status %d, %v", status, body)
+	}
+
+	var donation models.Donation
+	config.DB.Where("charity_id = ?", charity.ID).First(&donation)
+	if donation.CategoryID != food.ID || donation.Category != "Food" {
+		t.Fatalf("donation category: %d %q", donation.CategoryID, donation.Category)
+	}
+
+	totals := func() map[uint]int64 {
+		var rows []models.CharityTotal
+		config.DB.Where("charity_id = ?", charity.ID).Find(&rows)
+		byCategory := make(map[uint]int64)
+		for _, row := range rows {
+			byCategory[row.CategoryID] += row.Stroops
+		}
+		return byCategory
+	}
+	if got := totals(); got[food.ID] != 100_000_000 || len(got) != 1 {
+		t.Fatalf("totals after donating: %v", got)
+	}
+
+	// Moving the donation out of the category moves its total
+	if status, body := s.do("PUT", fmt.Sprintf("/api/donations/%d", donation.ID), donorToken, map[string]interface{}{"categoryId": 0}); status != 200 {
+		t.Fatalf("clear category: status %d, %v", status, body)
+	}
+	if got := totals(); got[0] != 100_000_000 || got[food.ID] != 0 {
+		t.Fatalf("totals after clearing the category: %v", got)
+	}
+}
+
+func TestCreateDonationRejectsUnverifiedPayment(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.user("owner", "")
+	charity, _ := s.charity(owner)
+	donor, donorToken := s.user("donor", "")
+	wallet := keypair.MustRandom().Address()
+	config.DB.Model(&donor).Update("public_key", wallet)
+
+	s.horizon.AddPayment(services.Payment{
+		TxHash:     "paid",
+		Successful: true,
+		From:       wallet,
+		To:         charity.WalletAddress,
+		Amount:     "10.0000000",
+		AssetCode:  services.NativeAssetCode,
+	})
+	s.horizon.AddPayment(services.Payment{
+		TxHash:     "paid-by-someone-else",
+		Successful: true,
+		From:       keypair.MustRandom().Address(),
+		To:         charity.WalletAddress,
+		Amount:     "10.0000000",
+		AssetCode:  services.NativeAssetCode,
+	})
+
+	status, body := s.do("POST", "/api/donations", donorToken, map[string]interface{}{"charityId": charity.ID, "txHash": "paid", "amount": "100"})
+	if status != 422 || body["code"] != services.VerificationAmountMismatch {
+		t.Fatalf("overstated donation: status %d, %v", status, body)
+	}
+	status, body = s.do("POST", "/api/donations", donorToken, map[string]interface{}{"charityId": charity.ID, "txHash": "paid-by-someone-else", "amount": "10"})
+	if status != 422 || body["code"] != services.VerificationSenderMismatch {
+		t.Fatalf("donation from another wallet: status %d, %v", status, body)
+	}
+
+	var count int64
+	config.DB.Model(&models.Donation{}).Count(&count)
+	config.DB.First(&charity, charity.ID)
+	if count != 0 || charity.TotalDonations != 0 {
+		t.Fatalf("%d donations recorded, charity total %s", count, charity.TotalDonations)
+	}
+}
@@ -0,0 +1,195 @@
+package routes
+
+import (
+	"bytes"
+	"cleargive/server/config"
+	"cleargive/server/middleware"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"cleargive/server/services/horizontest"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stellar/go/keypair"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testAudience = "cleargive-test"
+	testKeyID    = "test-key"
+	// testMasterKeys is the SECRET_MASTER_KEYS wallet secrets are encrypted with
+	testMasterKeys = "k1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+)
+
+// testServer is the API wired to a temporary database, the fake Horizon and a
+// token verifier that trusts a key pair generated for the test
+type testServer struct {
+	t       *testing.T
+	app     *fiber.App
+	key     *rsa.PrivateKey
+	horizon *horizontest.Horizon
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	t.Setenv("SECRET_MASTER_KEYS", testMasterKeys)
+
+	db, err := config.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.TaxReport{}, &models.TaxReportAsset{}, &models.AuditRecord{}, &models.Certificate{}, &models.ComplianceCheck{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.MigrateDB(db); err != nil {
+		t.Fatal(err)
+	}
+	previousDB := config.DB
+	config.DB = db
+
+	horizon := horizontest.New()
+	previousHorizon := services.Horizon
+	services.Horizon = horizon
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousVerifier := middleware.Verifier
+	middleware.Verifier = &middleware.RS256Verifier{
+		Keys:      middleware.StaticKeys{testKeyID: &key.PublicKey},
+		Issuer:    testIssuer,
+		Audience:  testAudience,
+		ClockSkew: time.Minute,
+	}
+
+	t.Cleanup(func() {
+		config.DB = previousDB
+		services.Horizon = previousHorizon
+		middleware.Verifier = previousVerifier
+	})
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			code := fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				code = e.Code
+			}
+			return c.Status(code).JSON(fiber.Map{"status": "error", "message": err.Error()})
+		},
+	})
+	SetupRoutes(app)
+
+	return &testServer{t: t, app: app, key: key, horizon: horizon}
+}
+
+// token signs an ID token for the Firebase account firebaseID
+func (s *testServer) token(firebaseID, email string) string {
+	s.t.Helper()
+	now := time.Now()
+	return s.signToken(map[string]interface{}{
+		"sub":   firebaseID,
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"email": email,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+}
+
+// signToken signs arbitrary claims with the trusted test key
+func (s *testServer) signToken(claims map[string]interface{}) string {
+	s.t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": testKeyID, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// user creates a user and returns it with a valid token
+func (s *testServer) user(firebaseID, role string) (models.User, string) {
+	s.t.Helper()
+	email := firebaseID + "@example.org"
+	user := models.User{FirebaseID: firebaseID, Email: email, Role: role}
+	if err := config.DB.Create(&user).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return user, s.token(firebaseID, email)
+}
+
+// charity creates a charity owned by owner with a wallet known to the fake Horizon
+func (s *testServer) charity(owner models.User) (models.Charity, *keypair.Full) {
+	s.t.Helper()
+	wallet := keypair.MustRandom()
+	s.horizon.CreateAccount(wallet.Address())
+	charity := models.Charity{
+		Name:          "Charity of " + owner.FirebaseID,
+		WalletAddress: wallet.Address(),
+		OwnerID:       owner.ID,
+	}
+	if err := config.DB.Create(&charity).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	secret, err := services.EncryptSecret(wallet.Seed(), services.SecretRow("charities", charity.ID))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err := config.DB.Model(&charity).Update("wallet_secret", secret).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	return charity, wallet
+}
+
+// do sends a request with an optional bearer token and JSON body and returns the
+// status code and decoded response
+func (s *testServer) do(method, path, token string, body interface{}) (int, map[string]interface{}) {
+	s.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var decoded map[string]interface{}
+	raw, _ := io.ReadAll(resp.Body)
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &decoded)
+	}
+	return resp.StatusCode, decoded
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"cleargive/server/controllers"
+	"cleargive/server/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupNotificationRoutes(router fiber.Router) {
+	notifications := router.Group("/notifications")
+
+	// Protected routes, users only see their own notifications
+	notifications.Use(middleware.AuthMiddleware())
+
+	notifications.Get("/", controllers.GetNotifications)
+	notifications.Patch("/:notificationId/read", controllers.MarkNotificationRead)
+}
@@ -15,4 +15,5 @@ func SetupRoutes(app *fiber.App) {
 	SetupDonationRoutes(api)
 	SetupCertificateRoutes(api)
 	SetupTaxReportingRoutes(api)
+	SetupNotificationRoutes(api)
 }
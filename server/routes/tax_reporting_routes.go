@@ -2,16 +2,18 @@ package routes
 
 import (
 	"cleargive/server/controllers"
+	"cleargive/server/middleware"
+	"cleargive/server/policy"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 func SetupTaxReportingRoutes(router fiber.Router) {
 	// Tax Reports Routes
-	reports := router.Group("/tax-reports")
+	reports := router.Group("/tax-reports", middleware.AuthMiddleware())
 
 	// Get all tax reports for a user
-	reports.Get("/user/:userId", controllers.GetTaxReports)
+	reports.Get("/user/:userId", middleware.RequireSelf("userId", policy.PermDonorsRead), controllers.GetTaxReports)
 
 	// Get a specific tax report
 	reports.Get("/:id", controllers.GetTaxReport)
@@ -20,26 +22,26 @@ func SetupTaxReportingRoutes(router fiber.Router) {
 	reports.Post("/", controllers.GenerateTaxReport)
 
 	// Get donations by year for a user (used for tax reporting)
-	reports.Get("/user/:userId/year/:year", controllers.GetDonationsByYear)
+	reports.Get("/user/:userId/year/:year", middleware.RequireSelf("userId", policy.PermDonorsRead), controllers.GetDonationsByYear)
 
 	// Audit Trail Routes
-	audit := router.Group("/audit")
+	audit := router.Group("/audit", middleware.AuthMiddleware())
 
 	// Get audit trail for a user
-	audit.Get("/user/:userId", controllers.GetAuditTrail)
+	audit.Get("/user/:userId", middleware.RequireSelf("userId", policy.PermAuditRead), controllers.GetAuditTrail)
 
 	// Compliance Routes
-	compliance := router.Group("/compliance")
+	compliance := router.Group("/compliance", middleware.AuthMiddleware())
 
 	// Get compliance checks for a user
-	compliance.Get("/user/:userId", controllers.GetComplianceChecks)
+	compliance.Get("/user/:userId", middleware.RequireSelf("userId", policy.PermComplianceRead), controllers.GetComplianceChecks)
 
 	// Get compliance checks for a charity
-	compliance.Get("/charity/:charityId", controllers.GetCharityComplianceChecks)
+	compliance.Get("/charity/:charityId", middleware.RequireCharityMember("charityId"), controllers.GetCharityComplianceChecks)
 
 	// Run a new compliance check
 	compliance.Post("/", controllers.RunComplianceCheck)
 
 	// Update a compliance check
-	compliance.Put("/:id", controllers.UpdateComplianceCheck)
+	compliance.Put("/:id", middleware.RequirePermission(policy.PermComplianceUpdate), controllers.UpdateComplianceCheck)
 }
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"cleargive/server/controllers"
+	"cleargive/server/middleware"
+	"cleargive/server/policy"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -9,12 +11,15 @@ import (
 func SetupUserRoutes(router fiber.Router) {
 	users := router.Group("/users")
 
-	// Create a new user
-	users.Post("/", controllers.CreateUser)
-	
+	// Create a new user for the Firebase account of the ID token
+	users.Post("/", middleware.TokenMiddleware(), controllers.CreateUser)
+
 	// Get user by Firebase ID
 	users.Get("/:firebase_id", controllers.GetUser)
-	
+
 	// Update user's Stellar wallet
-	users.Put("/:id", controllers.UpdateUser)
-} 
\ No newline at end of file
+	users.Put("/:id", middleware.AuthMiddleware(), controllers.UpdateUser)
+
+	// Assign a role to a user (admins only)
+	users.Put("/:id/role", middleware.AuthMiddleware(), middleware.RequirePermission(policy.PermRolesAssign), controllers.AssignUserRole)
+}
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"testing"
+)
+
+func TestCreateUserTakesEmailFromToken(t *testing.T) {
+	s := newTestServer(t)
+
+	token := s.token("new-user", "owner@example.org")
+	signup := func(email string) (int, map[string]interface{}) {
+		body := map[string]interface{}{"firebase_id": "new-user", "role": "USER"}
+		if email != "" {
+			body["email"] = email
+		}
+		return s.do("POST", "/api/users", token, body)
+	}
+
+	if status, body := signup("someone.else@example.org"); status != 403 {
+		t.Fatalf("signup with another email: status %d, %v", status, body)
+	}
+	if status, body := signup(""); status != 201 {
+		t.Fatalf("signup without email: status %d, %v", status, body)
+	}
+
+	var user models.User
+	config.DB.Where("firebase_id = ?", "new-user").First(&user)
+	if user.Email != "owner@example.org" {
+		t.Fatalf("stored email %q", user.Email)
+	}
+
+	// A token without an email cannot sign up
+	if status, body := s.do("POST", "/api/users", s.token("no-email", ""), map[string]interface{}{"firebase_id": "no-email", "role": "USER", "email": "x@example.org"}); status != 400 {
+		t.Fatalf("signup without token email: status %d, %v", status, body)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAsset is returned for asset codes or issuers the network would reject
+var ErrInvalidAsset = errors.New("asset code must be 1-12 letters or digits and non-native assets need a valid issuer")
+
+var assetCodePattern = regexp.MustCompile(`^[a-zA-Z0-9]{1,12}$`)
+
+// StellarAsset converts an asset to its txnbuild form, validating code and issuer
+func StellarAsset(asset models.Asset) (txnbuild.Asset, error) {
+	asset = asset.Normalized()
+	if asset.IsNative() {
+		return txnbuild.NativeAsset{}, nil
+	}
+	if !assetCodePattern.MatchString(asset.AssetCode) {
+		return nil, ErrInvalidAsset
+	}
+	if _, err := keypair.ParseAddress(asset.AssetIssuer); err != nil {
+		return nil, ErrInvalidAsset
+	}
+	return txnbuild.CreditAsset{Code: asset.AssetCode, Issuer: asset.AssetIssuer}, nil
+}
+
+// BuildTrustline builds the transaction that adds a trustline for asset to the account
+// at address so it can receive the asset, valid for timeout seconds. The returned
+// transaction is unsigned.
+func BuildTrustline(address string, asset models.Asset, timeout int64) (*txnbuild.Transaction, error) {
+	stellarAsset, err := StellarAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+	if stellarAsset.IsNative() {
+		return nil, errors.New("lumens do not need a trustline")
+	}
+	changeTrustAsset, err := stellarAsset.ToChangeTrustAsset()
+	if err != nil {
+		return nil, err
+	}
+
+	sequence, err := Horizon.AccountSequence(address)
+	if err != nil {
+		return nil, err
+	}
+	account := txnbuild.NewSimpleAccount(address, sequence)
+
+	return txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount:        &account,
+		IncrementSequenceNum: true,
+		Operations: []txnbuild.Operation{
+			&txnbuild.ChangeTrust{Line: changeTrustAsset, Limit: txnbuild.MaxTrustlineLimit},
+		},
+		BaseFee:       txnbuild.MinBaseFee,
+		Preconditions: txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(timeout)},
+	})
+}
+
+// CharityAcceptsAsset reports whether a charity accepts donations and payments in asset.
+// Lumens are always accepted, other assets need a CharityAsset row.
+func CharityAcceptsAsset(db *gorm.DB, charityID uint, asset models.Asset) (bool, error) {
+	asset = asset.Normalized()
+	if asset.IsNative() {
+		return true, nil
+	}
+
+	var count int64
+	err := db.Model(&models.CharityAsset{}).
+		Where("charity_id = ? AND asset_code = ? AND asset_issuer = ?", charityID, asset.AssetCode, asset.AssetIssuer).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("could not load accepted assets: %w", err)
+	}
+	return count > 0, nil
+}
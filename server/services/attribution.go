@@ -0,0 +1,179 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrUnknownAttributionMethod is returned for attribution methods other than fifo and pro_rata
+var ErrUnknownAttributionMethod = errors.New(`attribution method must be "fifo" or "pro_rata"`)
+
+// RefundShare is a donation's part of an approval's unspent funds
+type RefundShare struct {
+	Donation models.Donation
+	Amount   models.Money
+}
+
+// AttributeFunds links an executed approval to the confirmed donations that paid
+// for it inside tx. Donations are drawn in the approval's asset until the approval
+// amount is covered; any amount the donations cannot cover is left unattributed.
+func AttributeFunds(tx *gorm.DB, approval *models.TransactionApproval, method string) ([]models.FundAttribution, error) {
+	if method == "" {
+		method = models.AttributionFIFO
+	}
+	if method != models.AttributionFIFO && method != models.AttributionProRata {
+		return nil, ErrUnknownAttributionMethod
+	}
+
+	var donations []models.Donation
+	err := tx.Where("charity_id = ? AND status = ?", approval.CharityID, DonationStatusConfirmed).
+		Order("created_at, id").Find(&donations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// What each donation in the approval's asset has left after earlier approvals
+	asset := approval.Asset.Normalized()
+	var funding []models.Donation
+	var ids []uint
+	for _, donation := range donations {
+		if donation.Asset.Normalized() == asset {
+			funding = append(funding, donation)
+			ids = append(ids, donation.ID)
+		}
+	}
+	if len(funding) == 0 {
+		return nil, nil
+	}
+
+	var earlier []models.FundAttribution
+	if err := tx.Where("donation_id IN ?", ids).Find(&earlier).Error; err != nil {
+		return nil, err
+	}
+	spent := make(map[uint]models.Money)
+	for _, attribution := range earlier {
+		spent[attribution.DonationID] += attribution.Amount
+	}
+
+	available := make([]models.Money, len(funding))
+	var total models.Money
+	for i, donation := range funding {
+		if left := donation.Amount - spent[donation.ID]; left > 0 {
+			available[i] = left
+			total += left
+		}
+	}
+
+	var amounts []models.Money
+	if method == models.AttributionProRata && total > approval.Amount {
+		amounts = splitProRata(approval.Amount, available, total)
+	} else {
+		amounts = make([]models.Money, len(funding))
+		need := approval.Amount
+		for i := range funding {
+			amounts[i] = min(available[i], need)
+			need -= amounts[i]
+		}
+	}
+
+	var attributions []models.FundAttribution
+	for i, donation := range funding {
+		if amounts[i] > 0 {
+			attributions = append(attributions, models.FundAttribution{
+				ApprovalID: approval.ID,
+				DonationID: donation.ID,
+				Amount:     amounts[i],
+			})
+		}
+	}
+	if len(attributions) == 0 {
+		return nil, nil
+	}
+	if err := tx.Create(&attributions).Error; err != nil {
+		return nil, err
+	}
+	return attributions, nil
+}
+
+// RefundShares splits refundAmount between the donations that paid for an approval
+// in proportion to what each paid. The part of the approval no donation paid for
+// stays with the charity, so at most the attributed amount is refunded.
+func RefundShares(db *gorm.DB, approval *models.TransactionApproval, refundAmount models.Money) ([]RefundShare, error) {
+	var attributions []models.FundAttribution
+	if err := db.Preload("Donation").Where("approval_id = ?", approval.ID).Order("id").Find(&attributions).Error; err != nil {
+		return nil, err
+	}
+
+	weights := make([]models.Money, len(attributions))
+	var attributed models.Money
+	for i, attribution := range attributions {
+		weights[i] = attribution.Amount
+		attributed += attribution.Amount
+	}
+	if !attributed.IsPositive() {
+		return nil, nil
+	}
+
+	amounts := splitProRata(min(refundAmount, attributed), weights, attributed)
+
+	var shares []RefundShare
+	for i, attribution := range attributions {
+		if amounts[i] > 0 {
+			shares = append(shares, RefundShare{Donation: attribution.Donation, Amount: amounts[i]})
+		}
+	}
+	return shares, nil
+}
+
+// splitProRata splits amount in proportion to weights, which add up to total.
+// Shares are rounded down to the stroop and the stroops left over go to the
+// first weights so the shares add up to amount exactly.
+func splitProRata(amount models.Money, weights []models.Money, total models.Money) []models.Money {
+	shares := make([]models.Money, len(weights))
+	var assigned models.Money
+	for i, weight := range weights {
+		share := new(big.Int).Mul(big.NewInt(int64(amount)), big.NewInt(int64(weight)))
+		share.Quo(share, big.NewInt(int64(total)))
+		shares[i] = models.Money(share.Int64())
+		assigned += shares[i]
+	}
+	for i := 0; assigned < amount && i < len(shares); i++ {
+		if shares[i] < weights[i] {
+			shares[i]++
+			assigned++
+		}
+	}
+	return shares
+}
+
+// ApplyRefundToTaxReport lowers the deductible total of the tax report covering the
+// refunded donation's year, if the donor already generated it. Reports generated
+// later subtract the refund themselves.
+func ApplyRefundToTaxReport(tx *gorm.DB, refund *models.Refund, donatedAt time.Time) error {
+	var report models.TaxReport
+	err := tx.Preload("Assets").Where("user_id = ? AND year = ?", refund.DonorID, donatedAt.Year()).Limit(1).Find(&report).Error
+	if err != nil || report.ID == 0 {
+		return err
+	}
+
+	asset := refund.Asset.Normalized()
+	if asset.IsNative() {
+		if err := tx.Model(&report).Update("total_donations", report.TotalDonations-refund.Amount).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, reportAsset := range report.Assets {
+		if reportAsset.Asset.Normalized() == asset {
+			return tx.Model(&reportAsset).Updates(map[string]interface{}{
+				"total_donations": reportAsset.TotalDonations - refund.Amount,
+				"total_refunded":  reportAsset.TotalRefunded + refund.Amount,
+			}).Error
+		}
+	}
+	return nil
+}
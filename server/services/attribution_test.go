@@ -0,0 +1,91 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestSplitProRata(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		amount  models.Money
+		weights []models.Money
+		want    []models.Money
+	}{
+		{"exact shares", 70, []models.Money{20, 50}, []models.Money{20, 50}},
+		{"leftover stroop goes to the first weight", 10, []models.Money{10, 10, 10}, []models.Money{4, 3, 3}},
+		{"single stroop", 1, []models.Money{5, 5, 5}, []models.Money{1, 0, 0}},
+		{"share never exceeds its weight", 3, []models.Money{1, 5}, []models.Money{1, 2}},
+		{"nothing to split", 0, []models.Money{3, 4}, []models.Money{0, 0}},
+		{"1 XLM from three donations of 1 XLM", 10_000_000, []models.Money{10_000_000, 10_000_000, 10_000_000}, []models.Money{3_333_334, 3_333_333, 3_333_333}},
+		// amount * weight overflows int64
+		{"large amounts", 600_000_000_000_000_000, []models.Money{300_000_000_000_000_000, 600_000_000_000_000_000}, []models.Money{200_000_000_000_000_000, 400_000_000_000_000_000}},
+	} {
+		var total models.Money
+		for _, weight := range test.weights {
+			total += weight
+		}
+		got := splitProRata(test.amount, test.weights, total)
+
+		var sum models.Money
+		for _, share := range got {
+			sum += share
+		}
+		if !reflect.DeepEqual(got, test.want) || sum != test.amount {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRefundShares(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "attribution.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Donation{}, &models.FundAttribution{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The approval paid 400 stroops, 300 of them from two donations
+	approval := models.TransactionApproval{Amount: 400}
+	approval.ID = 1
+	first := models.Donation{Amount: 100, TxHash: "a"}
+	second := models.Donation{Amount: 500, TxHash: "b"}
+	db.Create(&first)
+	db.Create(&second)
+	db.Create(&models.FundAttribution{ApprovalID: approval.ID, DonationID: first.ID, Amount: 100})
+	db.Create(&models.FundAttribution{ApprovalID: approval.ID, DonationID: second.ID, Amount: 200})
+
+	for _, test := range []struct {
+		name   string
+		refund models.Money
+		want   map[uint]models.Money
+	}{
+		{"in proportion", 150, map[uint]models.Money{first.ID: 50, second.ID: 100}},
+		{"rounded to the stroop", 100, map[uint]models.Money{first.ID: 34, second.ID: 66}},
+		{"single stroop", 1, map[uint]models.Money{first.ID: 1}},
+		{"capped at the attributed amount", 400, map[uint]models.Money{first.ID: 100, second.ID: 200}},
+	} {
+		shares, err := RefundShares(db, &approval, test.refund)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got := make(map[uint]models.Money)
+		for _, share := range shares {
+			got[share.Donation.ID] = share.Amount
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.want)
+		}
+	}
+
+	// An approval no donation paid for refunds nothing
+	if shares, err := RefundShares(db, &models.TransactionApproval{Model: gorm.Model{ID: 2}}, 100); err != nil || shares != nil {
+		t.Errorf("unattributed approval: %v, %v", shares, err)
+	}
+}
@@ -0,0 +1,517 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// allocationTolerance absorbs float rounding when allocations are added up, e.g. 33.3 + 33.3 + 33.4
+const allocationTolerance = 1e-9
+
+// monthFormat is the layout of the months fiscal periods start and end in
+const monthFormat = "2006-01"
+
+var (
+	// ErrAllocationRange is returned for allocations outside 0 to 100 percent
+	ErrAllocationRange = errors.New("allocation must be between 0 and 100 percent")
+	// ErrNoOpenPeriod is returned when a charity has no open fiscal period for the current month
+	ErrNoOpenPeriod = errors.New("charity has no open fiscal period for the current month")
+	// ErrPeriodClosed is returned when the budget of a closed fiscal period would change
+	ErrPeriodClosed = errors.New("fiscal period is closed")
+	// ErrPeriodNotCurrent is returned when spending from a category whose period does not include the current month
+	ErrPeriodNotCurrent = errors.New("budget category belongs to a fiscal period that does not include the current month")
+	// ErrPeriodOverlap is returned when a fiscal period would share months with another period of the charity
+	ErrPeriodOverlap = errors.New("fiscal period overlaps another period of the charity")
+	// ErrInvalidPeriod is returned for fiscal periods that do not run from one month to a later one
+	ErrInvalidPeriod = errors.New("fiscal period must run from a start month to an end month formatted as YYYY-MM")
+	// ErrCategoryDeleted is returned when spending from a budget category that no longer exists
+	ErrCategoryDeleted = errors.New("budget category no longer exists")
+	// ErrVersionNotFound is returned for a budget version a fiscal period does not have
+	ErrVersionNotFound = errors.New("budget version not found")
+)
+
+// AllocationError is returned when the budget categories of a fiscal period would add up to more than 100%
+type AllocationError struct {
+	Allocated float64 // Percentage held by the charity's other categories
+}
+
+func (e *AllocationError) Error() string {
+	return fmt.Sprintf("budget categories cannot be allocated more than 100%% of a period, other categories already hold %g%%", e.Allocated)
+}
+
+// OverspendError is returned when a payment is larger than what its budget category has left
+type OverspendError struct {
+	Category  string
+	Asset     models.Asset
+	Amount    models.Money
+	Available models.Money // Negative once the category is overspent
+}
+
+func (e *OverspendError) Error() string {
+	return fmt.Sprintf("%s %s exceeds the %s %s left in budget category %q", e.Amount, e.Asset.AssetCode, e.Available, e.Asset.AssetCode, e.Category)
+}
+
+// CheckAllocation checks that a budget category of a fiscal period can be allocated
+// allocation percent. categoryID is the category being updated, 0 for a new one.
+func CheckAllocation(tx *gorm.DB, periodID, categoryID uint, allocation float64) error {
+	if math.IsNaN(allocation) || allocation < 0 || allocation > 100 {
+		return ErrAllocationRange
+	}
+
+	var allocated float64
+	err := tx.Model(&models.BudgetCategory{}).
+		Where("period_id = ? AND id <> ?", periodID, categoryID).
+		Select("COALESCE(SUM(allocation), 0)").Scan(&allocated).Error
+	if err != nil {
+		return err
+	}
+	if allocated+allocation > 100+allocationTolerance {
+		return &AllocationError{Allocated: allocated}
+	}
+	return nil
+}
+
+// CategoryBudget returns allocation percent of total, rounded down to the stroop
+func CategoryBudget(total models.Money, allocation float64) models.Money {
+	if total <= 0 || allocation <= 0 {
+		return 0
+	}
+
+	// Go through the shortest decimal form so 33.3 is 333/10, not its binary approximation
+	share, ok := new(big.Rat).SetString(strconv.FormatFloat(allocation, 'f', -1, 64))
+	if !ok {
+		return 0
+	}
+	share.Mul(share, new(big.Rat).SetInt64(int64(total)))
+	share.Quo(share, big.NewRat(100, 1))
+	return models.Money(new(big.Int).Quo(share.Num(), share.Denom()).Int64())
+}
+
+// CheckSpend checks that amount can be paid from a budget category now. The
+// category's fiscal period has to be open and include the current month, and the
+// payment cannot spend more than the category has left, otherwise an OverspendError
+// is returned. Lumen payments are limited by the category's share of the period's
+// budget, less what it has spent and the lumen payments still being submitted. Other assets have no planned budget, so their payments are limited by the
+// category's share of the asset donated during the period, less what the category
+// has already paid out in the asset. Payments without a category are not checked,
+// and payments from a category deleted since they were requested are refused with
+// ErrCategoryDeleted.
+func CheckSpend(tx *gorm.DB, charity *models.Charity, categoryID uint, amount models.Money, asset models.Asset) error {
+	if categoryID == 0 {
+		return nil
+	}
+
+	var category models.BudgetCategory
+	if err := tx.Where("charity_id = ?", charity.ID).Limit(1).Find(&category, categoryID).Error; err != nil {
+		return err
+	}
+	if category.ID == 0 {
+		return ErrCategoryDeleted
+	}
+
+	var period models.FiscalPeriod
+	if err := tx.First(&period, category.PeriodID).Error; err != nil {
+		return err
+	}
+	if period.Status != models.FiscalPeriodOpen {
+		return ErrPeriodClosed
+	}
+	if !period.Contains(time.Now().UTC().Format(monthFormat)) {
+		return ErrPeriodNotCurrent
+	}
+
+	// Executed lumen payments are in Spent, those still being submitted are not yet
+	executing, err := approvalsPaid(tx, category.ID, asset, models.ApprovalStatusExecuting)
+	if err != nil {
+		return err
+	}
+	available := CategoryBudget(period.Budget, category.Allocation) - category.Spent - executing
+	if !asset.IsNative() {
+		if available, err = assetAvailable(tx, &period, &category, asset); err != nil {
+			return err
+		}
+	}
+	if amount > available {
+		return &OverspendError{Category: category.Name, Asset: asset, Amount: amount, Available: available}
+	}
+	return nil
+}
+
+// assetAvailable returns what a budget category has left to spend in a non-native
+// asset: its share of the asset donated during the period, less the payments in the
+// asset it has made or is still submitting
+func assetAvailable(tx *gorm.DB, period *models.FiscalPeriod, category *models.BudgetCategory, asset models.Asset) (models.Money, error) {
+	var donated int64
+	err := tx.Model(&models.CharityTotal{}).
+		Where("charity_id = ? AND asset_code = ? AND asset_issuer = ? AND month >= ? AND month <= ?",
+			period.CharityID, asset.AssetCode, asset.AssetIssuer, period.StartMonth, period.EndMonth).
+		Select("COALESCE(SUM(stroops), 0)").Scan(&donated).Error
+	if err != nil {
+		return 0, err
+	}
+
+	// Refunded approvals were paid out before their unspent funds were returned
+	paid, err := approvalsPaid(tx, category.ID, asset,
+		models.ApprovalStatusExecuting, models.ApprovalStatusExecuted, models.ApprovalStatusRefunding, models.ApprovalStatusRefunded)
+	if err != nil {
+		return 0, err
+	}
+	return CategoryBudget(models.Money(donated), category.Allocation) - paid, nil
+}
+
+// approvalsPaid adds up the amounts of a budget category's approvals in asset that
+// are in one of statuses. Amounts are decimal strings, so they are added up here
+// rather than in SQL.
+func approvalsPaid(tx *gorm.DB, categoryID uint, asset models.Asset, statuses ...string) (models.Money, error) {
+	var payments []models.TransactionApproval
+	err := tx.Select("amount").
+		Where("category_id = ? AND asset_code = ? AND asset_issuer = ? AND status IN ?", categoryID, asset.AssetCode, asset.AssetIssuer, statuses).
+		Find(&payments).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var paid models.Money
+	for _, payment := range payments {
+		paid += payment.Amount
+	}
+	return paid, nil
+}
+
+// ParsePeriodMonths validates the start and end month (YYYY-MM) of a fiscal period
+func ParsePeriodMonths(start, end string) (time.Time, time.Time, error) {
+	startMonth, err := time.Parse(monthFormat, start)
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidPeriod
+	}
+	endMonth, err := time.Parse(monthFormat, end)
+	if err != nil || endMonth.Before(startMonth) {
+		return time.Time{}, time.Time{}, ErrInvalidPeriod
+	}
+	return startMonth, endMonth, nil
+}
+
+// CheckPeriodOverlap returns ErrPeriodOverlap when the months from start through
+// end overlap another fiscal period of the charity
+func CheckPeriodOverlap(tx *gorm.DB, charityID uint, start, end string) error {
+	var count int64
+	err := tx.Model(&models.FiscalPeriod{}).
+		Where("charity_id = ? AND start_month <= ? AND end_month >= ?", charityID, end, start).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPeriodOverlap
+	}
+	return nil
+}
+
+// CurrentFiscalPeriod returns the open fiscal period of a charity that includes the current month
+func CurrentFiscalPeriod(tx *gorm.DB, charityID uint) (*models.FiscalPeriod, error) {
+	month := time.Now().UTC().Format(monthFormat)
+
+	var period models.FiscalPeriod
+	err := tx.Where("charity_id = ? AND status = ? AND start_month <= ? AND end_month >= ?", charityID, models.FiscalPeriodOpen, month, month).
+		Limit(1).Find(&period).Error
+	if err != nil {
+		return nil, err
+	}
+	if period.ID == 0 {
+		return nil, ErrNoOpenPeriod
+	}
+	return &period, nil
+}
+
+// RecordBudgetVersion snapshots a fiscal period's budget and category allocations
+// as its next version inside tx
+func RecordBudgetVersion(tx *gorm.DB, period *models.FiscalPeriod, createdByID, note string) (*models.BudgetVersion, error) {
+	var latest int
+	err := tx.Model(&models.BudgetVersion{}).Where("period_id = ?", period.ID).
+		Select("COALESCE(MAX(version), 0)").Scan(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []models.BudgetCategory
+	if err := tx.Where("period_id = ?", period.ID).Order("id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	version := models.BudgetVersion{
+		PeriodID:    period.ID,
+		Version:     latest + 1,
+		Budget:      period.Budget,
+		Note:        note,
+		CreatedByID: createdByID,
+	}
+	for _, category := range categories {
+		version.Lines = append(version.Lines, models.BudgetVersionLine{
+			CategoryID: category.ID,
+			Name:       category.Name,
+			Allocation: category.Allocation,
+		})
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// RollForward creates the fiscal period that follows period, with the same length
+// and budget, and copies period's categories into it with nothing spent
+func RollForward(tx *gorm.DB, period *models.FiscalPeriod, name, createdByID string) (*models.FiscalPeriod, error) {
+	start, end, err := ParsePeriodMonths(period.StartMonth, period.EndMonth)
+	if err != nil {
+		return nil, err
+	}
+	months := (end.Year()-start.Year())*12 + int(end.Month()-start.Month()) + 1
+	nextStart := end.AddDate(0, 1, 0)
+	nextEnd := nextStart.AddDate(0, months-1, 0)
+
+	next := models.FiscalPeriod{
+		CharityID:  period.CharityID,
+		Name:       name,
+		StartMonth: nextStart.Format(monthFormat),
+		EndMonth:   nextEnd.Format(monthFormat),
+		Budget:     period.Budget,
+		Status:     models.FiscalPeriodOpen,
+	}
+	if next.Name == "" {
+		next.Name = next.StartMonth + " to " + next.EndMonth
+	}
+	if err := CheckPeriodOverlap(tx, period.CharityID, next.StartMonth, next.EndMonth); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&next).Error; err != nil {
+		return nil, err
+	}
+
+	var categories []models.BudgetCategory
+	if err := tx.Where("period_id = ?", period.ID).Order("id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		copied := models.BudgetCategory{
+			CharityID:  category.CharityID,
+			PeriodID:   next.ID,
+			Name:       category.Name,
+			Allocation: category.Allocation,
+		}
+		if err := tx.Create(&copied).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := RecordBudgetVersion(tx, &next, createdByID, "Rolled forward from "+period.Name); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// Budget version comparison statuses
+const (
+	VersionLineAdded     = "added"
+	VersionLineRemoved   = "removed"
+	VersionLineChanged   = "changed"
+	VersionLineUnchanged = "unchanged"
+)
+
+// VersionLineChange is how one category's allocation differs between two budget versions
+type VersionLineChange struct {
+	CategoryID     uint         `json:"categoryId"`
+	Name           string       `json:"name"` // Name in the later version, or the earlier one when removed
+	FromAllocation float64      `json:"fromAllocation"`
+	ToAllocation   float64      `json:"toAllocation"`
+	FromBudget     models.Money `json:"fromBudget"`
+	ToBudget       models.Money `json:"toBudget"`
+	Status         string       `json:"status"` // added, removed, changed or unchanged
+}
+
+// VersionComparison lists the differences between two budget versions of a fiscal period
+type VersionComparison struct {
+	From       int                 `json:"from"`
+	To         int                 `json:"to"`
+	FromBudget models.Money        `json:"fromBudget"`
+	ToBudget   models.Money        `json:"toBudget"`
+	Categories []VersionLineChange `json:"categories"`
+}
+
+// CompareBudgetVersions compares version from with version to of a fiscal period.
+// Categories are matched by ID, so a renamed category shows as changed.
+func CompareBudgetVersions(db *gorm.DB, periodID uint, from, to int) (*VersionComparison, error) {
+	var versions []models.BudgetVersion
+	err := db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("period_id = ? AND version IN ?", periodID, []int{from, to}).
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var fromVersion, toVersion *models.BudgetVersion
+	for i := range versions {
+		if versions[i].Version == from {
+			fromVersion = &versions[i]
+		}
+		if versions[i].Version == to {
+			toVersion = &versions[i]
+		}
+	}
+	if fromVersion == nil || toVersion == nil {
+		return nil, ErrVersionNotFound
+	}
+
+	comparison := &VersionComparison{
+		From:       from,
+		To:         to,
+		FromBudget: fromVersion.Budget,
+		ToBudget:   toVersion.Budget,
+		Categories: []VersionLineChange{},
+	}
+
+	earlier := make(map[uint]models.BudgetVersionLine, len(fromVersion.Lines))
+	for _, line := range fromVersion.Lines {
+		earlier[line.CategoryID] = line
+	}
+	for _, line := range toVersion.Lines {
+		change := VersionLineChange{
+			CategoryID:   line.CategoryID,
+			Name:         line.Name,
+			ToAllocation: line.Allocation,
+			ToBudget:     CategoryBudget(toVersion.Budget, line.Allocation),
+			Status:       VersionLineAdded,
+		}
+		if previous, ok := earlier[line.CategoryID]; ok {
+			delete(earlier, line.CategoryID)
+			change.FromAllocation = previous.Allocation
+			change.FromBudget = CategoryBudget(fromVersion.Budget, previous.Allocation)
+			change.Status = VersionLineUnchanged
+			if previous.Name != line.Name || previous.Allocation != line.Allocation || change.FromBudget != change.ToBudget {
+				change.Status = VersionLineChanged
+			}
+		}
+		comparison.Categories = append(comparison.Categories, change)
+	}
+	for _, line := range fromVersion.Lines {
+		if _, ok := earlier[line.CategoryID]; !ok {
+			continue
+		}
+		comparison.Categories = append(comparison.Categories, VersionLineChange{
+			CategoryID:     line.CategoryID,
+			Name:           line.Name,
+			FromAllocation: line.Allocation,
+			FromBudget:     CategoryBudget(fromVersion.Budget, line.Allocation),
+			Status:         VersionLineRemoved,
+		})
+	}
+
+	return comparison, nil
+}
+
+// BudgetLine compares a budget category's budget with its spending
+type BudgetLine struct {
+	CategoryID uint         `json:"categoryId"`
+	Name       string       `json:"name"`
+	Allocation float64      `json:"allocation"`
+	Budget     models.Money `json:"budget"`    // Allocation of the period's budget
+	Actual     models.Money `json:"actual"`    // Lumens paid out in the period
+	Variance   models.Money `json:"variance"`  // Budget minus actual, negative when overspent
+	Approvals  int          `json:"approvals"` // Executed approvals counted in Actual
+}
+
+// BudgetReport compares a fiscal period's budget with what its categories spent
+type BudgetReport struct {
+	CharityID  uint         `json:"charityId"`
+	PeriodID   uint         `json:"periodId"`
+	Period     string       `json:"period"`
+	From       string       `json:"from"` // YYYY-MM in UTC
+	To         string       `json:"to"`   // YYYY-MM in UTC, inclusive
+	Status     string       `json:"status"`
+	Budget     models.Money `json:"budget"`    // Lumens planned for the period
+	Donations  models.Money `json:"donations"` // Lumens donated in the period
+	Allocated  float64      `json:"allocated"` // Percentage allocated to the categories
+	Categories []BudgetLine `json:"categories"`
+	Unbudgeted models.Money `json:"unbudgeted"` // Lumens paid out in the period without one of its categories
+}
+
+// BuildBudgetReport compares each category's share of a fiscal period's budget with
+// the lumen payments executed during the period
+func BuildBudgetReport(db *gorm.DB, period *models.FiscalPeriod) (*BudgetReport, error) {
+	start, end, err := ParsePeriodMonths(period.StartMonth, period.EndMonth)
+	if err != nil {
+		return nil, err
+	}
+	end = end.AddDate(0, 1, 0)
+
+	report := &BudgetReport{
+		CharityID:  period.CharityID,
+		PeriodID:   period.ID,
+		Period:     period.Name,
+		From:       period.StartMonth,
+		To:         period.EndMonth,
+		Status:     period.Status,
+		Budget:     period.Budget,
+		Categories: []BudgetLine{},
+	}
+
+	var stroops int64
+	err = db.Model(&models.CharityTotal{}).
+		Where("charity_id = ? AND asset_code = ? AND asset_issuer = '' AND month >= ? AND month <= ?", period.CharityID, NativeAssetCode, period.StartMonth, period.EndMonth).
+		Select("COALESCE(SUM(stroops), 0)").Scan(&stroops).Error
+	if err != nil {
+		return nil, err
+	}
+	report.Donations = models.Money(stroops)
+
+	var categories []models.BudgetCategory
+	if err := db.Where("period_id = ?", period.ID).Order("id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	// Refunded approvals were paid out before their unspent funds were returned
+	var approvals []models.TransactionApproval
+	err = db.Select("id", "category_id", "amount").
+		Where("charity_id = ? AND kind = ? AND status IN ? AND asset_code = ? AND asset_issuer = ''", period.CharityID, models.ApprovalKindPayment,
+			[]string{models.ApprovalStatusExecuted, models.ApprovalStatusRefunding, models.ApprovalStatusRefunded}, NativeAssetCode).
+		Where("executed_at >= ? AND executed_at < ?", start, end).
+		Find(&approvals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, category := range categories {
+		report.Categories = append(report.Categories, BudgetLine{
+			CategoryID: category.ID,
+			Name:       category.Name,
+			Allocation: category.Allocation,
+			Budget:     CategoryBudget(period.Budget, category.Allocation),
+		})
+		report.Allocated += category.Allocation
+	}
+	lines := make(map[uint]*BudgetLine, len(categories))
+	for i := range report.Categories {
+		lines[report.Categories[i].CategoryID] = &report.Categories[i]
+	}
+
+	for _, approval := range approvals {
+		line, ok := lines[approval.CategoryID]
+		if !ok {
+			report.Unbudgeted += approval.Amount
+			continue
+		}
+		line.Actual += approval.Amount
+		line.Approvals++
+	}
+	for i := range report.Categories {
+		report.Categories[i].Variance = report.Categories[i].Budget - report.Categories[i].Actual
+	}
+
+	return report, nil
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func openBudgetDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "budget.db")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Charity{}, &models.FiscalPeriod{}, &models.BudgetCategory{}, &models.CharityTotal{}, &models.TransactionApproval{},
+		&models.BudgetVersion{}, &models.BudgetVersionLine{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCheckSpend(t *testing.T) {
+	db := openBudgetDB(t)
+	month := time.Now().UTC().Format(monthFormat)
+	usdc := models.Asset{AssetCode: "USDC", AssetIssuer: "GISSUER"}
+
+	charity := models.Charity{Name: "Charity", WalletAddress: "GWALLET", WalletSecret: "secret"}
+	db.Create(&charity)
+	period := models.FiscalPeriod{CharityID: charity.ID, StartMonth: month, EndMonth: month, Budget: 1000, Status: models.FiscalPeriodOpen}
+	db.Create(&period)
+	category := models.BudgetCategory{CharityID: charity.ID, PeriodID: period.ID, Name: "Food", Allocation: 50, Spent: 100}
+	db.Create(&category)
+
+	// Half of the 600 USDC donated this period, less the 100 already paid out
+	db.Create(&models.CharityTotal{CharityID: charity.ID, AssetCode: usdc.AssetCode, AssetIssuer: usdc.AssetIssuer, Month: month, Stroops: 600})
+	db.Create(&models.TransactionApproval{CharityID: charity.ID, CategoryID: category.ID, Amount: 100, Asset: usdc, Status: models.ApprovalStatusExecuted})
+	db.Create(&models.TransactionApproval{CharityID: charity.ID, CategoryID: category.ID, Amount: 500, Asset: usdc, Status: models.ApprovalStatusCancelled})
+
+	// Half of the 1000 lumen budget, less the 100 spent and the 50 being submitted
+	db.Create(&models.TransactionApproval{CharityID: charity.ID, CategoryID: category.ID, Amount: 50, Asset: models.NativeAsset(), Status: models.ApprovalStatusExecuting})
+	db.Create(&models.TransactionApproval{CharityID: charity.ID, CategoryID: category.ID, Amount: 70, Asset: models.NativeAsset(), Status: models.ApprovalStatusApproved})
+
+	for _, test := range []struct {
+		name      string
+		amount    models.Money
+		asset     models.Asset
+		fits      bool
+		available models.Money // Left in the category when the payment does not fit
+	}{
+		{"lumens within the budget", 350, models.NativeAsset(), true, 0},
+		{"lumens over the budget", 351, models.NativeAsset(), false, 350},
+		{"asset within its donations", 200, usdc, true, 0},
+		{"asset over its donations", 201, usdc, false, 200},
+		{"asset never donated", 1, models.Asset{AssetCode: "EURC", AssetIssuer: "GISSUER"}, false, 0},
+	} {
+		err := CheckSpend(db, &charity, category.ID, test.amount, test.asset)
+		var overspend *OverspendError
+		switch {
+		case test.fits && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case !test.fits && (!errors.As(err, &overspend) || overspend.Available != test.available):
+			t.Errorf("%s: expected %s left, got %v", test.name, test.available, err)
+		}
+	}
+
+	// A category deleted after the payment was requested cannot be spent from
+	db.Delete(&category)
+	if err := CheckSpend(db, &charity, category.ID, 1, models.NativeAsset()); !errors.Is(err, ErrCategoryDeleted) {
+		t.Fatalf("deleted category: %v", err)
+	}
+}
+
+func TestCategoryBudget(t *testing.T) {
+	for _, test := range []struct {
+		total      models.Money
+		allocation float64
+		want       models.Money
+	}{
+		{10_000_000, 33.3, 3_330_000},
+		{10_000_001, 50, 5_000_000}, // Rounded down to the stroop
+		{10, 33.3333333, 3},
+		{1, 99.9999999, 0},
+		{9_000_000_000_000_000_000, 100, 9_000_000_000_000_000_000},
+		{10_000_000, 0, 0},
+		{-10_000_000, 50, 0},
+	} {
+		if got := CategoryBudget(test.total, test.allocation); got != test.want {
+			t.Errorf("%g%% of %s: got %s, expected %s", test.allocation, test.total, got, test.want)
+		}
+	}
+}
+
+func TestClosedPeriodCannotBeSpentFrom(t *testing.T) {
+	db := openBudgetDB(t)
+	month := time.Now().UTC().Format(monthFormat)
+
+	charity := models.Charity{Name: "Charity", WalletAddress: "GWALLET", WalletSecret: "secret"}
+	db.Create(&charity)
+	period := models.FiscalPeriod{CharityID: charity.ID, StartMonth: month, EndMonth: month, Budget: 1000, Status: models.FiscalPeriodClosed}
+	db.Create(&period)
+	category := models.BudgetCategory{CharityID: charity.ID, PeriodID: period.ID, Name: "Food", Allocation: 100}
+	db.Create(&category)
+
+	if err := CheckSpend(db, &charity, category.ID, 1, models.NativeAsset()); !errors.Is(err, ErrPeriodClosed) {
+		t.Fatalf("expected ErrPeriodClosed, got %v", err)
+	}
+}
+
+func TestRollForward(t *testing.T) {
+	db := openBudgetDB(t)
+	period := models.FiscalPeriod{CharityID: 1, Name: "FY2025", StartMonth: "2024-11", EndMonth: "2025-10", Budget: 12_345_678_901_234_567, Status: models.FiscalPeriodClosed}
+	db.Create(&period)
+	db.Create(&models.BudgetCategory{CharityID: 1, PeriodID: period.ID, Name: "Food", Allocation: 33.3333333, Spent: 4_000_000_000_000_001})
+	db.Create(&models.BudgetCategory{CharityID: 1, PeriodID: period.ID, Name: "Shelter", Allocation: 66.6666667, Spent: 1})
+
+	next, err := RollForward(db, &period, "", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.StartMonth != "2025-11" || next.EndMonth != "2026-10" || next.Name != "2025-11 to 2026-10" ||
+		next.Budget != period.Budget || next.Status != models.FiscalPeriodOpen {
+		t.Fatalf("rolled forward to %+v", next)
+	}
+
+	var categories []models.BudgetCategory
+	db.Where("period_id = ?", next.ID).Order("id").Find(&categories)
+	var budgeted models.Money
+	for _, category := range categories {
+		if category.Spent != 0 {
+			t.Errorf("%s starts with %s spent", category.Name, category.Spent)
+		}
+		budgeted += CategoryBudget(next.Budget, category.Allocation)
+	}
+	if len(categories) != 2 || categories[0].Allocation != 33.3333333 || categories[1].Allocation != 66.6666667 {
+		t.Fatalf("copied %+v", categories)
+	}
+	// Each share is rounded down, so at most a stroop per category is left unbudgeted
+	if left := next.Budget - budgeted; left < 0 || left > 2 {
+		t.Errorf("categories budget %s of %s", budgeted, next.Budget)
+	}
+
+	var version models.BudgetVersion
+	db.Preload("Lines").Where("period_id = ?", next.ID).First(&version)
+	if version.Version != 1 || version.Budget != period.Budget || len(version.Lines) != 2 || version.CreatedByID != "7" {
+		t.Errorf("recorded version %+v", version)
+	}
+
+	// The next period already exists
+	if _, err := RollForward(db, &period, "", "7"); err == nil {
+		t.Fatal("rolled forward into an overlapping period")
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"fmt"
+)
+
+// Donation verification error codes
+const (
+	VerificationTxNotFound     = "transaction_not_found"
+	VerificationTxFailed       = "transaction_failed"
+	VerificationPaymentMissing = "payment_not_found"
+	VerificationSenderMismatch = "sender_mismatch"
+	VerificationAssetMismatch  = "asset_mismatch"
+	VerificationAmountMismatch = "amount_mismatch"
+	VerificationInvalidAmount  = "invalid_amount"
+)
+
+// VerificationError explains why a reported donation does not match the ledger
+type VerificationError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *VerificationError) Error() string {
+	return e.Message
+}
+
+// VerifyDonation confirms that txHash is a successful payment of the claimed amount in
+// asset from source to destination. It returns the matching payment, a
+// *VerificationError when the transaction does not match, or a Horizon error.
+func VerifyDonation(txHash, source, destination string, claimed models.Money, asset models.Asset) (*Payment, error) {
+	asset = asset.Normalized()
+	if !claimed.IsPositive() {
+		return nil, &VerificationError{Code: VerificationInvalidAmount, Message: "Amount must be positive"}
+	}
+
+	payments, err := Horizon.TransactionPayments(txHash)
+	if errors.Is(err, ErrTransactionNotFound) {
+		return nil, &VerificationError{Code: VerificationTxNotFound, Message: "Transaction was not found on the Stellar network"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var senderMismatch *Payment
+	var assetMismatch *Payment
+	var amountMismatch *Payment
+	for i := range payments {
+		payment := &payments[i]
+		if payment.To != destination {
+			continue
+		}
+		if !payment.Successful {
+			return nil, &VerificationError{Code: VerificationTxFailed, Message: "Transaction failed on the Stellar network"}
+		}
+		if payment.From != source {
+			senderMismatch = payment
+			continue
+		}
+		if payment.Asset() != asset {
+			assetMismatch = payment
+			continue
+		}
+		paid, err := models.ParseMoney(payment.Amount)
+		if err != nil || paid != claimed {
+			amountMismatch = payment
+			continue
+		}
+		return payment, nil
+	}
+
+	switch {
+	case amountMismatch != nil:
+		return nil, &VerificationError{
+			Code:    VerificationAmountMismatch,
+			Message: fmt.Sprintf("Transaction paid %s %s, not %s", amountMismatch.Amount, amountMismatch.AssetCode, claimed),
+		}
+	case assetMismatch != nil:
+		return nil, &VerificationError{
+			Code:    VerificationAssetMismatch,
+			Message: fmt.Sprintf("Transaction paid %s, not %s", assetMismatch.Asset().Canonical(), asset.Canonical()),
+		}
+	case senderMismatch != nil:
+		return nil, &VerificationError{
+			Code:    VerificationSenderMismatch,
+			Message: fmt.Sprintf("Transaction was sent from %s, not from your wallet", senderMismatch.From),
+		}
+	default:
+		return nil, &VerificationError{Code: VerificationPaymentMissing, Message: "Transaction contains no payment to the charity wallet"}
+	}
+}
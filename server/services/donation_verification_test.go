@@ -0,0 +1,55 @@
+package services_test
+
+import (
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"errors"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+)
+
+func TestVerifyDonation(t *testing.T) {
+	fake := useFakeHorizon(t)
+
+	charity := keypair.MustRandom().Address()
+	donor := keypair.MustRandom().Address()
+	usdc := models.Asset{AssetCode: "USDC", AssetIssuer: keypair.MustRandom().Address()}
+
+	fake.AddPayment(services.Payment{TxHash: "paid", Successful: true, From: donor, To: charity, Amount: "10.0000000", AssetCode: services.NativeAssetCode})
+	fake.AddPayment(services.Payment{TxHash: "failed", Successful: false, From: donor, To: charity, Amount: "10.0000000", AssetCode: services.NativeAssetCode})
+	fake.AddPayment(services.Payment{TxHash: "elsewhere", Successful: true, From: donor, To: keypair.MustRandom().Address(), Amount: "10.0000000", AssetCode: services.NativeAssetCode})
+	fake.AddPayment(services.Payment{TxHash: "other-donor", Successful: true, From: keypair.MustRandom().Address(), To: charity, Amount: "10.0000000", AssetCode: services.NativeAssetCode})
+	fake.AddPayment(services.Payment{TxHash: "usdc", Successful: true, From: donor, To: charity, Amount: "10.0000000", AssetCode: usdc.AssetCode, AssetIssuer: usdc.AssetIssuer})
+
+	for _, test := range []struct {
+		name    string
+		txHash  string
+		claimed models.Money
+		asset   models.Asset
+		code    string // Empty when the donation is verified
+	}{
+		{"matching payment", "paid", 100_000_000, models.NativeAsset(), ""},
+		{"issued asset", "usdc", 100_000_000, usdc, ""},
+		{"larger amount claimed", "paid", 100_000_001, models.NativeAsset(), services.VerificationAmountMismatch},
+		{"smaller amount claimed", "paid", 10_000_000, models.NativeAsset(), services.VerificationAmountMismatch},
+		{"lumens claimed for an issued asset", "usdc", 100_000_000, models.NativeAsset(), services.VerificationAssetMismatch},
+		{"payment from another account", "other-donor", 100_000_000, models.NativeAsset(), services.VerificationSenderMismatch},
+		{"payment to another account", "elsewhere", 100_000_000, models.NativeAsset(), services.VerificationPaymentMissing},
+		{"failed transaction", "failed", 100_000_000, models.NativeAsset(), services.VerificationTxFailed},
+		{"unknown transaction", "unknown", 100_000_000, models.NativeAsset(), services.VerificationTxNotFound},
+		{"no amount", "paid", 0, models.NativeAsset(), services.VerificationInvalidAmount},
+	} {
+		payment, err := services.VerifyDonation(test.txHash, donor, charity, test.claimed, test.asset)
+		if test.code == "" {
+			if err != nil || payment.From != donor {
+				t.Errorf("%s: payment %v, err %v", test.name, payment, err)
+			}
+			continue
+		}
+		var verificationErr *services.VerificationError
+		if !errors.As(err, &verificationErr) || verificationErr.Code != test.code {
+			t.Errorf("%s: expected %s, got %v", test.name, test.code, err)
+		}
+	}
+}
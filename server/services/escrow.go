@@ -0,0 +1,123 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"fmt"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+)
+
+// Escrowed payments pay into an escrow account of their own, which the payment creates.
+// The same transaction hands the account over to the charity wallet key and the keys of
+// the charity's cosigners and drops its master key, so its funds only move with the
+// signatures the network asks for at the escrow's threshold, which the wallet key never
+// meets alone. The escrow keeps the signers the charity had when the payment was requested.
+
+const (
+	// baseReserve is the lumens the network holds back for every entry of an account
+	baseReserve models.Money = 5_000_000
+	// escrowFeeBuffer pays the fees of the releases and the refund made from an escrow account
+	escrowFeeBuffer models.Money = 10_000_000
+)
+
+// ErrNoEscrowSigners is returned when a charity has no cosigner key to sign for an escrow account
+var ErrNoEscrowSigners = errors.New("escrow needs at least one active cosigner with a Stellar key")
+
+// EscrowThreshold returns the signatures an escrow account with signers keys requires:
+// the charity's required signatures, but at least two so the wallet key alone never
+// moves escrowed funds, and no more than there are signers
+func EscrowThreshold(required, signers int) (int, error) {
+	if signers < 2 {
+		return 0, ErrNoEscrowSigners
+	}
+	return min(max(required, 2), signers), nil
+}
+
+// escrowReserve returns the lumens an escrow account with signers keys needs besides
+// the funds it holds: the reserve of the account, its signers and its trustline for
+// an issued asset, and the fees of its own transactions
+func escrowReserve(signers int, asset models.Asset) models.Money {
+	entries := 2 + signers
+	if !asset.Normalized().IsNative() {
+		entries++
+	}
+	return models.Money(entries)*baseReserve + escrowFeeBuffer
+}
+
+// BuildEscrowedPayment builds a payment of amount in asset from walletAddress into the
+// new escrow account at escrowAddress. The transaction creates the escrow account with
+// its reserve, adds its trustline for an issued asset, pays it, adds each of signers
+// with SignerWeight and sets every threshold to threshold and the escrow's master key
+// to 0. Besides the wallet's signers it must be signed by the escrow key. It is valid
+// for timeout seconds and unsigned.
+func BuildEscrowedPayment(walletAddress, escrowAddress string, amount models.Money, asset models.Asset, signers []string, threshold int, timeout int64) (*txnbuild.Transaction, error) {
+	if threshold < 1 || threshold > len(signers)*SignerWeight {
+		return nil, fmt.Errorf("threshold %d cannot be met by %d signers", threshold, len(signers))
+	}
+	stellarAsset, err := StellarAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	operations := []txnbuild.Operation{
+		&txnbuild.CreateAccount{Destination: escrowAddress, Amount: escrowReserve(len(signers), asset).String()},
+	}
+	if !stellarAsset.IsNative() {
+		changeTrustAsset, err := stellarAsset.ToChangeTrustAsset()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, &txnbuild.ChangeTrust{Line: changeTrustAsset, Limit: txnbuild.MaxTrustlineLimit, SourceAccount: escrowAddress})
+	}
+	operations = append(operations, &txnbuild.Payment{Destination: escrowAddress, Amount: amount.String(), Asset: stellarAsset})
+	for _, signer := range signers {
+		if _, err := keypair.ParseAddress(signer); err != nil {
+			return nil, fmt.Errorf("invalid signer key %s", signer)
+		}
+		operations = append(operations, &txnbuild.SetOptions{Signer: &txnbuild.Signer{Address: signer, Weight: SignerWeight}, SourceAccount: escrowAddress})
+	}
+	t := txnbuild.Threshold(threshold)
+	operations = append(operations, &txnbuild.SetOptions{
+		MasterWeight:    txnbuild.NewThreshold(0),
+		LowThreshold:    txnbuild.NewThreshold(t),
+		MediumThreshold: txnbuild.NewThreshold(t),
+		HighThreshold:   txnbuild.NewThreshold(t),
+		SourceAccount:   escrowAddress,
+	})
+
+	return buildTransaction(walletAddress, operations, timeout)
+}
+
+// BuildEscrowRefund builds the transaction that closes an escrow account: it makes the
+// payouts in asset, pays rest to walletAddress and removes the trustline for an issued
+// asset, and merges the account into walletAddress, which returns the remaining lumens.
+// It needs the escrow's high threshold. It is valid for timeout seconds and unsigned.
+func BuildEscrowRefund(escrowAddress, walletAddress string, asset models.Asset, payouts []Payout, rest models.Money, timeout int64) (*txnbuild.Transaction, error) {
+	stellarAsset, err := StellarAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+	if !stellarAsset.IsNative() && rest.IsPositive() {
+		payouts = append(payouts, Payout{Destination: walletAddress, Amount: rest})
+	}
+	if len(payouts)+2 > maxOperations {
+		return nil, ErrTooManyPayouts
+	}
+
+	operations, err := payoutOperations(asset, payouts)
+	if err != nil {
+		return nil, err
+	}
+	if !stellarAsset.IsNative() {
+		changeTrustAsset, err := stellarAsset.ToChangeTrustAsset()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, &txnbuild.ChangeTrust{Line: changeTrustAsset, Limit: "0"})
+	}
+	operations = append(operations, &txnbuild.AccountMerge{Destination: walletAddress})
+
+	return buildTransaction(escrowAddress, operations, timeout)
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"cleargive/server/models"
+	"cleargive/server/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/stellar/go/txnbuild"
+)
+
+// MaxEvidenceSize is the largest evidence file accepted, in bytes
+const MaxEvidenceSize = 10 << 20
+
+// Evidence errors
+var (
+	ErrEvidenceTooLarge    = fmt.Errorf("evidence files cannot be larger than %d MB", MaxEvidenceSize>>20)
+	ErrEvidenceType        = errors.New("evidence must be a JPEG, PNG, GIF or WebP image or a PDF")
+	ErrAnchoringDisabled   = errors.New("evidence anchoring is not configured")
+	ErrUnknownAnchorMethod = errors.New(`EVIDENCE_ANCHOR must be "memo" or "manage_data"`)
+)
+
+// Ways of anchoring evidence hashes on the network
+const (
+	AnchorMemo       = "memo"        // a memo hash on a bump sequence operation, costs no reserve
+	AnchorManageData = "manage_data" // a data entry named after the evidence, visible on the account
+)
+
+// evidenceContentTypes are the content types accepted as evidence, detected from the file itself
+var evidenceContentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// EvidenceKey is the blob store key of evidence with the given hash.
+// Evidence is content addressed, so identical files are stored once.
+func EvidenceKey(hash string) string {
+	return "evidence/" + hash
+}
+
+// StoreEvidence checks an uploaded file and writes it to the blob store.
+// It returns the hex encoded SHA-256 of the content and the detected content type.
+func StoreEvidence(store storage.BlobStore, r io.Reader) (hash string, size int64, contentType string, err error) {
+	content, err := io.ReadAll(io.LimitReader(r, MaxEvidenceSize+1))
+	if err != nil {
+		return "", 0, "", err
+	}
+	if len(content) > MaxEvidenceSize {
+		return "", 0, "", ErrEvidenceTooLarge
+	}
+
+	// The content type claimed by the client is not trusted
+	contentType = http.DetectContentType(content)
+	if !evidenceContentTypes[contentType] {
+		return "", 0, "", ErrEvidenceType
+	}
+
+	sum := sha256.Sum256(content)
+	hash = hex.EncodeToString(sum[:])
+	if err := store.Put(EvidenceKey(hash), bytes.NewReader(content)); err != nil {
+		return "", 0, "", err
+	}
+	return hash, int64(len(content)), contentType, nil
+}
+
+// EvidenceAnchoring reports whether evidence hashes should be anchored on the network.
+//
+// EVIDENCE_ANCHOR selects "memo" or "manage_data" and EVIDENCE_ANCHOR_SECRET is the
+// secret of the account that submits the anchors, plain or encrypted with the keyring.
+func EvidenceAnchoring() bool {
+	return os.Getenv("EVIDENCE_ANCHOR") != "" && os.Getenv("EVIDENCE_ANCHOR_SECRET") != ""
+}
+
+// BuildEvidenceAnchor builds a transaction from sourceAddress that records the
+// hash of evidence on the network using method. The returned transaction is unsigned.
+func BuildEvidenceAnchor(sourceAddress, method string, evidence *models.MilestoneEvidence) (*txnbuild.Transaction, error) {
+	sum, err := hex.DecodeString(evidence.SHA256)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, errors.New("evidence hash must be a hex encoded SHA-256")
+	}
+
+	sequence, err := Horizon.AccountSequence(sourceAddress)
+	if err != nil {
+		return nil, err
+	}
+	account := txnbuild.NewSimpleAccount(sourceAddress, sequence)
+
+	params := txnbuild.TransactionParams{
+		SourceAccount:        &account,
+		IncrementSequenceNum: true,
+		BaseFee:              txnbuild.MinBaseFee,
+		Preconditions:        txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(transactionTimeout)},
+	}
+
+	switch method {
+	case AnchorMemo:
+		var memo txnbuild.MemoHash
+		copy(memo[:], sum)
+		params.Memo = memo
+		// Bumping to zero leaves the sequence alone, the operation only carries the memo
+		params.Operations = []txnbuild.Operation{&txnbuild.BumpSequence{BumpTo: 0}}
+	case AnchorManageData:
+		params.Operations = []txnbuild.Operation{&txnbuild.ManageData{
+			Name:  fmt.Sprintf("evidence:%d:%d", evidence.MilestoneID, evidence.ID),
+			Value: sum,
+		}}
+	default:
+		return nil, ErrUnknownAnchorMethod
+	}
+
+	return txnbuild.NewTransaction(params)
+}
+
+// AnchorEvidence submits the hash of evidence to the network with the configured
+// anchoring account so donors can check the file was not replaced later
+func AnchorEvidence(evidence *models.MilestoneEvidence) (*SubmitResult, error) {
+	if !EvidenceAnchoring() {
+		return nil, ErrAnchoringDisabled
+	}
+
+	source, err := signerFromSecret(envSecret("EVIDENCE_ANCHOR_SECRET"))
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := BuildEvidenceAnchor(source.Address(), os.Getenv("EVIDENCE_ANCHOR"), evidence)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignAndSubmit(tx, source)
+}
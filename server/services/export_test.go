@@ -0,0 +1,4 @@
+package services
+
+// UseTestKeyring exports useTestKeyring to the services_test package
+var UseTestKeyring = useTestKeyring
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/protocols/horizon/operations"
+)
+
+// SubmitResult describes a transaction accepted by the network
+type SubmitResult struct {
+	Hash   string `json:"hash"`
+	Ledger int32  `json:"ledger"`
+}
+
+// AccountAuth describes the signers and thresholds of an account
+type AccountAuth struct {
+	Signers       map[string]int32 `json:"signers"` // signer public key -> weight, including the master key
+	LowThreshold  uint8            `json:"lowThreshold"`
+	MedThreshold  uint8            `json:"medThreshold"`
+	HighThreshold uint8            `json:"highThreshold"`
+}
+
+// NativeAssetCode is the asset code used for lumens
+const NativeAssetCode = models.NativeAssetCode
+
+// Payment is a payment received by an account as reported by Horizon
+type Payment struct {
+	ID          string    `json:"id"`
+	PagingToken string    `json:"pagingToken"`
+	TxHash      string    `json:"txHash"`
+	Successful  bool      `json:"successful"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Amount      string    `json:"amount"`
+	AssetCode   string    `json:"assetCode"` // XLM for lumens
+	AssetIssuer string    `json:"assetIssuer,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Asset returns the asset the payment was made in
+func (p Payment) Asset() models.Asset {
+	return models.Asset{AssetCode: p.AssetCode, AssetIssuer: p.AssetIssuer}
+}
+
+// HorizonClient is the subset of Horizon used by the server.
+// It is an interface so tests can replace the network with horizontest.
+type HorizonClient interface {
+	// FundAccount creates and funds an account through Friendbot. It fails on the public network.
+	FundAccount(address string) error
+	// AccountSequence returns the current sequence number of an account
+	AccountSequence(address string) (int64, error)
+	// AccountAuth returns the signers and thresholds of an account
+	AccountAuth(address string) (*AccountAuth, error)
+	// SubmitTransaction submits a base64 encoded transaction envelope
+	SubmitTransaction(envelopeXDR string) (*SubmitResult, error)
+	// TransactionPayments returns the payments made by a transaction.
+	// It returns ErrTransactionNotFound when Horizon does not know the hash.
+	TransactionPayments(hash string) ([]Payment, error)
+	// StreamPayments calls handler for every payment involving account after cursor,
+	// in ledger order, until ctx is cancelled or handler returns an error.
+	// An empty cursor starts from the oldest payment Horizon has.
+	StreamPayments(ctx context.Context, account, cursor string, handler func(Payment) error) error
+}
+
+// ErrTransactionNotFound is returned when a transaction hash is unknown to Horizon
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// Horizon is the client used for all network access
+var Horizon HorizonClient
+
+// NetworkPassphrase is the passphrase transactions are signed for
+var NetworkPassphrase = network.TestNetworkPassphrase
+
+// ConnectHorizon configures the Horizon client from the environment.
+//
+// STELLAR_NETWORK selects "testnet" (default) or "public" and HORIZON_URL overrides
+// the server URL.
+func ConnectHorizon() {
+	horizonURL := os.Getenv("HORIZON_URL")
+	public := os.Getenv("STELLAR_NETWORK") == "public"
+
+	switch {
+	case public:
+		NetworkPassphrase = network.PublicNetworkPassphrase
+		if horizonURL == "" {
+			horizonURL = horizonclient.DefaultPublicNetClient.HorizonURL
+		}
+	default:
+		NetworkPassphrase = network.TestNetworkPassphrase
+		if horizonURL == "" {
+			horizonURL = horizonclient.DefaultTestNetClient.HorizonURL
+		}
+	}
+
+	Horizon = &horizonAdapter{client: &horizonclient.Client{HorizonURL: horizonURL}, public: public}
+	log.Printf("Using Horizon at %s", horizonURL)
+}
+
+// horizonAdapter implements HorizonClient on top of the SDK client
+type horizonAdapter struct {
+	client *horizonclient.Client
+	public bool
+}
+
+func (h *horizonAdapter) FundAccount(address string) error {
+	if h.public {
+		return errors.New("horizon: accounts cannot be funded on the public network")
+	}
+	if _, err := h.client.Fund(address); err != nil {
+		return describeHorizonError(err)
+	}
+	return nil
+}
+
+func (h *horizonAdapter) AccountSequence(address string) (int64, error) {
+	account, err := h.client.AccountDetail(horizonclient.AccountRequest{AccountID: address})
+	if err != nil {
+		return 0, describeHorizonError(err)
+	}
+	return account.GetSequenceNumber()
+}
+
+func (h *horizonAdapter) AccountAuth(address string) (*AccountAuth, error) {
+	account, err := h.client.AccountDetail(horizonclient.AccountRequest{AccountID: address})
+	if err != nil {
+		return nil, describeHorizonError(err)
+	}
+
+	auth := &AccountAuth{
+		Signers:       make(map[string]int32),
+		LowThreshold:  account.Thresholds.LowThreshold,
+		MedThreshold:  account.Thresholds.MedThreshold,
+		HighThreshold: account.Thresholds.HighThreshold,
+	}
+	for _, signer := range account.Signers {
+		auth.Signers[signer.Key] = signer.Weight
+	}
+	return auth, nil
+}
+
+func (h *horizonAdapter) SubmitTransaction(envelopeXDR string) (*SubmitResult, error) {
+	tx, err := h.client.SubmitTransactionXDR(envelopeXDR)
+	if err != nil {
+		return nil, describeHorizonError(err)
+	}
+	return &SubmitResult{Hash: tx.Hash, Ledger: tx.Ledger}, nil
+}
+
+func (h *horizonAdapter) TransactionPayments(hash string) ([]Payment, error) {
+	page, err := h.client.Payments(horizonclient.OperationRequest{ForTransaction: hash, IncludeFailed: true, Limit: 200})
+	if err != nil {
+		if horizonclient.IsNotFoundError(err) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, describeHorizonError(err)
+	}
+
+	var payments []Payment
+	for _, record := range page.Embedded.Records {
+		if payment, ok := paymentFromOperation(record); ok {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (h *horizonAdapter) StreamPayments(ctx context.Context, account, cursor string, handler func(Payment) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var handlerErr error
+	request := horizonclient.OperationRequest{ForAccount: account, Cursor: cursor, IncludeFailed: true}
+	err := h.client.StreamPayments(ctx, request, func(op operations.Operation) {
+		if handlerErr != nil {
+			return
+		}
+		payment, ok := paymentFromOperation(op)
+		if !ok {
+			return
+		}
+		if handlerErr = handler(payment); handlerErr != nil {
+			cancel()
+		}
+	})
+
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if err != nil {
+		return describeHorizonError(err)
+	}
+	return ctx.Err()
+}
+
+// paymentFromOperation converts payment operations into a Payment.
+// Account creation is not treated as a payment, so wallet funding is never mistaken for a donation.
+func paymentFromOperation(op operations.Operation) (Payment, bool) {
+	var base operations.Base
+	var payment Payment
+
+	switch o := op.(type) {
+	case operations.Payment:
+		base = o.Base
+		payment = Payment{From: o.From, To: o.To, Amount: o.Amount, AssetCode: o.Asset.Code, AssetIssuer: o.Asset.Issuer}
+		if o.Asset.Type == "native" {
+			payment.AssetCode = NativeAssetCode
+		}
+	case operations.PathPayment:
+		return paymentFromOperation(o.Payment)
+	case operations.PathPaymentStrictSend:
+		return paymentFromOperation(o.Payment)
+	default:
+		return Payment{}, false
+	}
+
+	payment.ID = base.ID
+	payment.PagingToken = base.PT
+	payment.TxHash = base.TransactionHash
+	payment.Successful = base.TransactionSuccessful
+	payment.CreatedAt = base.LedgerCloseTime
+	return payment, true
+}
+
+// describeHorizonError adds the Horizon result codes to an error when available
+func describeHorizonError(err error) error {
+	if hErr, ok := err.(*horizonclient.Error); ok {
+		if codes, cErr := hErr.ResultCodes(); cErr == nil {
+			return fmt.Errorf("horizon: %s (transaction: %s, operations: %v)", hErr.Problem.Title, codes.TransactionCode, codes.OperationCodes)
+		}
+		return fmt.Errorf("horizon: %s", hErr.Problem.Title)
+	}
+	return err
+}
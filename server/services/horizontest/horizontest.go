@@ -0,0 +1,451 @@
+// Package horizontest provides an in-memory Horizon for tests.
+package horizontest
+
+import (
+	"cleargive/server/services"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
+)
+
+// Horizon is an in-memory services.HorizonClient for tests.
+// It tracks account sequence numbers, signers and thresholds, checks signatures
+// the way the network does, rejecting transactions with too little weight or with
+// signatures no threshold needs, creates and merges accounts, requires trustlines for
+// payments in issued assets and records every accepted transaction and payment.
+type Horizon struct {
+	mu        sync.Mutex
+	accounts  map[string]*fakeAccount
+	payments  []services.Payment
+	ledger    int32
+	Submitted []*txnbuild.Transaction
+}
+
+type fakeAccount struct {
+	sequence   int64
+	auth       services.AccountAuth
+	trustlines map[string]bool // CODE:ISSUER of the issued assets the account trusts
+}
+
+// New creates an empty Horizon
+func New() *Horizon {
+	return &Horizon{
+		accounts: make(map[string]*fakeAccount),
+		ledger:   1,
+	}
+}
+
+// CreateAccount registers an account so it can be loaded and used as a transaction source
+func (f *Horizon) CreateAccount(address string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.accounts[address]; !ok {
+		f.accounts[address] = f.newAccount(address)
+	}
+}
+
+// newAccount returns an account signed by its master key alone, with a sequence
+// number starting at the current ledger like the network's
+func (f *Horizon) newAccount(address string) *fakeAccount {
+	return &fakeAccount{
+		sequence:   int64(f.ledger) << 32,
+		auth:       services.AccountAuth{Signers: map[string]int32{address: 1}},
+		trustlines: make(map[string]bool),
+	}
+}
+
+// FundAccount creates the account, as Friendbot does on testnet
+func (f *Horizon) FundAccount(address string) error {
+	f.CreateAccount(address)
+	return nil
+}
+
+func (f *Horizon) AccountSequence(address string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	account, ok := f.accounts[address]
+	if !ok {
+		return 0, fmt.Errorf("horizon: account %s not found", address)
+	}
+	return account.sequence, nil
+}
+
+func (f *Horizon) AccountAuth(address string) (*services.AccountAuth, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	account, ok := f.accounts[address]
+	if !ok {
+		return nil, fmt.Errorf("horizon: account %s not found", address)
+	}
+
+	auth := account.auth
+	auth.Signers = make(map[string]int32, len(account.auth.Signers))
+	for key, weight := range account.auth.Signers {
+		auth.Signers[key] = weight
+	}
+	return &auth, nil
+}
+
+func (f *Horizon) SubmitTransaction(envelopeXDR string) (*services.SubmitResult, error) {
+	parsed, err := txnbuild.TransactionFromXDR(envelopeXDR)
+	if err != nil {
+		return nil, fmt.Errorf("horizon: invalid envelope: %w", err)
+	}
+	tx, ok := parsed.Transaction()
+	if !ok {
+		return nil, errors.New("horizon: fee bump transactions are not supported")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	source := tx.SourceAccount().AccountID
+	account, ok := f.accounts[source]
+	if !ok {
+		return nil, errors.New("horizon: tx_no_source_account")
+	}
+	if tx.SequenceNumber() != account.sequence+1 {
+		return nil, errors.New("horizon: tx_bad_seq")
+	}
+
+	hash, err := tx.Hash(services.NetworkPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkSignatures(tx, hash[:]); err != nil {
+		return nil, err
+	}
+	if err := f.checkAccounts(tx); err != nil {
+		return nil, err
+	}
+	if err := f.checkTrustlines(tx); err != nil {
+		return nil, err
+	}
+	if err := f.checkMerges(tx); err != nil {
+		return nil, err
+	}
+
+	f.ledger++
+	txHash := fmt.Sprintf("%x", hash)
+
+	account.sequence = tx.SequenceNumber()
+	for i, op := range tx.Operations() {
+		opSource := operationSource(tx, op)
+		switch o := op.(type) {
+		case *txnbuild.CreateAccount:
+			f.accounts[o.Destination] = f.newAccount(o.Destination)
+		case *txnbuild.SetOptions:
+			applySetOptions(&f.accounts[opSource].auth, opSource, o)
+		case *txnbuild.ChangeTrust:
+			line := o.Line.GetCode() + ":" + o.Line.GetIssuer()
+			f.accounts[opSource].trustlines[line] = o.Limit != "0"
+		case *txnbuild.AccountMerge:
+			delete(f.accounts, opSource)
+		case *txnbuild.Payment:
+			code, issuer := assetParts(o.Asset)
+			f.recordPayment(services.Payment{
+				ID:          fmt.Sprintf("%d-%d", f.ledger, i+1),
+				TxHash:      txHash,
+				Successful:  true,
+				From:        opSource,
+				To:          o.Destination,
+				Amount:      o.Amount,
+				AssetCode:   code,
+				AssetIssuer: issuer,
+			})
+		}
+	}
+
+	f.Submitted = append(f.Submitted, tx)
+
+	return &services.SubmitResult{Hash: txHash, Ledger: f.ledger}, nil
+}
+
+func (f *Horizon) TransactionPayments(hash string) ([]services.Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var payments []services.Payment
+	for _, payment := range f.payments {
+		if payment.TxHash == hash {
+			payments = append(payments, payment)
+		}
+	}
+	if len(payments) == 0 {
+		return nil, services.ErrTransactionNotFound
+	}
+	return payments, nil
+}
+
+// fakeStreamInterval is how often StreamPayments checks for new payments
+const fakeStreamInterval = 100 * time.Millisecond
+
+func (f *Horizon) StreamPayments(ctx context.Context, account, cursor string, handler func(services.Payment) error) error {
+	ticker := time.NewTicker(fakeStreamInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, payment := range f.paymentsAfter(account, cursor) {
+			if err := handler(payment); err != nil {
+				return err
+			}
+			cursor = payment.PagingToken
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// paymentsAfter returns the payments involving account with a paging token after cursor
+func (f *Horizon) paymentsAfter(account, cursor string) []services.Payment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var payments []services.Payment
+	for _, payment := range f.payments {
+		if (payment.From == account || payment.To == account) && payment.PagingToken > cursor {
+			payments = append(payments, payment)
+		}
+	}
+	return payments
+}
+
+// AddPayment records a payment made outside the server, such as a donation sent from a wallet app.
+// The ID and paging token are assigned when empty.
+func (f *Horizon) AddPayment(payment services.Payment) services.Payment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.ledger++
+	if payment.ID == "" {
+		payment.ID = fmt.Sprintf("%d-1", f.ledger)
+	}
+	f.recordPayment(payment)
+	return f.payments[len(f.payments)-1]
+}
+
+func (f *Horizon) recordPayment(payment services.Payment) {
+	if payment.PagingToken == "" {
+		payment.PagingToken = fmt.Sprintf("%012d", len(f.payments)+1)
+	}
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = time.Now().UTC()
+	}
+	f.payments = append(f.payments, payment)
+}
+
+// checkAccounts fails like the network when an operation's source account neither
+// exists nor is created earlier in tx, or when tx creates an account that exists
+func (f *Horizon) checkAccounts(tx *txnbuild.Transaction) error {
+	created := make(map[string]bool)
+	for _, op := range tx.Operations() {
+		if _, known := f.accounts[operationSource(tx, op)]; !known && !created[operationSource(tx, op)] {
+			return errors.New("horizon: op_no_account")
+		}
+		if create, ok := op.(*txnbuild.CreateAccount); ok {
+			if _, exists := f.accounts[create.Destination]; exists || created[create.Destination] {
+				return errors.New("horizon: op_already_exists")
+			}
+			created[create.Destination] = true
+		}
+	}
+	return nil
+}
+
+// checkTrustlines fails with op_no_trust when a payment in an issued asset goes to a
+// known account without a trustline. Trustlines added earlier in tx count.
+func (f *Horizon) checkTrustlines(tx *txnbuild.Transaction) error {
+	added := make(map[string]bool) // ACCOUNT/CODE:ISSUER
+	for _, op := range tx.Operations() {
+		switch o := op.(type) {
+		case *txnbuild.ChangeTrust:
+			added[operationSource(tx, op)+"/"+o.Line.GetCode()+":"+o.Line.GetIssuer()] = o.Limit != "0"
+		case *txnbuild.Payment:
+			code, issuer := assetParts(o.Asset)
+			if issuer == "" || o.Destination == issuer {
+				continue
+			}
+			line := code + ":" + issuer
+			trusted, changed := added[o.Destination+"/"+line]
+			if !changed {
+				destination, known := f.accounts[o.Destination]
+				if !known {
+					continue
+				}
+				trusted = destination.trustlines[line]
+			}
+			if !trusted {
+				return errors.New("horizon: op_no_trust")
+			}
+		}
+	}
+	return nil
+}
+
+// checkMerges fails like the network when tx merges an account into one that does not
+// exist, or merges an account that still has a trustline. Trustlines changed earlier in
+// tx count.
+func (f *Horizon) checkMerges(tx *txnbuild.Transaction) error {
+	trustlines := make(map[string]map[string]bool) // ACCOUNT -> CODE:ISSUER
+	trusts := func(address string) map[string]bool {
+		if _, ok := trustlines[address]; !ok {
+			trustlines[address] = make(map[string]bool)
+			if account, ok := f.accounts[address]; ok {
+				for line, trusted := range account.trustlines {
+					trustlines[address][line] = trusted
+				}
+			}
+		}
+		return trustlines[address]
+	}
+
+	for _, op := range tx.Operations() {
+		switch o := op.(type) {
+		case *txnbuild.ChangeTrust:
+			trusts(operationSource(tx, op))[o.Line.GetCode()+":"+o.Line.GetIssuer()] = o.Limit != "0"
+		case *txnbuild.AccountMerge:
+			if _, exists := f.accounts[o.Destination]; !exists {
+				return errors.New("horizon: op_no_destination")
+			}
+			for _, trusted := range trusts(operationSource(tx, op)) {
+				if trusted {
+					return errors.New("horizon: op_has_sub_entries")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// assetParts splits a txnbuild asset into its code and issuer
+func assetParts(asset txnbuild.Asset) (string, string) {
+	if asset == nil || asset.IsNative() {
+		return services.NativeAssetCode, ""
+	}
+	return asset.GetCode(), asset.GetIssuer()
+}
+
+// operationSource returns the account an operation of tx acts on
+func operationSource(tx *txnbuild.Transaction, op txnbuild.Operation) string {
+	if source := op.GetSourceAccount(); source != "" {
+		return source
+	}
+	return tx.SourceAccount().AccountID
+}
+
+// checkSignatures checks the signatures of tx like the network: the low threshold of
+// the source account for the transaction, then every operation's threshold on its own
+// source account. Each check takes the signatures in order until the signers that made
+// them carry the threshold, at least 1. An account created by tx must sign with its own
+// key. The transaction fails with tx_bad_auth when a check is not met and with
+// tx_bad_auth_extra when a signature is not used by any check.
+func (f *Horizon) checkSignatures(tx *txnbuild.Transaction, hash []byte) error {
+	used := make([]bool, len(tx.Signatures()))
+	check := func(address string, threshold func(*services.AccountAuth) uint8) bool {
+		signers := map[string]int32{address: 1}
+		var needed int32
+		if account, ok := f.accounts[address]; ok {
+			signers = account.auth.Signers
+			needed = int32(threshold(&account.auth))
+		}
+		return verifySignatures(tx.Signatures(), hash, signers, needed, used)
+	}
+
+	source := tx.SourceAccount().AccountID
+	if !check(source, func(auth *services.AccountAuth) uint8 { return auth.LowThreshold }) {
+		return errors.New("horizon: tx_bad_auth")
+	}
+	for _, op := range tx.Operations() {
+		op := op
+		if !check(operationSource(tx, op), func(auth *services.AccountAuth) uint8 { return operationThreshold(op, auth) }) {
+			return errors.New("horizon: tx_bad_auth")
+		}
+	}
+
+	for _, sigUsed := range used {
+		if !sigUsed {
+			return errors.New("horizon: tx_bad_auth_extra")
+		}
+	}
+	return nil
+}
+
+// verifySignatures marks the signatures that signers made until their weight reaches
+// needed and reports whether it did
+func verifySignatures(signatures []xdr.DecoratedSignature, hash []byte, signers map[string]int32, needed int32, used []bool) bool {
+	remaining := make(map[string]int32, len(signers))
+	for key, weight := range signers {
+		if weight > 0 {
+			remaining[key] = weight
+		}
+	}
+
+	var total int32
+	for i, sig := range signatures {
+		for key, weight := range remaining {
+			kp, err := keypair.ParseAddress(key)
+			if err != nil || sig.Hint != xdr.SignatureHint(kp.Hint()) || kp.Verify(hash, sig.Signature) != nil {
+				continue
+			}
+			used[i] = true
+			total += weight
+			if total >= needed {
+				return true
+			}
+			delete(remaining, key)
+			break
+		}
+	}
+	return false
+}
+
+// operationThreshold returns the threshold of auth the network checks op against
+func operationThreshold(op txnbuild.Operation, auth *services.AccountAuth) uint8 {
+	switch o := op.(type) {
+	case *txnbuild.SetOptions:
+		if o.Signer != nil || o.MasterWeight != nil || o.LowThreshold != nil ||
+			o.MediumThreshold != nil || o.HighThreshold != nil {
+			return auth.HighThreshold
+		}
+	case *txnbuild.AccountMerge:
+		return auth.HighThreshold
+	case *txnbuild.BumpSequence, *txnbuild.ClaimClaimableBalance:
+		return auth.LowThreshold
+	}
+	return auth.MedThreshold
+}
+
+func applySetOptions(auth *services.AccountAuth, master string, op *txnbuild.SetOptions) {
+	if op.MasterWeight != nil {
+		auth.Signers[master] = int32(*op.MasterWeight)
+	}
+	if op.LowThreshold != nil {
+		auth.LowThreshold = uint8(*op.LowThreshold)
+	}
+	if op.MediumThreshold != nil {
+		auth.MedThreshold = uint8(*op.MediumThreshold)
+	}
+	if op.HighThreshold != nil {
+		auth.HighThreshold = uint8(*op.HighThreshold)
+	}
+	if op.Signer != nil {
+		if op.Signer.Weight == 0 {
+			delete(auth.Signers, op.Signer.Address)
+		} else {
+			auth.Signers[op.Signer.Address] = int32(op.Signer.Weight)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvitationTTL is how long a cosigner invitation can be accepted
+const InvitationTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrNoInviteSecret is returned when COSIGNER_INVITE_SECRET is not configured
+	ErrNoInviteSecret = errors.New("COSIGNER_INVITE_SECRET is not configured")
+	// ErrInvalidInvitation is returned for tokens that are forged, malformed or expired
+	ErrInvalidInvitation = errors.New("invitation is invalid or has expired")
+)
+
+// Invitation tokens are "<payload>.<mac>" with both parts base64url encoded. The
+// payload is "<cosigner id>:<expiry unix time>:<random nonce>" and the mac is its
+// HMAC-SHA256 under COSIGNER_INVITE_SECRET. Only a hash of the token is stored.
+
+func inviteSecret() ([]byte, error) {
+	secret := os.Getenv("COSIGNER_INVITE_SECRET")
+	if secret == "" {
+		return nil, ErrNoInviteSecret
+	}
+	return []byte(secret), nil
+}
+
+// NewInvitationToken creates a signed invitation token for a cosigner and the
+// hash to store for it
+func NewInvitationToken(cosignerID uint, expiresAt time.Time) (token string, hash string, err error) {
+	secret, err := inviteSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	payload := fmt.Sprintf("%d:%d:%s", cosignerID, expiresAt.Unix(), hex.EncodeToString(nonce))
+
+	token = base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(invitationMAC(secret, payload))
+	return token, HashInvitationToken(token), nil
+}
+
+// ParseInvitationToken checks a token's signature and expiry and returns the
+// cosigner it was issued for
+func ParseInvitationToken(token string, now time.Time) (uint, error) {
+	secret, err := inviteSecret()
+	if err != nil {
+		return 0, err
+	}
+
+	encodedPayload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidInvitation
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, ErrInvalidInvitation
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, invitationMAC(secret, string(payload))) {
+		return 0, ErrInvalidInvitation
+	}
+
+	parts := strings.Split(string(payload), ":")
+	if len(parts) != 3 {
+		return 0, ErrInvalidInvitation
+	}
+	cosignerID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidInvitation
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.After(time.Unix(expiry, 0)) {
+		return 0, ErrInvalidInvitation
+	}
+
+	return uint(cosignerID), nil
+}
+
+// HashInvitationToken returns the hex SHA-256 hash stored for a token
+func HashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func invitationMAC(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
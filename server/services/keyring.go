@@ -0,0 +1,283 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stellar/go/keypair"
+)
+
+// Secrets are stored with envelope encryption: every secret is encrypted with its own
+// random data key, and the data key is encrypted with a master key from the environment.
+//
+// The stored format is "enc:v2:<master key id>:<wrapped data key>:<ciphertext>" with both
+// binary parts base64 encoded as nonce followed by AES-256-GCM ciphertext. Both parts are
+// authenticated with the row the secret is stored in, so a secret copied to another row
+// does not decrypt.
+const secretPrefix = "enc:v2:"
+
+// Secret is a stored secret and the row it is stored in, e.g. "charities/12".
+// Secrets from the environment have no row.
+type Secret struct {
+	Stored string
+	Row    string
+	env    bool // set for secrets from the environment, which may be plain
+}
+
+// SecretRow returns the row identity a secret stored in table under id is bound to
+func SecretRow(table string, id uint) string {
+	return fmt.Sprintf("%s/%d", table, id)
+}
+
+// CharityWalletSecret returns the stored wallet secret of a charity
+func CharityWalletSecret(charity *models.Charity) Secret {
+	return Secret{Stored: charity.WalletSecret, Row: SecretRow("charities", charity.ID)}
+}
+
+// ApprovalEscrowSecret returns the stored key of an escrowed approval's escrow account
+func ApprovalEscrowSecret(approval *models.TransactionApproval) Secret {
+	return Secret{Stored: approval.EscrowSecret, Row: SecretRow("transaction_approvals", approval.ID)}
+}
+
+// UserWalletSecret returns the stored wallet secret of a user
+func UserWalletSecret(user *models.User) Secret {
+	return Secret{Stored: user.StellarWallet.SecretKey, Row: SecretRow("users", user.ID)}
+}
+
+// envSecret returns a secret from the environment, plain or encrypted with the keyring
+func envSecret(name string) Secret {
+	return Secret{Stored: os.Getenv(name), env: true}
+}
+
+var (
+	// ErrNoMasterKey is returned when SECRET_MASTER_KEYS is not configured
+	ErrNoMasterKey = errors.New("no secret master key configured")
+	// ErrUnknownKeyID is returned when a secret was encrypted with a master key that is not loaded
+	ErrUnknownKeyID = errors.New("secret was encrypted with an unknown master key")
+	// ErrPlaintextSecret is returned when a stored secret was never encrypted
+	ErrPlaintextSecret = errors.New("secret is stored in plaintext, run cmd/encrypt-secrets")
+)
+
+type keyring struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+var (
+	loadKeyringOnce sync.Once
+	loadedKeyring   *keyring
+	keyringErr      error
+)
+
+// loadKeyring reads the master keys once.
+//
+// SECRET_MASTER_KEYS is a comma separated list of "<key id>:<base64 32 byte key>" and
+// SECRET_ACTIVE_KEY_ID picks the key used for new secrets (the first key by default).
+// Older keys stay listed after a rotation so existing secrets can still be decrypted.
+func loadKeyring() (*keyring, error) {
+	loadKeyringOnce.Do(func() {
+		loadedKeyring, keyringErr = parseKeyring(os.Getenv("SECRET_MASTER_KEYS"), os.Getenv("SECRET_ACTIVE_KEY_ID"))
+	})
+	return loadedKeyring, keyringErr
+}
+
+func parseKeyring(spec, activeID string) (*keyring, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, ErrNoMasterKey
+	}
+
+	ring := &keyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid master key entry %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key %s must be 32 bytes of base64", id)
+		}
+		ring.keys[id] = key
+		if ring.activeID == "" {
+			ring.activeID = id
+		}
+	}
+
+	if activeID != "" {
+		if _, ok := ring.keys[activeID]; !ok {
+			return nil, fmt.Errorf("active master key %s is not configured", activeID)
+		}
+		ring.activeID = activeID
+	}
+	return ring, nil
+}
+
+// EncryptSecret encrypts a secret stored in row with a fresh data key wrapped by the active master key
+func EncryptSecret(plaintext, row string) (string, error) {
+	ring, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(ring.keys[ring.activeID], dataKey, []byte(row))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), []byte(row))
+	if err != nil {
+		return "", err
+	}
+
+	return secretPrefix + ring.activeID + ":" +
+		base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// IsEncryptedSecret reports whether a stored secret is already encrypted
+func IsEncryptedSecret(stored string) bool {
+	return strings.HasPrefix(stored, secretPrefix)
+}
+
+// SecretKeyID returns the master key id a stored secret was encrypted with
+func SecretKeyID(stored string) string {
+	if !IsEncryptedSecret(stored) {
+		return ""
+	}
+	id, _, _ := strings.Cut(stored[len(secretPrefix):], ":")
+	return id
+}
+
+// SecretIsCurrent reports whether a stored secret is encrypted with the master key
+// activeKeyID, so it does not need to be re-encrypted
+func SecretIsCurrent(stored, activeKeyID string) bool {
+	return IsEncryptedSecret(stored) && SecretKeyID(stored) == activeKeyID
+}
+
+// ActiveSecretKeyID returns the id of the master key used for new secrets
+func ActiveSecretKeyID() (string, error) {
+	ring, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	return ring.activeID, nil
+}
+
+// ReencryptSecret encrypts a plaintext secret, or re-encrypts an encrypted one under the
+// active master key, bound to its row
+func ReencryptSecret(secret Secret) (string, error) {
+	if !IsEncryptedSecret(secret.Stored) {
+		return EncryptSecret(secret.Stored, secret.Row)
+	}
+	plaintext, err := decryptSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return EncryptSecret(plaintext, secret.Row)
+}
+
+// decryptSecret returns the plaintext of a stored secret.
+// Only secrets from the environment may be plain, stored rows must have been encrypted.
+func decryptSecret(secret Secret) (string, error) {
+	stored := secret.Stored
+	if !IsEncryptedSecret(stored) {
+		if secret.env {
+			return stored, nil
+		}
+		return "", ErrPlaintextSecret
+	}
+	row := []byte(secret.Row)
+
+	parts := strings.Split(stored[len(secretPrefix):], ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted secret")
+	}
+
+	ring, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	masterKey, ok := ring.keys[parts[0]]
+	if !ok {
+		return "", ErrUnknownKeyID
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed encrypted secret")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed encrypted secret")
+	}
+
+	dataKey, err := open(masterKey, wrappedKey, row)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext, row)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// signerFromSecret decrypts a stored Stellar secret seed into a keypair
+func signerFromSecret(secret Secret) (*keypair.Full, error) {
+	seed, err := decryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := keypair.ParseFull(seed)
+	if err != nil {
+		return nil, errors.New("invalid secret key")
+	}
+	return kp, nil
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed encrypted secret")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+	if err != nil {
+		return nil, errors.New("could not decrypt secret")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
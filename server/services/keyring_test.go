@@ -0,0 +1,74 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// useTestKeyring loads a fixed keyring instead of the one in the environment
+func useTestKeyring(t *testing.T) {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	ring, err := parseKeyring("k1:"+key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadKeyringOnce.Do(func() {})
+	previous, previousErr := loadedKeyring, keyringErr
+	loadedKeyring, keyringErr = ring, nil
+	t.Cleanup(func() { loadedKeyring, keyringErr = previous, previousErr })
+}
+
+func TestSecretsAreBoundToTheirRow(t *testing.T) {
+	useTestKeyring(t)
+
+	row := SecretRow("charities", 1)
+	stored, err := EncryptSecret("SECRET", row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SecretIsCurrent(stored, "k1") {
+		t.Fatalf("fresh secret %q is not current", stored)
+	}
+
+	plaintext, err := decryptSecret(Secret{Stored: stored, Row: row})
+	if err != nil || plaintext != "SECRET" {
+		t.Fatalf("decrypt own row: %q, %v", plaintext, err)
+	}
+
+	// A secret copied into another row does not decrypt
+	for _, other := range []string{SecretRow("charities", 2), SecretRow("users", 1), ""} {
+		if _, err := decryptSecret(Secret{Stored: stored, Row: other}); err == nil {
+			t.Fatalf("secret of %s decrypted for %q", row, other)
+		}
+	}
+}
+
+func TestPlaintextSecretsAreRejected(t *testing.T) {
+	useTestKeyring(t)
+
+	row := SecretRow("users", 7)
+	if _, err := decryptSecret(Secret{Stored: "SECRET", Row: row}); !errors.Is(err, ErrPlaintextSecret) {
+		t.Fatalf("expected ErrPlaintextSecret, got %v", err)
+	}
+
+	// cmd/encrypt-secrets encrypts them for their row
+	updated, err := ReencryptSecret(Secret{Stored: "SECRET", Row: row})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SecretIsCurrent(updated, "k1") {
+		t.Fatalf("encrypted secret %q is not current", updated)
+	}
+	plaintext, err := decryptSecret(Secret{Stored: updated, Row: row})
+	if err != nil || plaintext != "SECRET" {
+		t.Fatalf("decrypt encrypted secret: %q, %v", plaintext, err)
+	}
+
+	// Secrets from the environment may still be plain
+	t.Setenv("TEST_SECRET", "SECRET")
+	if plaintext, err := decryptSecret(envSecret("TEST_SECRET")); err != nil || plaintext != "SECRET" {
+		t.Fatalf("decrypt environment secret: %q, %v", plaintext, err)
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrMilestonesExceedApproval is returned when milestones would allocate more than the approval amount
+	ErrMilestonesExceedApproval = errors.New("milestone amounts would exceed the approval amount")
+	// ErrApprovalNotFundable is returned when milestones are added to an approval that is not approved or executed
+	ErrApprovalNotFundable = errors.New("milestones can only be added to approved or executed approvals")
+)
+
+// MilestoneSummary shows how an approval's amount is split across its milestones
+type MilestoneSummary struct {
+	ApprovalID     uint         `json:"approvalId"`
+	models.Asset                // Asset of the approval and all its milestones
+	Amount         models.Money `json:"amount"`
+	Allocated      models.Money `json:"allocated"`   // Held by milestones that are not cancelled
+	Released       models.Money `json:"released"`    // Paid out by released milestones
+	Remaining      models.Money `json:"remaining"`   // Amount not released yet
+	Unallocated    models.Money `json:"unallocated"` // Amount not held by any milestone
+	MilestoneCount int          `json:"milestoneCount"`
+}
+
+// SummarizeMilestones totals the milestones of an approval
+func SummarizeMilestones(db *gorm.DB, approval *models.TransactionApproval) (*MilestoneSummary, error) {
+	var milestones []models.Milestone
+	if err := db.Select("id", "amount", "status").Where("approval_id = ?", approval.ID).Find(&milestones).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &MilestoneSummary{
+		ApprovalID: approval.ID,
+		Asset:      approval.Asset,
+		Amount:     approval.Amount,
+	}
+	for _, milestone := range milestones {
+		if milestone.Status == models.MilestoneStatusCancelled {
+			continue
+		}
+		summary.Allocated += milestone.Amount
+		summary.MilestoneCount++
+		if milestone.Status == models.MilestoneStatusReleased {
+			summary.Released += milestone.Amount
+		}
+	}
+	summary.Remaining = summary.Amount - summary.Released
+	summary.Unallocated = summary.Amount - summary.Allocated
+
+	return summary, nil
+}
+
+// AddMilestone creates a milestone for an approval inside tx, checking the
+// approval is approved or executed and that its milestones stay within its amount
+func AddMilestone(tx *gorm.DB, approval *models.TransactionApproval, milestone *models.Milestone) error {
+	// Reload the status so a concurrent cancellation is seen
+	var current models.TransactionApproval
+	if err := tx.Select("id", "status").First(&current, approval.ID).Error; err != nil {
+		return err
+	}
+	if current.Status != models.ApprovalStatusApproved && current.Status != models.ApprovalStatusExecuted {
+		return ErrApprovalNotFundable
+	}
+
+	summary, err := SummarizeMilestones(tx, approval)
+	if err != nil {
+		return err
+	}
+	if milestone.Amount > summary.Unallocated {
+		return ErrMilestonesExceedApproval
+	}
+
+	milestone.ApprovalID = approval.ID
+	milestone.Asset = approval.Asset
+	return tx.Create(milestone).Error
+}
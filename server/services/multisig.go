@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+)
+
+// SignerWeight is the weight of the master key and of every cosigner key. The medium
+// and high thresholds are set to the number of required signatures, so payments and
+// signer changes need that many signers and the server's master key cannot make them alone.
+const SignerWeight = 1
+
+// BuildSignerOptions returns the SetOptions operations that turn the signers and
+// thresholds described by current into the desired cosigner set. A threshold of 0
+// restores a single-signer account.
+func BuildSignerOptions(master string, current *AccountAuth, cosigners []string, threshold int) ([]txnbuild.Operation, error) {
+	if threshold < 0 || threshold > len(cosigners)+1 {
+		return nil, fmt.Errorf("threshold %d cannot be met by %d signers", threshold, len(cosigners)+1)
+	}
+
+	desired := make(map[string]bool, len(cosigners))
+	for _, key := range cosigners {
+		if _, err := keypair.ParseAddress(key); err != nil {
+			return nil, fmt.Errorf("invalid cosigner key %s", key)
+		}
+		if key == master {
+			return nil, errors.New("the charity wallet cannot be its own cosigner")
+		}
+		desired[key] = true
+	}
+
+	var ops []txnbuild.Operation
+
+	// Add new signers before removing old ones so the account never drops below its threshold
+	for _, key := range SortedKeys(desired) {
+		if current.Signers[key] != SignerWeight {
+			ops = append(ops, &txnbuild.SetOptions{Signer: &txnbuild.Signer{Address: key, Weight: SignerWeight}})
+		}
+	}
+	for _, key := range SortedKeys(current.Signers) {
+		if key != master && !desired[key] {
+			ops = append(ops, &txnbuild.SetOptions{Signer: &txnbuild.Signer{Address: key, Weight: 0}})
+		}
+	}
+
+	t := txnbuild.Threshold(threshold)
+	if current.Signers[master] != SignerWeight || current.MedThreshold != uint8(t) || current.HighThreshold != uint8(t) {
+		ops = append(ops, &txnbuild.SetOptions{
+			MasterWeight:    txnbuild.NewThreshold(SignerWeight),
+			LowThreshold:    txnbuild.NewThreshold(0),
+			MediumThreshold: txnbuild.NewThreshold(t),
+			HighThreshold:   txnbuild.NewThreshold(t),
+		})
+	}
+
+	return ops, nil
+}
+
+// BuildSignerUpdate builds the transaction that makes the signers of the account at
+// address match cosigners and threshold, valid for timeout seconds. It returns nil when
+// the account is already in sync. The transaction is unsigned: it needs the signatures
+// of the signers the account has now.
+func BuildSignerUpdate(address string, cosigners []string, threshold int, timeout int64) (*txnbuild.Transaction, error) {
+	current, err := Horizon.AccountAuth(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := BuildSignerOptions(address, current, cosigners, threshold)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	sequence, err := Horizon.AccountSequence(address)
+	if err != nil {
+		return nil, err
+	}
+	account := txnbuild.NewSimpleAccount(address, sequence)
+
+	return txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount:        &account,
+		IncrementSequenceNum: true,
+		Operations:           ops,
+		BaseFee:              txnbuild.MinBaseFee,
+		Preconditions:        txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(timeout)},
+	})
+}
+
+// SignaturesNeeded returns how many signatures the account at address needs for
+// operations at its high threshold, or its medium threshold when high is false. The
+// first signature is made with ownerKey, the charity wallet key, and every other signer
+// carries SignerWeight.
+func SignaturesNeeded(address, ownerKey string, high bool) (int, error) {
+	auth, err := Horizon.AccountAuth(address)
+	if err != nil {
+		return 0, err
+	}
+
+	threshold := int32(max(auth.MedThreshold, 1))
+	if high {
+		threshold = int32(max(auth.HighThreshold, 1))
+	}
+	owner := auth.Signers[ownerKey]
+	if threshold <= owner {
+		return 1, nil
+	}
+	return 1 + int((threshold-owner+SignerWeight-1)/SignerWeight), nil
+}
+
+// SignerDrift reports whether the on-chain signers of address differ from the expected set
+func SignerDrift(address string, cosigners []string, threshold int) (bool, *AccountAuth, error) {
+	current, err := Horizon.AccountAuth(address)
+	if err != nil {
+		return false, nil, err
+	}
+
+	ops, err := BuildSignerOptions(address, current, cosigners, threshold)
+	if err != nil {
+		return false, current, err
+	}
+	return len(ops) > 0, current, nil
+}
+
+// SortedKeys returns the keys of a set in a stable order
+func SortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,157 @@
+package services_test
+
+import (
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"cleargive/server/services/horizontest"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+)
+
+// useFakeHorizon swaps in an in-memory Horizon for the duration of a test
+func useFakeHorizon(t *testing.T) *horizontest.Horizon {
+	t.Helper()
+	fake := horizontest.New()
+	previous := services.Horizon
+	services.Horizon = fake
+	t.Cleanup(func() { services.Horizon = previous })
+	return fake
+}
+
+// walletSecret encrypts the seed of kp the way wallet secrets are stored
+func walletSecret(t *testing.T, kp *keypair.Full) services.Secret {
+	t.Helper()
+	services.UseTestKeyring(t)
+	stored, err := services.EncryptSecret(kp.Seed(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return services.Secret{Stored: stored}
+}
+
+// signWith returns the decorated signatures of keys over an envelope
+func signWith(t *testing.T, envelopeXDR string, keys ...*keypair.Full) []string {
+	t.Helper()
+	var signatures []string
+	for _, key := range keys {
+		signature, err := services.SignEnvelope(envelopeXDR, walletSecret(t, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures
+}
+
+func TestCosignersAreNeededAfterSync(t *testing.T) {
+	fake := useFakeHorizon(t)
+
+	master := keypair.MustRandom()
+	donor := keypair.MustRandom()
+	fake.CreateAccount(master.Address())
+	fake.CreateAccount(donor.Address())
+	secret := walletSecret(t, master)
+
+	first, second := keypair.MustRandom(), keypair.MustRandom()
+	cosigners := []string{first.Address(), second.Address()}
+
+	// encode returns the envelope of an unsigned transaction
+	encode := func(tx *txnbuild.Transaction, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope, _, err := services.EncodeEnvelope(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return envelope
+	}
+
+	// A single-signer wallet signs its own signer change
+	update := encode(services.BuildSignerUpdate(master.Address(), cosigners, 2, 300))
+	if _, err := services.SubmitWithSignatures(update, nil, secret); err != nil {
+		t.Fatalf("sync signers: %v", err)
+	}
+
+	auth, err := services.Horizon.AccountAuth(master.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Signers[master.Address()] != services.SignerWeight || auth.MedThreshold != 2 || auth.HighThreshold != 2 {
+		t.Fatalf("master weight %d, thresholds %d/%d", auth.Signers[master.Address()], auth.MedThreshold, auth.HighThreshold)
+	}
+	drifted, _, err := services.SignerDrift(master.Address(), cosigners, 2)
+	if err != nil || drifted {
+		t.Fatalf("drifted %v, err %v", drifted, err)
+	}
+	if needed, err := services.SignaturesNeeded(master.Address(), master.Address(), true); err != nil || needed != 2 {
+		t.Fatalf("signatures needed %d, err %v", needed, err)
+	}
+
+	// The master key alone can no longer pay or change the signers
+	payment := encode(services.BuildPayment(master.Address(), donor.Address(), 10_000_000, models.NativeAsset(), 300))
+	if _, err := services.SubmitWithSignatures(payment, nil, secret); !errors.Is(err, services.ErrSignatureWeight) {
+		t.Fatalf("payment signed by the master key alone: %v", err)
+	}
+	if _, err := services.SendPayment(secret, donor.Address(), 10_000_000, models.NativeAsset()); err == nil || !strings.Contains(err.Error(), "tx_bad_auth") {
+		t.Fatalf("payment submitted with the master key alone: %v", err)
+	}
+	restore := encode(services.BuildSignerUpdate(master.Address(), nil, 0, 300))
+	if _, err := services.SubmitWithSignatures(restore, nil, secret); !errors.Is(err, services.ErrSignatureWeight) {
+		t.Fatalf("signer change signed by the master key alone: %v", err)
+	}
+
+	// The network rejects signatures the thresholds do not need
+	parsed, err := txnbuild.TransactionFromXDR(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, _ := parsed.Transaction()
+	tx, err = tx.Sign(services.NetworkPassphrase, master, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overSigned, err := tx.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Horizon.SubmitTransaction(overSigned); err == nil || !strings.Contains(err.Error(), "tx_bad_auth_extra") {
+		t.Fatalf("payment with an extra signature: %v", err)
+	}
+
+	// Only the signatures the thresholds use are attached
+	if _, err := services.SubmitWithSignatures(payment, signWith(t, payment, first, second), secret); err != nil {
+		t.Fatalf("payment signed by both cosigners and the master key: %v", err)
+	}
+	if signed := fake.Submitted[len(fake.Submitted)-1].Signatures(); len(signed) != 2 {
+		t.Fatalf("payment submitted with %d signatures", len(signed))
+	}
+	if _, err := services.SubmitWithSignatures(restore, signWith(t, restore, second), secret); err == nil {
+		t.Fatal("expected the stale signer change to be rejected")
+	}
+	restore = encode(services.BuildSignerUpdate(master.Address(), nil, 0, 300))
+	if _, err := services.SubmitWithSignatures(restore, signWith(t, restore, second), secret); err != nil {
+		t.Fatalf("restore single signer: %v", err)
+	}
+	if needed, err := services.SignaturesNeeded(master.Address(), master.Address(), true); err != nil || needed != 1 {
+		t.Fatalf("signatures needed %d, err %v", needed, err)
+	}
+}
+
+func TestBuildSignerOptionsRejectsUnreachableThreshold(t *testing.T) {
+	master := keypair.MustRandom().Address()
+	current := &services.AccountAuth{Signers: map[string]int32{master: 1}}
+	cosigners := []string{keypair.MustRandom().Address()}
+
+	if _, err := services.BuildSignerOptions(master, current, cosigners, 3); err == nil {
+		t.Fatal("expected a threshold above the number of signers to be rejected")
+	}
+	if _, err := services.BuildSignerOptions(master, current, []string{master}, 1); err == nil {
+		t.Fatal("expected the master key to be rejected as a cosigner")
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// Notify records a notification for each user inside tx
+func Notify(tx *gorm.DB, userIDs []uint, event, message, link string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	notifications := make([]models.Notification, len(userIDs))
+	for i, userID := range userIDs {
+		notifications[i] = models.Notification{UserID: userID, Event: event, Message: message, Link: link}
+	}
+	if err := tx.Create(&notifications).Error; err != nil {
+		return err
+	}
+
+	log.Printf("notified %d users: %s", len(userIDs), message)
+	return nil
+}
+
+// NotifyCharitySigners notifies the owner and the active cosigners of a charity inside tx
+func NotifyCharitySigners(tx *gorm.DB, charityID uint, event, message, link string) error {
+	userIDs, err := CharitySignerIDs(tx, charityID)
+	if err != nil {
+		return err
+	}
+	return Notify(tx, userIDs, event, message, link)
+}
+
+// CharitySignerIDs returns the user IDs of a charity's owner and active cosigners
+func CharitySignerIDs(tx *gorm.DB, charityID uint) ([]uint, error) {
+	var charity models.Charity
+	if err := tx.Select("id", "owner_id").First(&charity, charityID).Error; err != nil {
+		return nil, err
+	}
+
+	var cosignerIDs []uint
+	err := tx.Model(&models.Cosigner{}).
+		Where("charity_id = ? AND status = ? AND user_id <> ?", charityID, models.CosignerStatusActive, charity.OwnerID).
+		Distinct().Pluck("user_id", &cosignerIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]uint{charity.OwnerID}, cosignerIDs...), nil
+}
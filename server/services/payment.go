@@ -0,0 +1,241 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+)
+
+// transactionTimeout is how long a transaction signed by the server stays valid, in seconds
+const transactionTimeout = 300
+
+// maxOperations is the most operations the network accepts in one transaction
+const maxOperations = 100
+
+// ErrTooManyPayouts is returned when payouts do not fit in a single transaction
+var ErrTooManyPayouts = fmt.Errorf("a transaction can make at most %d payments", maxOperations)
+
+// Payout is one payment of a transaction that pays several accounts
+type Payout struct {
+	Destination string
+	Amount      models.Money
+}
+
+// ApprovalEnvelopeTimeout is how long an envelope collecting cosigner signatures stays valid, in seconds
+const ApprovalEnvelopeTimeout = 7 * 24 * 60 * 60
+
+// BuildPayment builds a payment of amount in asset from sourceAddress to destination
+// that is valid for timeout seconds. The returned transaction is unsigned.
+func BuildPayment(sourceAddress, destination string, amount models.Money, asset models.Asset, timeout int64) (*txnbuild.Transaction, error) {
+	if _, err := keypair.ParseAddress(destination); err != nil {
+		return nil, fmt.Errorf("invalid destination address: %w", err)
+	}
+	if !amount.IsPositive() {
+		return nil, errors.New("amount must be positive")
+	}
+	stellarAsset, err := StellarAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	sequence, err := Horizon.AccountSequence(sourceAddress)
+	if err != nil {
+		return nil, err
+	}
+	account := txnbuild.NewSimpleAccount(sourceAddress, sequence)
+
+	return txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount:        &account,
+		IncrementSequenceNum: true,
+		Operations: []txnbuild.Operation{
+			&txnbuild.Payment{
+				Destination: destination,
+				Amount:      amount.String(),
+				Asset:       stellarAsset,
+			},
+		},
+		BaseFee:       txnbuild.MinBaseFee,
+		Preconditions: txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(timeout)},
+	})
+}
+
+// ErrStaleEnvelope is returned when the source account has used the sequence number of an envelope
+var ErrStaleEnvelope = errors.New("the wallet has made other transactions since the envelope was built, it must be signed again")
+
+// CheckEnvelopeSequence returns ErrStaleEnvelope when the envelope's sequence number
+// is no longer the next one of its source account, so the network would reject it
+func CheckEnvelopeSequence(envelopeXDR string) error {
+	tx, _, err := parseEnvelope(envelopeXDR)
+	if err != nil {
+		return err
+	}
+	sequence, err := Horizon.AccountSequence(tx.SourceAccount().AccountID)
+	if err != nil {
+		return err
+	}
+	if tx.SequenceNumber() != sequence+1 {
+		return ErrStaleEnvelope
+	}
+	return nil
+}
+
+// Outcomes of a submitted envelope
+const (
+	EnvelopeLanded  = "landed"  // in a ledger and successful
+	EnvelopeFailed  = "failed"  // cannot land: failed in a ledger, its sequence number was used or its time bounds passed
+	EnvelopePending = "pending" // not in a ledger yet but could still land
+)
+
+// EnvelopeOutcome looks up an envelope on the network by its hash, so a submission
+// whose response was lost can be settled without paying twice
+func EnvelopeOutcome(envelopeXDR string) (string, error) {
+	tx, hash, err := parseEnvelope(envelopeXDR)
+	if err != nil {
+		return "", err
+	}
+
+	// Whether the envelope can still land is checked before looking it up, so an envelope
+	// that lands in between is found rather than reported failed
+	expired := tx.Timebounds().MaxTime != txnbuild.TimeoutInfinite && time.Now().Unix() > tx.Timebounds().MaxTime
+	err = CheckEnvelopeSequence(envelopeXDR)
+	stale := errors.Is(err, ErrStaleEnvelope)
+	if err != nil && !stale {
+		return "", err
+	}
+
+	payments, err := Horizon.TransactionPayments(fmt.Sprintf("%x", hash))
+	if err == nil {
+		if len(payments) > 0 && payments[0].Successful {
+			return EnvelopeLanded, nil
+		}
+		return EnvelopeFailed, nil
+	}
+	if !errors.Is(err, ErrTransactionNotFound) {
+		return "", err
+	}
+	if expired || stale {
+		return EnvelopeFailed, nil
+	}
+	return EnvelopePending, nil
+}
+
+// RebuildEnvelope builds the operations of an envelope again with the current sequence
+// number of its source account, valid for timeout seconds. The returned transaction is unsigned.
+func RebuildEnvelope(envelopeXDR string, timeout int64) (*txnbuild.Transaction, error) {
+	tx, _, err := parseEnvelope(envelopeXDR)
+	if err != nil {
+		return nil, err
+	}
+
+	source := tx.SourceAccount().AccountID
+	sequence, err := Horizon.AccountSequence(source)
+	if err != nil {
+		return nil, err
+	}
+	account := txnbuild.NewSimpleAccount(source, sequence)
+
+	return txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount:        &account,
+		IncrementSequenceNum: true,
+		Operations:           tx.Operations(),
+		BaseFee:              txnbuild.MinBaseFee,
+		Memo:                 tx.Memo(),
+		Preconditions:        txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(timeout)},
+	})
+}
+
+// SendPayment signs a payment with the stored source secret and submits it through Horizon
+func SendPayment(sourceSecret Secret, destination string, amount models.Money, asset models.Asset) (*SubmitResult, error) {
+	source, err := signerFromSecret(sourceSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := BuildPayment(source.Address(), destination, amount, asset, transactionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignAndSubmit(tx, source)
+}
+
+// BuildPayouts builds a transaction from sourceAddress that pays every payout in asset,
+// so either all of them are made or none are. It is valid for timeout seconds and unsigned.
+func BuildPayouts(sourceAddress string, asset models.Asset, payouts []Payout, timeout int64) (*txnbuild.Transaction, error) {
+	operations, err := payoutOperations(asset, payouts)
+	if err != nil {
+		return nil, err
+	}
+	return buildTransaction(sourceAddress, operations, timeout)
+}
+
+// buildTransaction builds an unsigned transaction of operations from sourceAddress that is valid for timeout seconds
+func buildTransaction(sourceAddress string, operations []txnbuild.Operation, timeout int64) (*txnbuild.Transaction, error) {
+	sequence, err := Horizon.AccountSequence(sourceAddress)
+	if err != nil {
+		return nil, err
+	}
+	account := txnbuild.NewSimpleAccount(sourceAddress, sequence)
+
+	return txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount:        &account,
+		IncrementSequenceNum: true,
+		Operations:           operations,
+		BaseFee:              txnbuild.MinBaseFee,
+		Preconditions:        txnbuild.Preconditions{TimeBounds: txnbuild.NewTimeout(timeout)},
+	})
+}
+
+// payoutOperations builds a payment operation for every payout
+func payoutOperations(asset models.Asset, payouts []Payout) ([]txnbuild.Operation, error) {
+	if len(payouts) > maxOperations {
+		return nil, ErrTooManyPayouts
+	}
+	stellarAsset, err := StellarAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	operations := make([]txnbuild.Operation, 0, len(payouts))
+	for _, payout := range payouts {
+		if _, err := keypair.ParseAddress(payout.Destination); err != nil {
+			return nil, fmt.Errorf("invalid destination address: %w", err)
+		}
+		if !payout.Amount.IsPositive() {
+			return nil, errors.New("amount must be positive")
+		}
+		operations = append(operations, &txnbuild.Payment{
+			Destination: payout.Destination,
+			Amount:      payout.Amount.String(),
+			Asset:       stellarAsset,
+		})
+	}
+	return operations, nil
+}
+
+// SignAndSubmit signs a transaction with the given keys and submits it through Horizon
+func SignAndSubmit(tx *txnbuild.Transaction, signers ...*keypair.Full) (*SubmitResult, error) {
+	signed, err := tx.Sign(NetworkPassphrase, signers...)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := signed.Base64()
+	if err != nil {
+		return nil, err
+	}
+
+	return Horizon.SubmitTransaction(envelope)
+}
+
+// assetParts splits a txnbuild asset into its code and issuer
+func assetParts(asset txnbuild.Asset) (string, string) {
+	if asset == nil || asset.IsNative() {
+		return NativeAssetCode, ""
+	}
+	return asset.GetCode(), asset.GetIssuer()
+}
@@ -0,0 +1,67 @@
+package services_test
+
+import (
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"errors"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+)
+
+func TestStaleEnvelopeIsRebuilt(t *testing.T) {
+	fake := useFakeHorizon(t)
+
+	wallet := keypair.MustRandom()
+	destination := keypair.MustRandom()
+	fake.CreateAccount(wallet.Address())
+	fake.CreateAccount(destination.Address())
+	secret := walletSecret(t, wallet)
+
+	tx, err := services.BuildPayment(wallet.Address(), destination.Address(), 50_000_000, models.NativeAsset(), services.ApprovalEnvelopeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, _, err := services.EncodeEnvelope(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.CheckEnvelopeSequence(envelope); err != nil {
+		t.Fatalf("fresh envelope: %v", err)
+	}
+
+	// Another payment from the wallet uses the envelope's sequence number
+	if _, err := services.SendPayment(secret, destination.Address(), 10_000_000, models.NativeAsset()); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.CheckEnvelopeSequence(envelope); !errors.Is(err, services.ErrStaleEnvelope) {
+		t.Fatalf("expected services.ErrStaleEnvelope, got %v", err)
+	}
+	if _, err := services.SubmitWithSignatures(envelope, nil, secret); err == nil {
+		t.Fatal("expected the stale envelope to be rejected by services.Horizon")
+	}
+
+	rebuilt, err := services.RebuildEnvelope(envelope, services.ApprovalEnvelopeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuiltEnvelope, _, err := services.EncodeEnvelope(rebuilt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.CheckEnvelopeSequence(rebuiltEnvelope); err != nil {
+		t.Fatalf("rebuilt envelope: %v", err)
+	}
+	result, err := services.SubmitWithSignatures(rebuiltEnvelope, nil, secret)
+	if err != nil {
+		t.Fatalf("submit rebuilt envelope: %v", err)
+	}
+
+	payments, err := fake.TransactionPayments(result.Hash)
+	if err != nil || len(payments) != 1 {
+		t.Fatalf("payments %v, err %v", payments, err)
+	}
+	if payments[0].To != destination.Address() || payments[0].Amount != "5.0000000" {
+		t.Fatalf("rebuilt payment does not match the original: %+v", payments[0])
+	}
+}
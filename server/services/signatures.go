@@ -0,0 +1,289 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
+)
+
+// ErrInvalidSignature is returned when a signature does not match the signer key and envelope
+var ErrInvalidSignature = errors.New("signature does not match the signer key and transaction envelope")
+
+// EncodeEnvelope returns the base64 envelope and hex hash of an unsigned transaction
+func EncodeEnvelope(tx *txnbuild.Transaction) (envelopeXDR, hash string, err error) {
+	envelopeXDR, err = tx.Base64()
+	if err != nil {
+		return "", "", err
+	}
+	hash, err = tx.HashHex(NetworkPassphrase)
+	if err != nil {
+		return "", "", err
+	}
+	return envelopeXDR, hash, nil
+}
+
+// parseEnvelope decodes a base64 envelope into a transaction and its network hash
+func parseEnvelope(envelopeXDR string) (*txnbuild.Transaction, [32]byte, error) {
+	var hash [32]byte
+
+	parsed, err := txnbuild.TransactionFromXDR(envelopeXDR)
+	if err != nil {
+		return nil, hash, fmt.Errorf("invalid transaction envelope: %w", err)
+	}
+	tx, ok := parsed.Transaction()
+	if !ok {
+		return nil, hash, errors.New("fee bump envelopes are not supported")
+	}
+
+	hash, err = tx.Hash(NetworkPassphrase)
+	return tx, hash, err
+}
+
+// VerifySignature checks that a base64 XDR decorated signature was made by publicKey
+// over the hash of the envelope
+func VerifySignature(envelopeXDR, publicKey, signatureXDR string) (xdr.DecoratedSignature, error) {
+	var sig xdr.DecoratedSignature
+
+	signer, err := keypair.ParseAddress(publicKey)
+	if err != nil {
+		return sig, fmt.Errorf("invalid signer key: %w", err)
+	}
+
+	_, hash, err := parseEnvelope(envelopeXDR)
+	if err != nil {
+		return sig, err
+	}
+
+	if err := xdr.SafeUnmarshalBase64(signatureXDR, &sig); err != nil {
+		return sig, errors.New("signature must be a base64 XDR decorated signature")
+	}
+
+	if sig.Hint != xdr.SignatureHint(signer.Hint()) || signer.Verify(hash[:], sig.Signature) != nil {
+		return sig, ErrInvalidSignature
+	}
+	return sig, nil
+}
+
+// SignEnvelope signs the envelope hash with the stored secret and returns a base64 XDR decorated signature
+func SignEnvelope(envelopeXDR string, secret Secret) (string, error) {
+	kp, err := signerFromSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	_, hash, err := parseEnvelope(envelopeXDR)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := kp.SignDecorated(hash[:])
+	if err != nil {
+		return "", err
+	}
+	return xdr.MarshalBase64(sig)
+}
+
+// SubmitWithSignatures merges the collected signatures and a signature for each extra
+// secret into the envelope and submits it through Horizon. Only the signatures the
+// network uses to meet the thresholds are attached, in their order, since it rejects
+// a transaction carrying any other signature.
+func SubmitWithSignatures(envelopeXDR string, signatures []string, extraSecrets ...Secret) (*SubmitResult, error) {
+	tx, hash, err := parseEnvelope(envelopeXDR)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, secret := range extraSecrets {
+		sig, err := SignEnvelope(envelopeXDR, secret)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, sig)
+	}
+
+	var merged []xdr.DecoratedSignature
+	for _, encoded := range signatures {
+		var sig xdr.DecoratedSignature
+		if err := xdr.SafeUnmarshalBase64(encoded, &sig); err != nil {
+			return nil, errors.New("stored signature is not a valid decorated signature")
+		}
+		if !containsSignature(merged, sig) {
+			merged = append(merged, sig)
+		}
+	}
+
+	checks, err := signatureChecks(tx)
+	if err != nil {
+		return nil, err
+	}
+	used, err := usedSignatures(checks, hash, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := tx.AddSignatureDecorated(used...)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := signed.Base64()
+	if err != nil {
+		return nil, err
+	}
+	return Horizon.SubmitTransaction(envelope)
+}
+
+// ErrSignatureWeight is returned when the signatures of a transaction do not carry the
+// weight its accounts need
+var ErrSignatureWeight = errors.New("signatures do not meet the thresholds of the accounts in the transaction")
+
+// Threshold levels the network checks operations against
+const (
+	thresholdLow = iota
+	thresholdMedium
+	thresholdHigh
+)
+
+// operationThreshold returns the threshold level of op's source account the network
+// checks its signatures against
+func operationThreshold(op txnbuild.Operation) int {
+	switch o := op.(type) {
+	case *txnbuild.SetOptions:
+		if o.Signer != nil || o.MasterWeight != nil || o.LowThreshold != nil || o.MediumThreshold != nil || o.HighThreshold != nil {
+			return thresholdHigh
+		}
+	case *txnbuild.AccountMerge:
+		return thresholdHigh
+	case *txnbuild.BumpSequence, *txnbuild.ClaimClaimableBalance:
+		return thresholdLow
+	}
+	return thresholdMedium
+}
+
+// signatureCheck requires the signers of an account to carry weight
+type signatureCheck struct {
+	account string
+	signers map[string]int32
+	weight  int32
+}
+
+// signatureChecks returns the checks the network makes before applying tx, in its
+// order: the low threshold of the source account for the transaction, then the
+// threshold of every operation on its own source account. An account the transaction
+// creates can only sign with its own key. A threshold of 0 still needs one signature.
+func signatureChecks(tx *txnbuild.Transaction) ([]signatureCheck, error) {
+	created := make(map[string]bool)
+	for _, op := range tx.Operations() {
+		if create, ok := op.(*txnbuild.CreateAccount); ok {
+			created[create.Destination] = true
+		}
+	}
+
+	accounts := make(map[string]*AccountAuth)
+	check := func(address string, level int) (signatureCheck, error) {
+		auth, ok := accounts[address]
+		if !ok {
+			auth = &AccountAuth{Signers: map[string]int32{address: SignerWeight}}
+			if !created[address] {
+				loaded, err := Horizon.AccountAuth(address)
+				if err != nil {
+					return signatureCheck{}, err
+				}
+				auth = loaded
+			}
+			accounts[address] = auth
+		}
+
+		weight := int32([]uint8{auth.LowThreshold, auth.MedThreshold, auth.HighThreshold}[level])
+		if weight == 0 {
+			weight = 1
+		}
+		return signatureCheck{account: address, signers: auth.Signers, weight: weight}, nil
+	}
+
+	source := tx.SourceAccount().AccountID
+	first, err := check(source, thresholdLow)
+	if err != nil {
+		return nil, err
+	}
+	checks := []signatureCheck{first}
+	for _, op := range tx.Operations() {
+		address := op.GetSourceAccount()
+		if address == "" {
+			address = source
+		}
+		next, err := check(address, operationThreshold(op))
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, next)
+	}
+	return checks, nil
+}
+
+// usedSignatures returns the signatures the checks use, in their order. Like the
+// network, every check takes the signatures in order, each counting the weight of
+// the signer that made it once, until the needed weight is reached.
+func usedSignatures(checks []signatureCheck, hash [32]byte, signatures []xdr.DecoratedSignature) ([]xdr.DecoratedSignature, error) {
+	used := make([]bool, len(signatures))
+	for _, check := range checks {
+		remaining := make(map[string]int32, len(check.signers))
+		for key, weight := range check.signers {
+			if weight > 0 {
+				remaining[key] = weight
+			}
+		}
+
+		var total int32
+		for i, sig := range signatures {
+			key, ok := signedBy(remaining, hash, sig)
+			if !ok {
+				continue
+			}
+			used[i] = true
+			total += remaining[key]
+			delete(remaining, key)
+			if total >= check.weight {
+				break
+			}
+		}
+		if total < check.weight {
+			return nil, fmt.Errorf("%w: %s needs a weight of %d", ErrSignatureWeight, check.account, check.weight)
+		}
+	}
+
+	var attached []xdr.DecoratedSignature
+	for i, sig := range signatures {
+		if used[i] {
+			attached = append(attached, sig)
+		}
+	}
+	return attached, nil
+}
+
+// signedBy returns the signer whose key made sig over hash
+func signedBy(signers map[string]int32, hash [32]byte, sig xdr.DecoratedSignature) (string, bool) {
+	for key := range signers {
+		kp, err := keypair.ParseAddress(key)
+		if err != nil {
+			continue
+		}
+		if sig.Hint == xdr.SignatureHint(kp.Hint()) && kp.Verify(hash[:], sig.Signature) == nil {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func containsSignature(signatures []xdr.DecoratedSignature, sig xdr.DecoratedSignature) bool {
+	for _, existing := range signatures {
+		if existing.Hint == sig.Hint && bytes.Equal(existing.Signature, sig.Signature) {
+			return true
+		}
+	}
+	return false
+}
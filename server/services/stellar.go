@@ -2,8 +2,6 @@ package services
 
 import (
 	"github.com/stellar/go/keypair"
-	"fmt"
-	"net/http"
 )
 
 type StellarAccount struct {
@@ -24,19 +22,3 @@ func CreateStellarAccount() (*StellarAccount, error) {
 		SecretKey:  pair.Seed(),
 	}, nil
 }
-
-// FundTestnetAccount funds a new account on testnet using Friendbot
-func FundTestnetAccount(publicKey string) error {
-	// For production, remove this function and handle funding differently
-	resp, err := http.Get("https://friendbot.stellar.org/?addr=" + publicKey)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to fund account: %d", resp.StatusCode)
-	}
-
-	return nil
-} 
\ No newline at end of file
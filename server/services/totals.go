@@ -0,0 +1,86 @@
+package services
+
+import (
+	"cleargive/server/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DonationStatusConfirmed marks a donation whose payment was verified on the ledger.
+// Only confirmed donations count towards charity totals.
+const DonationStatusConfirmed = "confirmed"
+
+// AddDonationToTotals adds a donation to its charity's totals inside tx
+func AddDonationToTotals(tx *gorm.DB, donation *models.Donation) error {
+	return applyDonation(tx, donation, 1)
+}
+
+// RemoveDonationFromTotals removes a donation from its charity's totals inside tx
+func RemoveDonationFromTotals(tx *gorm.DB, donation *models.Donation) error {
+	return applyDonation(tx, donation, -1)
+}
+
+func applyDonation(tx *gorm.DB, donation *models.Donation, sign int64) error {
+	if donation.Status != DonationStatusConfirmed {
+		return nil
+	}
+
+	asset := donation.Asset.Normalized()
+	row := models.CharityTotal{
+		CharityID:     donation.CharityID,
+		AssetCode:     asset.AssetCode,
+		AssetIssuer:   asset.AssetIssuer,
+		CategoryID:    donation.CategoryID,
+		Month:         donation.CreatedAt.UTC().Format("2006-01"),
+		Stroops:       sign * int64(donation.Amount),
+		DonationCount: sign,
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "charity_id"}, {Name: "asset_code"}, {Name: "asset_issuer"}, {Name: "category_id"}, {Name: "month"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"stroops":        gorm.Expr("stroops + ?", row.Stroops),
+			"donation_count": gorm.Expr("donation_count + ?", row.DonationCount),
+			"updated_at":     gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(&row).Error
+	if err != nil {
+		return err
+	}
+
+	return refreshCharityTotal(tx, donation.CharityID)
+}
+
+// RebuildCharityTotals recomputes all totals of a charity from its confirmed donations
+func RebuildCharityTotals(tx *gorm.DB, charityID uint) error {
+	if err := tx.Where("charity_id = ?", charityID).Delete(&models.CharityTotal{}).Error; err != nil {
+		return err
+	}
+
+	var donations []models.Donation
+	if err := tx.Where("charity_id = ? AND status = ?", charityID, DonationStatusConfirmed).Find(&donations).Error; err != nil {
+		return err
+	}
+
+	for i := range donations {
+		if err := AddDonationToTotals(tx, &donations[i]); err != nil {
+			return err
+		}
+	}
+
+	return refreshCharityTotal(tx, charityID)
+}
+
+// refreshCharityTotal copies the lumen total into Charity.TotalDonations
+func refreshCharityTotal(tx *gorm.DB, charityID uint) error {
+	var stroops int64
+	err := tx.Model(&models.CharityTotal{}).
+		Where("charity_id = ? AND asset_code = ? AND asset_issuer = ''", charityID, NativeAssetCode).
+		Select("COALESCE(SUM(stroops), 0)").Scan(&stroops).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Charity{}).Where("id = ?", charityID).Update("total_donations", models.Money(stroops)).Error
+}
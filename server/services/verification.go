@@ -0,0 +1,67 @@
+package services
+
+import (
+	"cleargive/server/models"
+
+	"gorm.io/gorm"
+)
+
+// VerificationRule is the quorum a milestone needs to be verified
+type VerificationRule struct {
+	Quorum             int  `json:"quorum"`             // Approving votes needed, rejecting votes needed to send it back
+	RequireIndependent bool `json:"requireIndependent"` // One approving vote must be independent
+}
+
+// VerificationRuleFor returns the approval's rule when it sets one, otherwise the charity's
+func VerificationRuleFor(charity *models.Charity, approval *models.TransactionApproval) VerificationRule {
+	rule := VerificationRule{Quorum: charity.VerificationQuorum, RequireIndependent: charity.RequireIndependent}
+	if approval.VerificationQuorum > 0 {
+		rule = VerificationRule{Quorum: approval.VerificationQuorum, RequireIndependent: approval.RequireIndependent}
+	}
+	if rule.Quorum < 1 {
+		rule.Quorum = 1
+	}
+	return rule
+}
+
+// VerificationTally counts the votes of a milestone's current verification round
+type VerificationTally struct {
+	Round                int              `json:"round"`
+	Rule                 VerificationRule `json:"rule"`
+	Approvals            int              `json:"approvals"`
+	Rejections           int              `json:"rejections"`
+	IndependentApprovals int              `json:"independentApprovals"`
+	Outcome              string           `json:"outcome"` // Status the milestone moves to, empty while undecided
+}
+
+// TallyVerifications counts the votes of the milestone's current round and decides
+// the outcome. Rejections are checked first, so when both sides reach the quorum
+// the milestone goes back to pending: a verification is only granted when it is
+// not also refused.
+func TallyVerifications(db *gorm.DB, milestone *models.Milestone, rule VerificationRule) (*VerificationTally, error) {
+	var votes []models.MilestoneVerification
+	if err := db.Where("milestone_id = ? AND round = ?", milestone.ID, milestone.VerificationRound).Find(&votes).Error; err != nil {
+		return nil, err
+	}
+
+	tally := &VerificationTally{Round: milestone.VerificationRound, Rule: rule}
+	for _, vote := range votes {
+		switch vote.Status {
+		case models.VerificationApproved:
+			tally.Approvals++
+			if vote.Independent {
+				tally.IndependentApprovals++
+			}
+		case models.VerificationRejected:
+			tally.Rejections++
+		}
+	}
+
+	switch {
+	case tally.Rejections >= rule.Quorum:
+		tally.Outcome = models.MilestoneStatusPending
+	case tally.Approvals >= rule.Quorum && (!rule.RequireIndependent || tally.IndependentApprovals > 0):
+		tally.Outcome = models.MilestoneStatusVerified
+	}
+	return tally, nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"cleargive/server/models"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestVerificationRuleFor(t *testing.T) {
+	charity := models.Charity{VerificationQuorum: 2, RequireIndependent: true}
+	if rule := VerificationRuleFor(&charity, &models.TransactionApproval{}); rule != (VerificationRule{Quorum: 2, RequireIndependent: true}) {
+		t.Errorf("charity rule: %+v", rule)
+	}
+	if rule := VerificationRuleFor(&charity, &models.TransactionApproval{VerificationQuorum: 3}); rule != (VerificationRule{Quorum: 3}) {
+		t.Errorf("approval rule: %+v", rule)
+	}
+	if rule := VerificationRuleFor(&models.Charity{}, &models.TransactionApproval{}); rule.Quorum != 1 {
+		t.Errorf("unset quorum: %+v", rule)
+	}
+}
+
+func TestTallyVerifications(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "verification.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Milestone{}, &models.MilestoneVerification{}); err != nil {
+		t.Fatal(err)
+	}
+
+	type vote struct {
+		status      string
+		independent bool
+	}
+	approve := vote{models.VerificationApproved, false}
+	approveIndependent := vote{models.VerificationApproved, true}
+	reject := vote{models.VerificationRejected, false}
+
+	for _, test := range []struct {
+		name    string
+		rule    VerificationRule
+		votes   []vote
+		outcome string
+	}{
+		{"no votes", VerificationRule{Quorum: 1}, nil, ""},
+		{"one short of the quorum", VerificationRule{Quorum: 2}, []vote{approve}, ""},
+		{"quorum reached", VerificationRule{Quorum: 2}, []vote{approve, approveIndependent}, models.MilestoneStatusVerified},
+		{"quorum without an independent vote", VerificationRule{Quorum: 2, RequireIndependent: true}, []vote{approve, approve}, ""},
+		{"quorum with an independent vote", VerificationRule{Quorum: 2, RequireIndependent: true}, []vote{approve, approveIndependent}, models.MilestoneStatusVerified},
+		{"rejected", VerificationRule{Quorum: 2}, []vote{reject, approve, reject}, models.MilestoneStatusPending},
+		{"both sides reach the quorum", VerificationRule{Quorum: 1}, []vote{approveIndependent, reject}, models.MilestoneStatusPending},
+	} {
+		milestone := models.Milestone{VerificationRound: 2}
+		db.Create(&milestone)
+		// A vote of the previous round does not count
+		db.Create(&models.MilestoneVerification{MilestoneID: milestone.ID, Round: 1, VerifierID: "old", Status: models.VerificationApproved, Independent: true})
+		for i, v := range test.votes {
+			db.Create(&models.MilestoneVerification{MilestoneID: milestone.ID, Round: 2, VerifierID: fmt.Sprint(i), Status: v.status, Independent: v.independent})
+		}
+
+		tally, err := TallyVerifications(db, &milestone, test.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if tally.Outcome != test.outcome || tally.Approvals+tally.Rejections != len(test.votes) {
+			t.Errorf("%s: %+v, expected outcome %q", test.name, tally, test.outcome)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package statemachine
+
+import (
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotEnoughSignatures blocks approving an approval below its signature threshold
+var ErrNotEnoughSignatures = errors.New("not enough signatures")
+
+// Approvals is the state machine of TransactionApproval.Status:
+//
+//	pending   -> approved, rejected, cancelled, expired
+//	approved  -> executing, cancelled, expired, pending (envelope rebuilt, signed again)
+//	executing -> executed, approved (the payment did not land)
+//	executed  -> refunding, refunded (nothing to pay back)
+//	refunding -> refunded (its refund approval executed), executed (refund approval abandoned)
+//
+// A refunding approval waits for its refund approval, a child approval of kind refund,
+// which moves it on through the effects below. A release approval, the child of an
+// escrowed payment, likewise moves its releasing milestone on.
+var Approvals = New("transaction_approval", &models.TransactionApproval{}).
+	Allow(models.ApprovalStatusApproved, models.ApprovalStatusPending, models.ApprovalStatusExecuting).
+	Allow(models.ApprovalStatusPending, models.ApprovalStatusApproved).
+	Allow(models.ApprovalStatusRejected, models.ApprovalStatusPending).
+	Allow(models.ApprovalStatusCancelled, models.ApprovalStatusPending, models.ApprovalStatusApproved).
+	Allow(models.ApprovalStatusExpired, models.ApprovalStatusPending, models.ApprovalStatusApproved).
+	Allow(models.ApprovalStatusExecuting, models.ApprovalStatusApproved).
+	Allow(models.ApprovalStatusExecuted, models.ApprovalStatusExecuting).
+	Allow(models.ApprovalStatusRefunding, models.ApprovalStatusExecuted).
+	Allow(models.ApprovalStatusRefunded, models.ApprovalStatusExecuted, models.ApprovalStatusRefunding).
+	Allow(models.ApprovalStatusExecuted, models.ApprovalStatusRefunding).
+	Guard(models.ApprovalStatusApproved, requireSignatures).
+	Effect(models.ApprovalStatusExecuted, landed(recordBudgetSpend)).
+	Effect(models.ApprovalStatusExecuted, landed(attributeFunds)).
+	Effect(models.ApprovalStatusExecuted, landed(recordWalletChange)).
+	Effect(models.ApprovalStatusExecuting, recordReleaseSubmission).
+	Effect(models.ApprovalStatusExecuted, landed(releaseMilestone)).
+	Effect(models.ApprovalStatusCancelled, abandonRelease).
+	Effect(models.ApprovalStatusRejected, abandonRelease).
+	Effect(models.ApprovalStatusExpired, abandonRelease)
+
+// The refund effects fire transitions of the parent approval, so they are added once Approvals is declared
+func init() {
+	Approvals.
+		Effect(models.ApprovalStatusExecuting, recordRefundSubmission).
+		Effect(models.ApprovalStatusExecuted, landed(settleRefund)).
+		Effect(models.ApprovalStatusCancelled, abandonRefund).
+		Effect(models.ApprovalStatusRejected, abandonRefund).
+		Effect(models.ApprovalStatusExpired, abandonRefund)
+}
+
+// landed runs effect only when the approval's transaction landed, not when a refunding
+// approval returns to executed
+func landed(effect Effect) Effect {
+	return func(tx *gorm.DB, t *Transition) error {
+		if t.From != models.ApprovalStatusExecuting {
+			return nil
+		}
+		return effect(tx, t)
+	}
+}
+
+// requireSignatures checks the approval has collected its required signatures
+func requireSignatures(tx *gorm.DB, t *Transition) error {
+	var approval models.TransactionApproval
+	if err := tx.Select("id", "required_signatures").First(&approval, t.ID).Error; err != nil {
+		return err
+	}
+
+	var count int64
+	if err := tx.Model(&models.ApprovalSignature{}).Where("approval_id = ?", t.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) < approval.RequiredSignatures {
+		return ErrNotEnoughSignatures
+	}
+	return nil
+}
+
+// recordBudgetSpend adds an executed lumen payment to its budget category
+func recordBudgetSpend(tx *gorm.DB, t *Transition) error {
+	var approval models.TransactionApproval
+	if err := tx.First(&approval, t.ID).Error; err != nil {
+		return err
+	}
+	if approval.Kind != models.ApprovalKindPayment || approval.CategoryID == 0 || !approval.Asset.IsNative() {
+		return nil
+	}
+
+	var budgetCategory models.BudgetCategory
+	if err := tx.Where("charity_id = ?", approval.CharityID).Limit(1).Find(&budgetCategory, approval.CategoryID).Error; err != nil {
+		return err
+	}
+	if budgetCategory.ID == 0 {
+		return nil
+	}
+
+	// The spending of closed periods is frozen
+	var period models.FiscalPeriod
+	if err := tx.Select("id", "status").Limit(1).Find(&period, budgetCategory.PeriodID).Error; err != nil {
+		return err
+	}
+	if period.Status == models.FiscalPeriodClosed {
+		return nil
+	}
+
+	budgetCategory.Spent += approval.Amount
+	return tx.Model(&budgetCategory).Update("spent", budgetCategory.Spent).Error
+}
+
+// attributeFunds links an executed approval to the donations that paid for it,
+// using the charity's attribution method
+func attributeFunds(tx *gorm.DB, t *Transition) error {
+	var approval models.TransactionApproval
+	if err := tx.First(&approval, t.ID).Error; err != nil {
+		return err
+	}
+	if approval.Kind != models.ApprovalKindPayment {
+		return nil
+	}
+
+	var charity models.Charity
+	if err := tx.Select("id", "attribution_method").First(&charity, approval.CharityID).Error; err != nil {
+		return err
+	}
+
+	_, err := services.AttributeFunds(tx, &approval, charity.AttributionMethod)
+	return err
+}
+
+// recordWalletChange records what an executed change to the charity wallet did: the
+// signer set of a signers approval and the accepted asset of a trustline approval
+func recordWalletChange(tx *gorm.DB, t *Transition) error {
+	var approval models.TransactionApproval
+	if err := tx.First(&approval, t.ID).Error; err != nil {
+		return err
+	}
+
+	switch approval.Kind {
+	case models.ApprovalKindSigners:
+		return tx.Model(&models.Charity{}).Where("id = ?", approval.CharityID).Updates(map[string]interface{}{
+			"signer_set":          approval.SignerSet,
+			"threshold_version":   gorm.Expr("threshold_version + 1"),
+			"signer_sync_tx_hash": approval.TxHash,
+		}).Error
+	case models.ApprovalKindTrustline:
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.CharityAsset{
+			CharityID:       approval.CharityID,
+			AssetCode:       approval.AssetCode,
+			AssetIssuer:     approval.AssetIssuer,
+			TrustlineTxHash: approval.TxHash,
+		}).Error
+	}
+	return nil
+}
+
+// releaseApproval loads the approval of t when it is a release approval, nil otherwise
+func releaseApproval(tx *gorm.DB, t *Transition) (*models.TransactionApproval, error) {
+	var approval models.TransactionApproval
+	if err := tx.First(&approval, t.ID).Error; err != nil {
+		return nil, err
+	}
+	if approval.Kind != models.ApprovalKindRelease {
+		return nil, nil
+	}
+	return &approval, nil
+}
+
+// recordReleaseSubmission stores the hash of a release approval that is being submitted
+// on its milestone, whose release_tx_hash then names the transaction to look for
+func recordReleaseSubmission(tx *gorm.DB, t *Transition) error {
+	release, err := releaseApproval(tx, t)
+	if err != nil || release == nil {
+		return err
+	}
+	return tx.Model(&models.Milestone{}).
+		Where("id = ? AND status = ?", release.MilestoneID, models.MilestoneStatusReleasing).
+		Update("release_tx_hash", release.TxHash).Error
+}
+
+// releaseMilestone releases the milestone whose escrowed funds an executed release
+// approval paid to the destination
+func releaseMilestone(tx *gorm.DB, t *Transition) error {
+	release, err := releaseApproval(tx, t)
+	if err != nil || release == nil {
+		return err
+	}
+	return Milestones.Fire(tx, Transition{
+		ID:      release.MilestoneID,
+		From:    models.MilestoneStatusReleasing,
+		To:      models.MilestoneStatusReleased,
+		ActorID: t.ActorID,
+		Reason:  fmt.Sprintf("Release approval #%d executed", release.ID),
+		Updates: map[string]interface{}{"release_tx_hash": release.TxHash},
+	})
+}
+
+// abandonRelease returns the milestone of a release approval that will not be executed
+// to verified, so its funds can be released again
+func abandonRelease(tx *gorm.DB, t *Transition) error {
+	release, err := releaseApproval(tx, t)
+	if err != nil || release == nil {
+		return err
+	}
+	return Milestones.Fire(tx, Transition{
+		ID:      release.MilestoneID,
+		From:    models.MilestoneStatusReleasing,
+		To:      models.MilestoneStatusVerified,
+		ActorID: t.ActorID,
+		Reason:  fmt.Sprintf("Release approval #%d was %s", release.ID, t.To),
+		Updates: map[string]interface{}{"release_tx_hash": ""},
+	})
+}
+
+// refundApproval loads the approval of t when it is a refund approval, nil otherwise
+func refundApproval(tx *gorm.DB, t *Transition) (*models.TransactionApproval, error) {
+	var approval models.TransactionApproval
+	if err := tx.First(&approval, t.ID).Error; err != nil {
+		return nil, err
+	}
+	if approval.Kind != models.ApprovalKindRefund {
+		return nil, nil
+	}
+	return &approval, nil
+}
+
+// recordRefundSubmission stores the hash of a refund approval that is being submitted on
+// its parent, whose refund_tx_hash then names the transaction to look for on the network
+func recordRefundSubmission(tx *gorm.DB, t *Transition) error {
+	refund, err := refundApproval(tx, t)
+	if err != nil || refund == nil {
+		return err
+	}
+	return tx.Model(&models.TransactionApproval{}).
+		Where("id = ? AND status = ?", refund.ParentID, models.ApprovalStatusRefunding).
+		Update("refund_tx_hash", refund.TxHash).Error
+}
+
+// settleRefund marks the parent of an executed refund approval refunded, stamps its
+// refunds with the transaction hash and takes them off the donors' tax reports
+func settleRefund(tx *gorm.DB, t *Transition) error {
+	refund, err := refundApproval(tx, t)
+	if err != nil || refund == nil {
+		return err
+	}
+
+	var refunds []models.Refund
+	if err := tx.Where("approval_id = ? AND tx_hash = ''", refund.ParentID).Order("id").Find(&refunds).Error; err != nil {
+		return err
+	}
+	for i := range refunds {
+		var donation models.Donation
+		if err := tx.Unscoped().Select("id", "created_at").First(&donation, refunds[i].DonationID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&refunds[i]).Update("tx_hash", refund.TxHash).Error; err != nil {
+			return err
+		}
+		if err := services.ApplyRefundToTaxReport(tx, &refunds[i], donation.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	return Approvals.Fire(tx, Transition{
+		ID:      refund.ParentID,
+		From:    models.ApprovalStatusRefunding,
+		To:      models.ApprovalStatusRefunded,
+		ActorID: t.ActorID,
+		Reason:  fmt.Sprintf("Refund approval #%d executed: %s to %d donations", refund.ID, refund.Amount, len(refunds)),
+		Updates: map[string]interface{}{"refund_tx_hash": refund.TxHash},
+	})
+}
+
+// abandonRefund returns the parent of a refund approval that will not be executed to
+// executed, so it can be refunded again. Its pending refunds are deleted and the refund
+// proposal that was accepted for it is opened again.
+func abandonRefund(tx *gorm.DB, t *Transition) error {
+	refund, err := refundApproval(tx, t)
+	if err != nil || refund == nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Where("approval_id = ? AND tx_hash = ''", refund.ParentID).Delete(&models.Refund{}).Error; err != nil {
+		return err
+	}
+	err = tx.Model(&models.RefundProposal{}).
+		Where("approval_id = ? AND status = ?", refund.ParentID, models.RefundProposalAccepted).
+		Updates(map[string]interface{}{"status": models.RefundProposalOpen, "decided_by_id": "", "decided_at": nil}).Error
+	if err != nil {
+		return err
+	}
+
+	return Approvals.Fire(tx, Transition{
+		ID:      refund.ParentID,
+		From:    models.ApprovalStatusRefunding,
+		To:      models.ApprovalStatusExecuted,
+		ActorID: t.ActorID,
+		Reason:  fmt.Sprintf("Refund approval #%d was %s", refund.ID, t.To),
+		Updates: map[string]interface{}{"refund_tx_hash": ""},
+	})
+}
@@ -0,0 +1,158 @@
+// Package statemachine declares the allowed status transitions of transaction
+// approvals and milestones. Every transition is applied with a compare-and-set
+// on the current status and recorded as a models.StatusTransition.
+package statemachine
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrIllegalTransition is returned for a transition the machine does not allow
+	ErrIllegalTransition = errors.New("illegal status transition")
+	// ErrStaleStatus is returned when the record's status changed since it was loaded
+	ErrStaleStatus = errors.New("status was changed concurrently")
+)
+
+// SystemActor is the actor ID recorded for transitions made by background jobs
+const SystemActor = "system"
+
+// TransitionError is returned when a transition is not applied. Err is
+// ErrIllegalTransition, ErrStaleStatus or the error of a failed guard.
+type TransitionError struct {
+	Entity string
+	From   string
+	To     string
+	Err    error
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s cannot move from %s to %s: %v", e.Entity, e.From, e.To, e.Err)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+// Transition is a status change of one record
+type Transition struct {
+	ID      uint
+	From    string
+	To      string
+	ActorID string
+	Reason  string
+	Updates map[string]interface{} // Other columns to set together with the status
+}
+
+// Guard decides whether a transition may happen. A non-nil error blocks it.
+type Guard func(tx *gorm.DB, t *Transition) error
+
+// Effect runs after the status is updated, in the same database transaction
+type Effect func(tx *gorm.DB, t *Transition) error
+
+type edge struct {
+	from string
+	to   string
+}
+
+// Machine holds the transitions, guards and effects of one model's status
+type Machine struct {
+	entity  string
+	model   interface{}
+	allowed map[edge]bool
+	guards  map[string][]Guard
+	effects map[string][]Effect
+}
+
+// New creates a machine for the status column of model's table
+func New(entity string, model interface{}) *Machine {
+	return &Machine{
+		entity:  entity,
+		model:   model,
+		allowed: make(map[edge]bool),
+		guards:  make(map[string][]Guard),
+		effects: make(map[string][]Effect),
+	}
+}
+
+// Entity is the name recorded in StatusTransition.Entity
+func (m *Machine) Entity() string {
+	return m.entity
+}
+
+// Allow permits moving to a status from each of the given statuses
+func (m *Machine) Allow(to string, from ...string) *Machine {
+	for _, status := range from {
+		m.allowed[edge{status, to}] = true
+	}
+	return m
+}
+
+// Guard adds a check run before every transition into a status
+func (m *Machine) Guard(to string, guard Guard) *Machine {
+	m.guards[to] = append(m.guards[to], guard)
+	return m
+}
+
+// Effect adds a side effect run after every transition into a status
+func (m *Machine) Effect(to string, effect Effect) *Machine {
+	m.effects[to] = append(m.effects[to], effect)
+	return m
+}
+
+// Can reports whether the machine allows moving from one status to another
+func (m *Machine) Can(from, to string) bool {
+	return m.allowed[edge{from, to}]
+}
+
+// Fire applies a transition: it checks it is allowed, runs the guards, updates
+// the status if it is still t.From, runs the effects and records the history,
+// all in one database transaction
+func (m *Machine) Fire(db *gorm.DB, t Transition) error {
+	if !m.Can(t.From, t.To) {
+		return m.fail(t, ErrIllegalTransition)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, guard := range m.guards[t.To] {
+			if err := guard(tx, &t); err != nil {
+				return m.fail(t, err)
+			}
+		}
+
+		updates := map[string]interface{}{"status": t.To}
+		for column, value := range t.Updates {
+			updates[column] = value
+		}
+		result := tx.Model(m.model).Where("id = ? AND status = ?", t.ID, t.From).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return m.fail(t, ErrStaleStatus)
+		}
+
+		for _, effect := range m.effects[t.To] {
+			if err := effect(tx, &t); err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.StatusTransition{
+			Entity:     m.entity,
+			EntityID:   t.ID,
+			FromStatus: t.From,
+			ToStatus:   t.To,
+			ActorID:    t.ActorID,
+			Reason:     t.Reason,
+		}).Error
+	})
+}
+
+func (m *Machine) fail(t Transition, err error) error {
+	return &TransitionError{Entity: m.entity, From: t.From, To: t.To, Err: err}
+}
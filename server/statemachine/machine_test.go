@@ -0,0 +1,204 @@
+package statemachine
+
+import (
+	"cleargive/server/models"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func openMachineDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "machine.db")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.TransactionApproval{}, &models.ApprovalSignature{}, &models.Milestone{}, &models.StatusTransition{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// history returns the recorded transitions of an entity, oldest first
+func history(t *testing.T, db *gorm.DB, entity string, id uint) []models.StatusTransition {
+	t.Helper()
+	var transitions []models.StatusTransition
+	if err := db.Where("entity = ? AND entity_id = ?", entity, id).Order("id").Find(&transitions).Error; err != nil {
+		t.Fatal(err)
+	}
+	return transitions
+}
+
+func TestApprovalTransitions(t *testing.T) {
+	for _, test := range []struct {
+		from, to string
+		allowed  bool
+	}{
+		{models.ApprovalStatusPending, models.ApprovalStatusApproved, true},
+		{models.ApprovalStatusPending, models.ApprovalStatusExecuting, false},
+		{models.ApprovalStatusApproved, models.ApprovalStatusExecuting, true},
+		{models.ApprovalStatusApproved, models.ApprovalStatusPending, true},
+		{models.ApprovalStatusApproved, models.ApprovalStatusExecuted, false},
+		{models.ApprovalStatusExecuting, models.ApprovalStatusExecuted, true},
+		{models.ApprovalStatusExecuting, models.ApprovalStatusApproved, true},
+		{models.ApprovalStatusExecuting, models.ApprovalStatusCancelled, false},
+		{models.ApprovalStatusExecuted, models.ApprovalStatusRefunding, true},
+		{models.ApprovalStatusExecuted, models.ApprovalStatusPending, false},
+		{models.ApprovalStatusRefunding, models.ApprovalStatusRefunded, true},
+		{models.ApprovalStatusRefunding, models.ApprovalStatusExecuted, true},
+		{models.ApprovalStatusRefunded, models.ApprovalStatusExecuted, false},
+		{models.ApprovalStatusCancelled, models.ApprovalStatusPending, false},
+	} {
+		if got := Approvals.Can(test.from, test.to); got != test.allowed {
+			t.Errorf("%s -> %s: allowed %v, expected %v", test.from, test.to, got, test.allowed)
+		}
+	}
+}
+
+func TestMilestoneTransitions(t *testing.T) {
+	for _, test := range []struct {
+		from, to string
+		allowed  bool
+	}{
+		{models.MilestoneStatusPending, models.MilestoneStatusCompleted, true},
+		{models.MilestoneStatusCompleted, models.MilestoneStatusVerified, true},
+		{models.MilestoneStatusCompleted, models.MilestoneStatusPending, true},
+		{models.MilestoneStatusPending, models.MilestoneStatusVerified, false},
+		{models.MilestoneStatusVerified, models.MilestoneStatusReleasing, true},
+		{models.MilestoneStatusReleasing, models.MilestoneStatusReleased, true},
+		{models.MilestoneStatusReleasing, models.MilestoneStatusVerified, true},
+		{models.MilestoneStatusReleasing, models.MilestoneStatusCancelled, false},
+		{models.MilestoneStatusReleased, models.MilestoneStatusCancelled, false},
+		{models.MilestoneStatusCancelled, models.MilestoneStatusPending, false},
+	} {
+		if got := Milestones.Can(test.from, test.to); got != test.allowed {
+			t.Errorf("%s -> %s: allowed %v, expected %v", test.from, test.to, got, test.allowed)
+		}
+	}
+}
+
+func TestFireAppliesAndRecordsTransition(t *testing.T) {
+	db := openMachineDB(t)
+	approval := models.TransactionApproval{Status: models.ApprovalStatusPending}
+	db.Create(&approval)
+
+	err := Approvals.Fire(db, Transition{
+		ID:      approval.ID,
+		From:    models.ApprovalStatusPending,
+		To:      models.ApprovalStatusCancelled,
+		ActorID: "user-1",
+		Reason:  "No longer needed",
+		Updates: map[string]interface{}{"description": "cancelled by requester"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusCancelled || approval.Description != "cancelled by requester" {
+		t.Fatalf("approval is %s with description %q", approval.Status, approval.Description)
+	}
+	transitions := history(t, db, Approvals.Entity(), approval.ID)
+	if len(transitions) != 1 || transitions[0].FromStatus != models.ApprovalStatusPending ||
+		transitions[0].ToStatus != models.ApprovalStatusCancelled || transitions[0].ActorID != "user-1" {
+		t.Fatalf("recorded %+v", transitions)
+	}
+}
+
+func TestFireRejectsTransition(t *testing.T) {
+	db := openMachineDB(t)
+	approval := models.TransactionApproval{Status: models.ApprovalStatusPending, RequiredSignatures: 2}
+	db.Create(&approval)
+	db.Create(&models.ApprovalSignature{ApprovalID: approval.ID})
+
+	for _, test := range []struct {
+		name     string
+		from, to string
+		err      error
+	}{
+		{"illegal", models.ApprovalStatusPending, models.ApprovalStatusExecuted, ErrIllegalTransition},
+		{"guard", models.ApprovalStatusPending, models.ApprovalStatusApproved, ErrNotEnoughSignatures},
+	} {
+		err := Approvals.Fire(db, Transition{ID: approval.ID, From: test.from, To: test.to, ActorID: SystemActor})
+		var transitionErr *TransitionError
+		if !errors.As(err, &transitionErr) || !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+
+	db.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusPending {
+		t.Fatalf("rejected transitions moved the approval to %s", approval.Status)
+	}
+	if transitions := history(t, db, Approvals.Entity(), approval.ID); len(transitions) != 0 {
+		t.Fatalf("rejected transitions were recorded: %+v", transitions)
+	}
+}
+
+func TestFireConflict(t *testing.T) {
+	db := openMachineDB(t)
+	approval := models.TransactionApproval{Status: models.ApprovalStatusPending}
+	db.Create(&approval)
+
+	// Two requests loaded the approval while it was pending, the second one loses
+	cancel := Transition{ID: approval.ID, From: models.ApprovalStatusPending, To: models.ApprovalStatusCancelled, ActorID: "user-1"}
+	expire := Transition{ID: approval.ID, From: models.ApprovalStatusPending, To: models.ApprovalStatusExpired, ActorID: SystemActor}
+	if err := Approvals.Fire(db, cancel); err != nil {
+		t.Fatal(err)
+	}
+	if err := Approvals.Fire(db, expire); !errors.Is(err, ErrStaleStatus) {
+		t.Fatalf("expected ErrStaleStatus, got %v", err)
+	}
+
+	db.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusCancelled {
+		t.Fatalf("approval is %s", approval.Status)
+	}
+	if transitions := history(t, db, Approvals.Entity(), approval.ID); len(transitions) != 1 {
+		t.Fatalf("recorded %d transitions", len(transitions))
+	}
+}
+
+func TestFailedEffectRollsBack(t *testing.T) {
+	db := openMachineDB(t)
+	approval := models.TransactionApproval{Status: models.ApprovalStatusPending}
+	db.Create(&approval)
+
+	failure := errors.New("effect failed")
+	machine := New("transaction_approval", &models.TransactionApproval{}).
+		Allow(models.ApprovalStatusCancelled, models.ApprovalStatusPending).
+		Effect(models.ApprovalStatusCancelled, func(tx *gorm.DB, t *Transition) error { return failure })
+
+	err := machine.Fire(db, Transition{ID: approval.ID, From: models.ApprovalStatusPending, To: models.ApprovalStatusCancelled})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the effect's error, got %v", err)
+	}
+	db.First(&approval, approval.ID)
+	if approval.Status != models.ApprovalStatusPending {
+		t.Fatalf("approval is %s", approval.Status)
+	}
+}
+
+func TestAbandonedReleaseReturnsMilestoneToVerified(t *testing.T) {
+	db := openMachineDB(t)
+	milestone := models.Milestone{Status: models.MilestoneStatusReleasing, ReleaseTxHash: "abc"}
+	db.Create(&milestone)
+	release := models.TransactionApproval{Kind: models.ApprovalKindRelease, MilestoneID: milestone.ID, Status: models.ApprovalStatusPending}
+	db.Create(&release)
+
+	err := Approvals.Fire(db, Transition{ID: release.ID, From: models.ApprovalStatusPending, To: models.ApprovalStatusRejected, ActorID: "user-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.First(&milestone, milestone.ID)
+	if milestone.Status != models.MilestoneStatusVerified || milestone.ReleaseTxHash != "" {
+		t.Fatalf("milestone is %s with release hash %q", milestone.Status, milestone.ReleaseTxHash)
+	}
+	if transitions := history(t, db, Milestones.Entity(), milestone.ID); len(transitions) != 1 || transitions[0].ActorID != "user-2" {
+		t.Fatalf("recorded %+v", transitions)
+	}
+}
@@ -0,0 +1,51 @@
+package statemachine
+
+import (
+	"cleargive/server/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrApprovalNotExecuted blocks releasing milestone funds before the approval's payment is made
+var ErrApprovalNotExecuted = errors.New("transaction approval has not been executed")
+
+// Milestones is the state machine of Milestone.Status:
+//
+//	pending   -> overdue, completed, cancelled
+//	overdue   -> completed, cancelled
+//	completed -> verified, pending (verification rejected), cancelled
+//	verified  -> released, releasing, cancelled
+//	releasing -> released (its release approval executed), verified (release approval abandoned)
+//
+// The escrowed funds of a milestone are paid out by a release approval; the milestone
+// is releasing while it waits for signatures and the network, which the effects of
+// Approvals settle.
+var Milestones = New("milestone", &models.Milestone{}).
+	Allow(models.MilestoneStatusOverdue, models.MilestoneStatusPending).
+	Allow(models.MilestoneStatusCompleted, models.MilestoneStatusPending, models.MilestoneStatusOverdue).
+	Allow(models.MilestoneStatusVerified, models.MilestoneStatusCompleted).
+	Allow(models.MilestoneStatusPending, models.MilestoneStatusCompleted).
+	Allow(models.MilestoneStatusReleasing, models.MilestoneStatusVerified).
+	Allow(models.MilestoneStatusReleased, models.MilestoneStatusVerified, models.MilestoneStatusReleasing).
+	Allow(models.MilestoneStatusVerified, models.MilestoneStatusReleasing).
+	Allow(models.MilestoneStatusCancelled, models.MilestoneStatusPending, models.MilestoneStatusOverdue, models.MilestoneStatusCompleted, models.MilestoneStatusVerified).
+	Guard(models.MilestoneStatusReleasing, requireExecutedApproval).
+	Guard(models.MilestoneStatusReleased, requireExecutedApproval)
+
+// requireExecutedApproval checks the milestone's approval has been paid out
+func requireExecutedApproval(tx *gorm.DB, t *Transition) error {
+	var milestone models.Milestone
+	if err := tx.Select("id", "approval_id").First(&milestone, t.ID).Error; err != nil {
+		return err
+	}
+
+	var approval models.TransactionApproval
+	if err := tx.Select("id", "status").First(&approval, milestone.ApprovalID).Error; err != nil {
+		return err
+	}
+	if approval.Status != models.ApprovalStatusExecuted {
+		return ErrApprovalNotExecuted
+	}
+	return nil
+}
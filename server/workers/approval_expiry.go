@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/statemachine"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// approvalExpiryInterval is how often stale approvals are expired
+const approvalExpiryInterval = time.Minute
+
+// ExpireApprovals marks pending and approved transaction approvals that are past
+// their expiry as expired. It blocks until ctx is cancelled.
+func ExpireApprovals(ctx context.Context) {
+	ticker := time.NewTicker(approvalExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		if expired, err := expireStaleApprovals(time.Now()); err != nil {
+			log.Printf("approval expiry: %v", err)
+		} else if expired > 0 {
+			log.Printf("approval expiry: expired %d approvals", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func expireStaleApprovals(now time.Time) (int, error) {
+	var approvals []models.TransactionApproval
+	err := config.DB.Select("id", "status").
+		Where("status IN ? AND expires_at IS NOT NULL AND expires_at <= ?", []string{models.ApprovalStatusPending, models.ApprovalStatusApproved}, now).
+		Find(&approvals).Error
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, approval := range approvals {
+		err := statemachine.Approvals.Fire(config.DB, statemachine.Transition{
+			ID:      approval.ID,
+			From:    approval.Status,
+			To:      models.ApprovalStatusExpired,
+			ActorID: statemachine.SystemActor,
+			Reason:  "Approval window elapsed",
+		})
+		switch {
+		case err == nil:
+			expired++
+		case errors.Is(err, statemachine.ErrStaleStatus):
+			// Signed, executed or cancelled since it was loaded
+		default:
+			return expired, err
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,91 @@
+package workers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"cleargive/server/statemachine"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	// approvalReconcileInterval is how often executing approvals are looked up on the network
+	approvalReconcileInterval = time.Minute
+	// executingGracePeriod leaves a submission that is still in progress alone
+	executingGracePeriod = time.Minute
+)
+
+// ReconcileApprovals settles transaction approvals left executing because the response
+// to their payment was lost. Payments found on the network are marked executed, payments
+// that can no longer land return the approval to approved. A refund approval marked
+// executed here marks the payment it refunds refunded, a release approval releases its
+// milestone. It blocks until ctx is cancelled.
+func ReconcileApprovals(ctx context.Context) {
+	ticker := time.NewTicker(approvalReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		if settled, err := reconcileExecutingApprovals(time.Now()); err != nil {
+			log.Printf("approval reconciler: %v", err)
+		} else if settled > 0 {
+			log.Printf("approval reconciler: settled %d approvals", settled)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func reconcileExecutingApprovals(now time.Time) (int, error) {
+	var approvals []models.TransactionApproval
+	err := config.DB.Select("id", "status", "envelope_xdr", "tx_hash").
+		Where("status = ? AND updated_at <= ?", models.ApprovalStatusExecuting, now.Add(-executingGracePeriod)).
+		Find(&approvals).Error
+	if err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for _, approval := range approvals {
+		outcome, err := services.EnvelopeOutcome(approval.EnvelopeXDR)
+		if err != nil {
+			log.Printf("approval reconciler: approval #%d: %v", approval.ID, err)
+			continue
+		}
+
+		transition := statemachine.Transition{
+			ID:      approval.ID,
+			From:    approval.Status,
+			ActorID: statemachine.SystemActor,
+		}
+		switch outcome {
+		case services.EnvelopeLanded:
+			transition.To = models.ApprovalStatusExecuted
+			transition.Reason = "Payment found on the network"
+			transition.Updates = map[string]interface{}{"executed_at": now}
+		case services.EnvelopeFailed:
+			transition.To = models.ApprovalStatusApproved
+			transition.Reason = "Payment can no longer land on the network"
+			transition.Updates = map[string]interface{}{"tx_hash": ""}
+		default:
+			continue
+		}
+
+		err = statemachine.Approvals.Fire(config.DB, transition)
+		switch {
+		case err == nil:
+			settled++
+		case errors.Is(err, statemachine.ErrStaleStatus):
+			// Settled by the request that submitted it
+		default:
+			return settled, err
+		}
+	}
+	return settled, nil
+}
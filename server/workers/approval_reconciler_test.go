@@ -0,0 +1,153 @@
+package workers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"cleargive/server/services/horizontest"
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+)
+
+func TestReconcileExecutingApprovals(t *testing.T) {
+	useTestDB(t)
+	fake := horizontest.New()
+	previous := services.Horizon
+	services.Horizon = fake
+	t.Cleanup(func() { services.Horizon = previous })
+
+	wallet := keypair.MustRandom()
+	fake.CreateAccount(wallet.Address())
+	charity := models.Charity{Name: "Charity", WalletAddress: wallet.Address()}
+	if err := config.DB.Create(&charity).Error; err != nil {
+		t.Fatal(err)
+	}
+	stored, err := services.EncryptSecret(wallet.Seed(), services.SecretRow("charities", charity.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB.Model(&charity).Update("wallet_secret", stored)
+	secret := services.CharityWalletSecret(&charity)
+
+	executing := func() models.TransactionApproval {
+		tx, err := services.BuildPayment(wallet.Address(), keypair.MustRandom().Address(), 10_000_000, models.NativeAsset(), services.ApprovalEnvelopeTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope, hash, err := services.EncodeEnvelope(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		approval := models.TransactionApproval{
+			CharityID:   charity.ID,
+			Amount:      10_000_000,
+			Asset:       models.NativeAsset(),
+			Status:      models.ApprovalStatusExecuting,
+			EnvelopeXDR: envelope,
+			TxHash:      hash,
+		}
+		if err := config.DB.Create(&approval).Error; err != nil {
+			t.Fatal(err)
+		}
+		return approval
+	}
+
+	// Landed, but the response was lost
+	landed := executing()
+	if _, err := services.SubmitWithSignatures(landed.EnvelopeXDR, nil, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// Never landed, and another transaction has used its sequence number since
+	lost := executing()
+	if _, err := services.SendPayment(secret, keypair.MustRandom().Address(), 10_000_000, models.NativeAsset()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not landed yet and could still land
+	inFlight := executing()
+
+	// Submissions in progress are left alone
+	if settled, err := reconcileExecutingApprovals(time.Now()); err != nil || settled != 0 {
+		t.Fatalf("within the grace period: settled %d, err %v", settled, err)
+	}
+
+	settled, err := reconcileExecutingApprovals(time.Now().Add(2 * executingGracePeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settled != 2 {
+		t.Fatalf("settled %d approvals, want 2", settled)
+	}
+
+	for _, want := range []struct {
+		approval models.TransactionApproval
+		status   string
+	}{
+		{landed, models.ApprovalStatusExecuted},
+		{lost, models.ApprovalStatusApproved},
+		{inFlight, models.ApprovalStatusExecuting},
+	} {
+		var approval models.TransactionApproval
+		config.DB.First(&approval, want.approval.ID)
+		if approval.Status != want.status {
+			t.Errorf("approval #%d: status %s, want %s", approval.ID, approval.Status, want.status)
+		}
+	}
+}
+
+func TestReconcilerSettlesRefundWhoseResponseWasLost(t *testing.T) {
+	useTestDB(t)
+	fake := horizontest.New()
+	previous := services.Horizon
+	services.Horizon = fake
+	t.Cleanup(func() { services.Horizon = previous })
+
+	wallet := keypair.MustRandom()
+	fake.CreateAccount(wallet.Address())
+	charity := models.Charity{Name: "Charity", WalletAddress: wallet.Address()}
+	config.DB.Create(&charity)
+	stored, err := services.EncryptSecret(wallet.Seed(), services.SecretRow("charities", charity.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB.Model(&charity).Update("wallet_secret", stored)
+
+	donor := keypair.MustRandom().Address()
+	tx, err := services.BuildPayouts(wallet.Address(), models.NativeAsset(), []services.Payout{{Destination: donor, Amount: 10_000_000}}, services.ApprovalEnvelopeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, hash, err := services.EncodeEnvelope(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment := models.TransactionApproval{CharityID: charity.ID, Kind: models.ApprovalKindPayment, Amount: 10_000_000, Asset: models.NativeAsset(),
+		Status: models.ApprovalStatusRefunding, RefundTxHash: hash}
+	config.DB.Create(&payment)
+	refund := models.TransactionApproval{CharityID: charity.ID, Kind: models.ApprovalKindRefund, ParentID: payment.ID, Amount: 10_000_000,
+		Asset: models.NativeAsset(), Status: models.ApprovalStatusExecuting, EnvelopeXDR: envelope, TxHash: hash}
+	config.DB.Create(&refund)
+	donation := models.Donation{Amount: 10_000_000, Asset: models.NativeAsset(), CharityID: charity.ID, TxHash: "donation", SourceAccount: donor}
+	config.DB.Create(&donation)
+	config.DB.Create(&models.Refund{ApprovalID: payment.ID, DonationID: donation.ID, Destination: donor, Amount: 10_000_000, Asset: models.NativeAsset()})
+
+	// The refund landed, but the response was lost
+	if _, err := services.SubmitWithSignatures(envelope, nil, services.CharityWalletSecret(&charity)); err != nil {
+		t.Fatal(err)
+	}
+	if settled, err := reconcileExecutingApprovals(time.Now().Add(2 * executingGracePeriod)); err != nil || settled != 1 {
+		t.Fatalf("settled %d, err %v", settled, err)
+	}
+
+	var settledRefund models.Refund
+	config.DB.Where("approval_id = ?", payment.ID).First(&settledRefund)
+	config.DB.First(&payment, payment.ID)
+	config.DB.First(&refund, refund.ID)
+	if refund.Status != models.ApprovalStatusExecuted || payment.Status != models.ApprovalStatusRefunded || settledRefund.TxHash != hash {
+		t.Fatalf("refund approval %s, payment %s, refund tx %q", refund.Status, payment.Status, settledRefund.TxHash)
+	}
+}
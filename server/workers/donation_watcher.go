@@ -0,0 +1,178 @@
+package workers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// charityRefreshInterval is how often new charities are picked up
+	charityRefreshInterval = time.Minute
+	// streamRetryDelay is how long a wallet stream waits before reconnecting after an error
+	streamRetryDelay = 10 * time.Second
+)
+
+// WatchDonations streams payments to every charity wallet and records the ones that
+// are not yet known as confirmed donations. Each wallet resumes from its stored
+// cursor, so payments made while the server was down are ingested on restart.
+// It blocks until ctx is cancelled.
+func WatchDonations(ctx context.Context) {
+	var wg sync.WaitGroup
+	watching := make(map[uint]bool)
+
+	ticker := time.NewTicker(charityRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		var charities []models.Charity
+		if err := config.DB.Select("id", "wallet_address").Where("wallet_address <> ''").Find(&charities).Error; err != nil {
+			log.Printf("donation watcher: could not load charities: %v", err)
+		}
+
+		for _, charity := range charities {
+			if watching[charity.ID] {
+				continue
+			}
+			watching[charity.ID] = true
+
+			wg.Add(1)
+			go func(charityID uint, wallet string) {
+				defer wg.Done()
+				watchWallet(ctx, charityID, wallet)
+			}(charity.ID, charity.WalletAddress)
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchWallet streams one charity wallet, reconnecting after errors
+func watchWallet(ctx context.Context, charityID uint, wallet string) {
+	for {
+		cursor, err := loadCursor(charityID)
+		if err == nil {
+			err = services.Horizon.StreamPayments(ctx, wallet, cursor, func(payment services.Payment) error {
+				return ingestPayment(charityID, wallet, payment)
+			})
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("donation watcher: charity %d: %v", charityID, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(streamRetryDelay):
+		}
+	}
+}
+
+func loadCursor(charityID uint) (string, error) {
+	var cursor models.IngestCursor
+	err := config.DB.Where("charity_id = ?", charityID).Limit(1).Find(&cursor).Error
+	return cursor.Cursor, err
+}
+
+// ingestPayment records an incoming payment as a donation and advances the cursor
+// in the same transaction
+func ingestPayment(charityID uint, wallet string, payment services.Payment) error {
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		if payment.To == wallet && payment.From != wallet && payment.Successful {
+			if err := recordIngestedDonation(tx, charityID, payment); err != nil {
+				return err
+			}
+		}
+
+		cursor := models.IngestCursor{CharityID: charityID, Cursor: payment.PagingToken}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "charity_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"cursor", "updated_at"}),
+		}).Create(&cursor).Error
+	})
+}
+
+func recordIngestedDonation(tx *gorm.DB, charityID uint, payment services.Payment) error {
+	// Donations are keyed by their payment; those recorded before payment IDs by their transaction
+	var count int64
+	if err := tx.Model(&models.Donation{}).Unscoped().
+		Where("payment_id = ? OR (payment_id = '' AND tx_hash = ?)", payment.ID, payment.TxHash).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// Payments the server made itself, from an escrow account or a charity wallet,
+	// move funds the platform already holds and are not donations
+	fromServer, err := serverAccount(tx, payment.From)
+	if err != nil || fromServer {
+		return err
+	}
+
+	// Payments in assets the charity does not accept are not donations
+	accepted, err := services.CharityAcceptsAsset(tx, charityID, payment.Asset())
+	if err != nil || !accepted {
+		return err
+	}
+
+	paid, err := models.ParseMoney(payment.Amount)
+	if err != nil {
+		return err
+	}
+
+	donation := models.Donation{
+		Amount:        paid,
+		Asset:         payment.Asset(),
+		CharityID:     charityID,
+		TxHash:        payment.TxHash,
+		PaymentID:     payment.ID,
+		Status:        services.DonationStatusConfirmed,
+		SourceAccount: payment.From,
+		Message:       "Received on the Stellar network",
+	}
+
+	// Attribute the donation when the sending wallet belongs to a user
+	var donor models.User
+	if err := tx.Where("public_key = ?", payment.From).Limit(1).Find(&donor).Error; err != nil {
+		return err
+	}
+	donation.DonorID = donor.FirebaseID
+
+	if err := tx.Create(&donation).Error; err != nil {
+		return err
+	}
+	return services.AddDonationToTotals(tx, &donation)
+}
+
+// serverAccount reports whether address is an account the server holds a key of: the
+// escrow account of an escrowed payment or a charity wallet
+func serverAccount(tx *gorm.DB, address string) (bool, error) {
+	var escrows int64
+	if err := tx.Model(&models.TransactionApproval{}).Unscoped().Where("escrow_address = ?", address).Count(&escrows).Error; err != nil {
+		return false, err
+	}
+	if escrows > 0 {
+		return true, nil
+	}
+
+	var count int64
+	if err := tx.Model(&models.Charity{}).Unscoped().Where("wallet_address = ?", address).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,105 @@
+package workers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"path/filepath"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+)
+
+// testMasterKeys is the SECRET_MASTER_KEYS wallet secrets are encrypted with
+const testMasterKeys = "k1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+// useTestDB points config.DB at a migrated database in a temporary directory
+func useTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("SECRET_MASTER_KEYS", testMasterKeys)
+	db, err := config.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.TaxReport{}, &models.TaxReportAsset{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.MigrateDB(db); err != nil {
+		t.Fatal(err)
+	}
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() { config.DB = previous })
+}
+
+func TestIngestSkipsPaymentsMadeByTheServer(t *testing.T) {
+	useTestDB(t)
+
+	charity := models.Charity{Name: "Receiving", WalletAddress: keypair.MustRandom().Address(), WalletSecret: "a"}
+	other := models.Charity{Name: "Paying", WalletAddress: keypair.MustRandom().Address(), WalletSecret: "b"}
+	for _, c := range []*models.Charity{&charity, &other} {
+		if err := config.DB.Create(c).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	escrow := models.TransactionApproval{CharityID: other.ID, Kind: models.ApprovalKindPayment, Escrowed: true, EscrowAddress: keypair.MustRandom().Address()}
+	if err := config.DB.Create(&escrow).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	payments := []struct {
+		name     string
+		from     string
+		recorded bool
+	}{
+		{"escrow refund", escrow.EscrowAddress, false},
+		{"charity wallet", other.WalletAddress, false},
+		{"donor", keypair.MustRandom().Address(), true},
+	}
+	for i, p := range payments {
+		payment := services.Payment{
+			PagingToken: string(rune('1' + i)),
+			TxHash:      p.name,
+			Successful:  true,
+			From:        p.from,
+			To:          charity.WalletAddress,
+			Amount:      "10.0000000",
+			AssetCode:   models.NativeAssetCode,
+		}
+		if err := ingestPayment(charity.ID, charity.WalletAddress, payment); err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+
+		var count int64
+		config.DB.Model(&models.Donation{}).Where("tx_hash = ?", p.name).Count(&count)
+		if recorded := count > 0; recorded != p.recorded {
+			t.Errorf("%s: recorded %v, want %v", p.name, recorded, p.recorded)
+		}
+	}
+}
+
+func TestIngestRecordsEveryPaymentOfATransaction(t *testing.T) {
+	useTestDB(t)
+
+	charity := models.Charity{Name: "Receiving", WalletAddress: keypair.MustRandom().Address(), WalletSecret: "a"}
+	if err := config.DB.Create(&charity).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// One transaction pays the charity twice, the first payment is streamed again after a reconnect
+	donor := keypair.MustRandom().Address()
+	first := services.Payment{ID: "101", PagingToken: "101", TxHash: "batch", Successful: true, From: donor, To: charity.WalletAddress, Amount: "10.0000000", AssetCode: models.NativeAssetCode}
+	second := first
+	second.ID, second.PagingToken, second.Amount = "102", "102", "5.0000000"
+	for _, payment := range []services.Payment{first, second, first} {
+		if err := ingestPayment(charity.ID, charity.WalletAddress, payment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var donations []models.Donation
+	config.DB.Where("tx_hash = ?", "batch").Order("id").Find(&donations)
+	if len(donations) != 2 || donations[0].PaymentID != "101" || donations[1].PaymentID != "102" || donations[1].Amount != 50_000_000 {
+		t.Fatalf("donations of the transaction: %+v", donations)
+	}
+}
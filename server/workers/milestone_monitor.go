@@ -0,0 +1,196 @@
+package workers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"cleargive/server/services"
+	"cleargive/server/statemachine"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultOverdueGracePeriod is how long a milestone can stay overdue before a
+// refund of its approval's unspent funds is proposed
+const DefaultOverdueGracePeriod = 14 * 24 * time.Hour
+
+// milestoneMonitorInterval is how often milestones are checked against their due dates
+const milestoneMonitorInterval = time.Hour
+
+// Clock tells the current time. It is injected so the monitor can be run against a fixed time.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// MilestoneMonitor marks milestones that are past their due date as overdue,
+// notifies the charity's signers and proposes a refund once the grace period is over
+type MilestoneMonitor struct {
+	DB          *gorm.DB
+	Clock       Clock
+	GracePeriod time.Duration
+}
+
+// MonitorResult counts what one scan changed
+type MonitorResult struct {
+	Overdue   int
+	Proposals int
+}
+
+// NewMilestoneMonitor creates a monitor on the application database.
+//
+// MILESTONE_GRACE_PERIOD overrides the grace period, e.g. "336h".
+func NewMilestoneMonitor() *MilestoneMonitor {
+	grace := DefaultOverdueGracePeriod
+	if value := os.Getenv("MILESTONE_GRACE_PERIOD"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed >= 0 {
+			grace = parsed
+		} else {
+			log.Printf("milestone monitor: invalid MILESTONE_GRACE_PERIOD %q, using %s", value, grace)
+		}
+	}
+	return &MilestoneMonitor{DB: config.DB, Clock: SystemClock{}, GracePeriod: grace}
+}
+
+// Run scans milestones periodically. It blocks until ctx is cancelled.
+func (m *MilestoneMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(milestoneMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		if result, err := m.Scan(); err != nil {
+			log.Printf("milestone monitor: %v", err)
+		} else if result.Overdue > 0 || result.Proposals > 0 {
+			log.Printf("milestone monitor: %d milestones overdue, %d refunds proposed", result.Overdue, result.Proposals)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan checks every open milestone once against the clock
+func (m *MilestoneMonitor) Scan() (MonitorResult, error) {
+	var result MonitorResult
+	now := m.Clock.Now()
+
+	overdue, err := m.markOverdue(now)
+	result.Overdue = overdue
+	if err != nil {
+		return result, err
+	}
+
+	result.Proposals, err = m.proposeRefunds(now)
+	return result, err
+}
+
+// markOverdue moves pending milestones past their due date to overdue
+func (m *MilestoneMonitor) markOverdue(now time.Time) (int, error) {
+	var milestones []models.Milestone
+	err := m.DB.Preload("TransactionApproval").
+		Where("status = ? AND due_date > ? AND due_date <= ?", models.MilestoneStatusPending, time.Time{}, now).
+		Find(&milestones).Error
+	if err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for _, milestone := range milestones {
+		err := m.DB.Transaction(func(tx *gorm.DB) error {
+			err := statemachine.Milestones.Fire(tx, statemachine.Transition{
+				ID:      milestone.ID,
+				From:    milestone.Status,
+				To:      models.MilestoneStatusOverdue,
+				ActorID: statemachine.SystemActor,
+				Reason:  "Due date passed: " + milestone.DueDate.UTC().Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
+			}
+			return services.NotifyCharitySigners(tx, milestone.TransactionApproval.CharityID, "Milestone Overdue",
+				fmt.Sprintf("Milestone %q of transaction approval #%d was due on %s and is not completed",
+					milestone.Name, milestone.ApprovalID, milestone.DueDate.UTC().Format("2006-01-02")),
+				fmt.Sprintf("/charities/approvals/%d/milestones", milestone.ApprovalID))
+		})
+		switch {
+		case err == nil:
+			marked++
+		case errors.Is(err, statemachine.ErrStaleStatus):
+			// Completed or cancelled since it was loaded
+		default:
+			return marked, err
+		}
+	}
+	return marked, nil
+}
+
+// proposeRefunds opens a refund proposal for executed approvals with a milestone
+// that is still overdue after the grace period
+func (m *MilestoneMonitor) proposeRefunds(now time.Time) (int, error) {
+	var milestones []models.Milestone
+	err := m.DB.Preload("TransactionApproval").
+		Where("status = ? AND due_date <= ?", models.MilestoneStatusOverdue, now.Add(-m.GracePeriod)).
+		Where("approval_id NOT IN (?)", m.DB.Model(&models.RefundProposal{}).Select("approval_id")).
+		Order("due_date, id").
+		Find(&milestones).Error
+	if err != nil {
+		return 0, err
+	}
+
+	proposed := 0
+	seen := make(map[uint]bool)
+	for _, milestone := range milestones {
+		approval := milestone.TransactionApproval
+		if seen[approval.ID] || approval.Status != models.ApprovalStatusExecuted {
+			continue
+		}
+		seen[approval.ID] = true
+
+		summary, err := services.SummarizeMilestones(m.DB, &approval)
+		if err != nil {
+			return proposed, err
+		}
+		if !summary.Remaining.IsPositive() {
+			continue
+		}
+
+		proposal := models.RefundProposal{
+			ApprovalID:  approval.ID,
+			MilestoneID: milestone.ID,
+			Amount:      summary.Remaining,
+			Reason:      fmt.Sprintf("Milestone %q has been overdue since %s", milestone.Name, milestone.DueDate.UTC().Format("2006-01-02")),
+			Status:      models.RefundProposalOpen,
+		}
+		err = m.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&proposal).Error; err != nil {
+				return err
+			}
+			return services.NotifyCharitySigners(tx, approval.CharityID, "Refund Proposed",
+				fmt.Sprintf("A refund of %s %s from transaction approval #%d is proposed: %s",
+					proposal.Amount, approval.Asset.Normalized().AssetCode, approval.ID, proposal.Reason),
+				fmt.Sprintf("/charities/%d/refund-proposals", approval.CharityID))
+		})
+		switch {
+		case err == nil:
+			proposed++
+		case errors.Is(err, gorm.ErrDuplicatedKey):
+			// Proposed by another scan in the meantime
+		default:
+			return proposed, err
+		}
+	}
+	return proposed, nil
+}
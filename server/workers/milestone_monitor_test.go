@@ -0,0 +1,94 @@
+package workers
+
+import (
+	"cleargive/server/config"
+	"cleargive/server/models"
+	"testing"
+	"time"
+)
+
+// fixedClock is a Clock the test moves by hand
+type fixedClock struct{ now time.Time }
+
+func (c *fixedClock) Now() time.Time { return c.now }
+
+func TestMilestoneMonitorDetectsOverdueMilestones(t *testing.T) {
+	useTestDB(t)
+
+	owner := models.User{FirebaseID: "owner", Email: "owner@example.org"}
+	config.DB.Create(&owner)
+	charity := models.Charity{Name: "Charity", WalletAddress: "GWALLET", WalletSecret: "secret", OwnerID: owner.ID}
+	config.DB.Create(&charity)
+	approval := models.TransactionApproval{CharityID: charity.ID, Amount: 300, Asset: models.NativeAsset(), Status: models.ApprovalStatusExecuted}
+	config.DB.Create(&approval)
+
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 12, 0, 0, 0, time.UTC) }
+	milestone := func(name string, due time.Time, status string) models.Milestone {
+		m := models.Milestone{Name: name, ApprovalID: approval.ID, Amount: 100, Asset: models.NativeAsset(), DueDate: due, Status: status}
+		if err := config.DB.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	late := milestone("Late", day(1), models.MilestoneStatusPending)
+	upcoming := milestone("Upcoming", day(20), models.MilestoneStatusPending)
+	done := milestone("Done", day(1).AddDate(0, -1, 0), models.MilestoneStatusCompleted)
+
+	clock := &fixedClock{now: day(1).Add(-time.Second)}
+	monitor := &MilestoneMonitor{DB: config.DB, Clock: clock, GracePeriod: 14 * 24 * time.Hour}
+
+	scan := func(want MonitorResult) {
+		t.Helper()
+		result, err := monitor.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != want {
+			t.Fatalf("scan at %s: %+v, want %+v", clock.now.Format(time.RFC3339), result, want)
+		}
+	}
+
+	// Nothing is due a second before the first due date
+	scan(MonitorResult{})
+
+	// At the due date the late milestone becomes overdue, once
+	clock.now = day(1)
+	scan(MonitorResult{Overdue: 1})
+	scan(MonitorResult{})
+
+	// A refund is proposed only when the grace period is over, and only once
+	clock.now = day(15).Add(-time.Second)
+	scan(MonitorResult{})
+	clock.now = day(15)
+	scan(MonitorResult{Proposals: 1})
+	scan(MonitorResult{})
+
+	for _, want := range []struct {
+		milestone models.Milestone
+		status    string
+	}{
+		{late, models.MilestoneStatusOverdue},
+		{upcoming, models.MilestoneStatusPending},
+		{done, models.MilestoneStatusCompleted},
+	} {
+		var m models.Milestone
+		config.DB.First(&m, want.milestone.ID)
+		if m.Status != want.status {
+			t.Errorf("milestone %q: status %s, want %s", m.Name, m.Status, want.status)
+		}
+	}
+
+	var proposal models.RefundProposal
+	if err := config.DB.Where("approval_id = ?", approval.ID).First(&proposal).Error; err != nil {
+		t.Fatal(err)
+	}
+	if proposal.MilestoneID != late.ID || proposal.Amount != 300 {
+		t.Fatalf("proposal for milestone #%d of %s", proposal.MilestoneID, proposal.Amount)
+	}
+
+	var notifications int64
+	config.DB.Model(&models.Notification{}).Where("user_id = ?", owner.ID).Count(&notifications)
+	if notifications != 2 {
+		t.Fatalf("owner received %d notifications, want one overdue and one refund proposal", notifications)
+	}
+}